@@ -1,15 +1,27 @@
 package cmd
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/pstrobl96/prusa_exporter/config"
+	"github.com/pstrobl96/prusa_exporter/history"
+	"github.com/pstrobl96/prusa_exporter/influx"
+	"github.com/pstrobl96/prusa_exporter/mqtt"
+	"github.com/pstrobl96/prusa_exporter/notifier"
+	"github.com/pstrobl96/prusa_exporter/otlp"
 	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/pstrobl96/prusa_exporter/queue"
+	"github.com/pstrobl96/prusa_exporter/remotewrite"
+	"github.com/pstrobl96/prusa_exporter/server"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -18,7 +30,10 @@ var (
 	configFile             = kingpin.Flag("config.file", "Configuration file for prusa_exporter.").Default("./prusa.yml").ExistingFile()
 	metricsPath            = kingpin.Flag("exporter.metrics-path", "Path where to expose metrics.").Default("/metrics").String()
 	metricsPort            = kingpin.Flag("exporter.metrics-port", "Port where to expose metrics.").Default("10009").Int()
-	prusaLinkScrapeTimeout = kingpin.Flag("prusalink.scrape-timeout", "Timeout in seconds to scrape prusalink metrics.").Default("10").Int()
+	probePath              = kingpin.Flag("exporter.probe-path", "Path where to expose metrics of single printer given by target parameter.").Default("/probe").String()
+	sdPath                 = kingpin.Flag("exporter.sd-path", "Path where to expose printers for Prometheus HTTP service discovery.").Default("/sd").String()
+	prusaLinkScrapeTimeout = kingpin.Flag("prusalink.scrape-timeout", "Timeout in seconds of single request to the printer, it can be overridden per printer.").Default("50").Int()
+	legacyStatus           = kingpin.Flag("prusalink.legacy-status", "Export deprecated numeric prusa_status_info metric, use prusa_printer_state instead.").Default("false").Bool()
 	logLevel               = kingpin.Flag("log.level", "Log level for zerolog.").Default("info").String()
 )
 
@@ -28,11 +43,12 @@ func Run() {
 	log.Info().Msg("Prusa exporter starting")
 	log.Info().Msg("Loading configuration file: " + *configFile)
 
-	config, err := config.LoadConfig(*configFile, *prusaLinkScrapeTimeout)
+	config, err := config.LoadConfig(*configFile, time.Duration(*prusaLinkScrapeTimeout)*time.Second)
 	if err != nil {
 		log.Error().Msg("Error loading configuration file " + err.Error())
 		os.Exit(1)
 	}
+	config.Exporter.LegacyStatus = *legacyStatus
 
 	logLevel, err := zerolog.ParseLevel(*logLevel)
 
@@ -44,13 +60,191 @@ func Run() {
 
 	var collectors []prometheus.Collector
 
+	// closers are called in reverse order on exit, deferred calls would be skipped by os.Exit
+	var closers []func()
+	shutdown := func(code int) {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+		os.Exit(code)
+	}
+
+	poller := prusalink.NewPoller(config)
+	closers = append(closers, poller.Jobs.Save)
+
+	if config.Exporter.History.Enabled {
+		store, err := history.Open(config.Exporter.History.Path, time.Duration(config.Exporter.History.Retention))
+		if err != nil {
+			log.Error().Msg("Error opening print history " + err.Error())
+			shutdown(1)
+		}
+		closers = append(closers, func() {
+			if err := store.Close(); err != nil {
+				log.Error().Msg("Error closing print history " + err.Error())
+			}
+		})
+
+		poller.Jobs.Subscribe(store.HandleJobEvent)
+		http.Handle(server.HistoryPath, server.HistoryHandler(store))
+		log.Info().Msg("Print history enabled!")
+	}
+
+	if config.Exporter.Notifications.Enabled {
+		notifications := notifier.New(config)
+		poller.Jobs.Subscribe(notifications.HandleJobEvent)
+		poller.Subscribe(notifications.HandleSnapshot)
+		log.Info().Msg("Notifications enabled!")
+	}
+
+	if config.Exporter.MQTT.Enabled {
+		publisher := mqtt.New(config)
+		closers = append(closers, publisher.Close)
+
+		poller.Subscribe(publisher.HandleSnapshot)
+		log.Info().Msg("MQTT publisher enabled!")
+	}
+
+	if config.Exporter.InfluxDB.Enabled {
+		writer, err := influx.New(config)
+		if err != nil {
+			log.Error().Msg("Error creating InfluxDB writer " + err.Error())
+			shutdown(1)
+		}
+		closers = append(closers, writer.Flush)
+
+		poller.Subscribe(writer.HandleSnapshot)
+		writer.Start()
+		log.Info().Msg("InfluxDB output enabled!")
+	}
+
+	if config.Exporter.Queue.Enabled {
+		if len(config.Exporter.Queue.Tokens) == 0 {
+			log.Error().Msg("Print queue is enabled without tokens, it stays disabled")
+		} else {
+			scheduler, err := queue.New(config)
+			if err != nil {
+				log.Error().Msg("Error opening print queue " + err.Error())
+				shutdown(1)
+			}
+			closers = append(closers, func() {
+				if err := scheduler.Close(); err != nil {
+					log.Error().Msg("Error closing print queue " + err.Error())
+				}
+			})
+
+			poller.Subscribe(scheduler.HandleSnapshot)
+			collectors = append(collectors, scheduler)
+			http.Handle(server.QueuePath, server.QueueHandler(scheduler, config.Exporter.Queue.Tokens))
+			http.Handle(server.QueuePath+"/", server.QueueHandler(scheduler, config.Exporter.Queue.Tokens))
+			log.Info().Msg("Print queue enabled!")
+		}
+	}
+
+	broadcaster := server.NewBroadcaster()
+	poller.Subscribe(broadcaster.HandleSnapshot)
+
+	stream := server.NewStream(config.Printers)
+	poller.Subscribe(stream.HandleSnapshot)
+
+	poller.Start()
+
+	if config.Exporter.OTLP.Enabled {
+		exporter, err := otlp.New(config, poller)
+		if err != nil {
+			log.Error().Msg("Error creating OTLP exporter " + err.Error())
+			shutdown(1)
+		}
+		closers = append(closers, exporter.Shutdown)
+
+		exporter.Start()
+		log.Info().Msg("OTLP export enabled!")
+	}
+
 	log.Info().Msg("PrusaLink metrics enabled!")
-	collectors = append(collectors, prusalink.NewCollector(config))
+	collectors = append(collectors, prusalink.NewCollector(config, poller))
 
 	prometheus.MustRegister(collectors...)
 	log.Info().Msg("Metrics registered")
+
+	if config.Exporter.RemoteWrite.Enabled {
+		writer, err := remotewrite.New(config, prometheus.DefaultGatherer)
+		if err != nil {
+			log.Error().Msg("Error creating remote write WAL " + err.Error())
+			shutdown(1)
+		}
+
+		writer.Start()
+		closers = append(closers, writer.Stop)
+		log.Info().Msg("Remote write enabled!")
+	}
+
 	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(*probePath, server.ProbeHandler(config.Printers, poller))
+	http.Handle(*sdPath, server.SDHandler(config.Printers, *probePath))
+	http.Handle(server.PrintersPath, server.PrintersHandler(config.Printers, poller))
+	http.Handle(server.PrintersPath+"/", server.PrintersHandler(config.Printers, poller))
+	http.Handle("/", server.UIHandler(config.Printers, poller))
+	http.Handle(server.UIEventsPath, server.UIEventsHandler(broadcaster))
+	http.Handle(server.StreamPath, stream.Handler())
+
+	if config.Exporter.CameraProxy.Enabled {
+		if config.Exporter.CameraProxy.Token == "" {
+			log.Error().Msg("Camera proxy is enabled without token, it stays disabled")
+		} else {
+			// embed token is visible in URLs, so it must not be the same as the bearer token
+			embedToken := config.Exporter.CameraProxy.EmbedToken
+			if embedToken == config.Exporter.CameraProxy.Token {
+				log.Error().Msg("Camera proxy embed token is the same as token, it's ignored")
+				embedToken = ""
+			}
+
+			http.Handle(server.CameraPath, server.CameraHandler(config.Printers, config.Exporter.CameraProxy.Token, embedToken))
+			log.Info().Msg("Camera proxy enabled!")
+		}
+	}
+
+	if config.Exporter.Control.Enabled {
+		if len(config.Exporter.Control.Tokens) == 0 {
+			log.Error().Msg("Control is enabled without tokens, it stays disabled")
+		} else {
+			audit := server.NewAuditLog(config.Exporter.Control.AuditLog)
+			http.Handle(server.ControlPath, server.ControlHandler(config.Printers, config.Exporter.Control.Tokens, poller, audit))
+			log.Info().Msg("Control enabled!")
+		}
+	}
+
+	if config.Exporter.Upload.Enabled {
+		if len(config.Exporter.Upload.Tokens) == 0 {
+			log.Error().Msg("Upload is enabled without tokens, it stays disabled")
+		} else {
+			uploads := server.NewUploads(config.Printers, config.Exporter.Upload.Tokens)
+			http.Handle(server.FilesPath, uploads.Handler())
+			http.Handle(server.UploadsPath, uploads.ProgressHandler())
+			log.Info().Msg("Upload enabled!")
+		}
+	}
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{Addr: ":" + strconv.Itoa(*metricsPort)}
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
 	log.Info().Msg("Listening at port: " + strconv.Itoa(*metricsPort))
-	log.Fatal().Msg(http.ListenAndServe(":"+strconv.Itoa(*metricsPort), nil).Error())
 
+	select {
+	case <-signals.Done():
+		log.Info().Msg("Prusa exporter shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Error().Msg("Error while shutting down HTTP server " + err.Error())
+		}
+		cancel()
+		shutdown(0)
+	case err := <-errs:
+		log.Error().Msg("Error while listening at port " + strconv.Itoa(*metricsPort) + " - " + err.Error())
+		shutdown(1)
+	}
 }
@@ -2,21 +2,147 @@ package config
 
 import (
 	"os"
+	"time"
 
+	"github.com/prometheus/common/model"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 // Config struct for the configuration file prusa.yml
 type Config struct {
 	Exporter struct {
-		ScrapeTimeout int `yaml:"scrape_timeout"`
+		ScrapeTimeout model.Duration `yaml:"-"` // set by --prusalink.scrape-timeout flag, timeout of single request to the printer
+		PollInterval  model.Duration `yaml:"poll_interval"`
+		StateFile     string         `yaml:"state_file"` // job counters and utilization, default is state.json
 
-		LogLevel string `yaml:"log_level"`
+		Refresh struct {
+			Metadata model.Duration `yaml:"metadata"` // /api/version, /api/v1/info, /api/v1/storage and /api/v1/cameras
+			Files    model.Duration `yaml:"files"`    // /api/files
+		} `yaml:"refresh"` // refresh classes of slow changing endpoints, telemetry and job endpoints are polled every poll interval
+
+		LogLevel     string `yaml:"log_level"`
+		LegacyStatus bool   `yaml:"-"` // set by --prusalink.legacy-status flag
+
+		History struct {
+			Enabled   bool           `yaml:"enabled"`
+			Path      string         `yaml:"path"`
+			Retention model.Duration `yaml:"retention"` // zero keeps history forever
+		} `yaml:"history"`
+
+		Utilization struct {
+			Windows []model.Duration `yaml:"windows"` // rolling windows of utilization, availability and success rate
+		} `yaml:"utilization"`
+
+		Filament struct {
+			Diameter  float64             `yaml:"diameter"` // in millimeters
+			Currency  string              `yaml:"currency"`
+			Materials map[string]Material `yaml:"materials"`
+		} `yaml:"filament"`
+
+		Notifications struct {
+			Enabled      bool           `yaml:"enabled"`
+			RateLimit    model.Duration `yaml:"rate_limit"`    // minimum time between notifications of the same event and printer
+			Retries      int            `yaml:"retries"`       // 0 disables retries, default is 3
+			OfflineAfter int            `yaml:"offline_after"` // consecutive failed polls before printer is reported offline
+			Receivers    []Receiver     `yaml:"receivers"`
+		} `yaml:"notifications"`
+
+		MQTT struct {
+			Enabled     bool   `yaml:"enabled"`
+			Broker      string `yaml:"broker"` // tcp://host:1883, ssl://host:8883 or ws://host:80
+			ClientID    string `yaml:"client_id"`
+			Username    string `yaml:"username,omitempty"`
+			Password    string `yaml:"password,omitempty"`
+			TopicPrefix string `yaml:"topic_prefix"`
+			QoS         byte   `yaml:"qos"`
+
+			HomeAssistant struct {
+				Enabled         bool   `yaml:"enabled"`
+				DiscoveryPrefix string `yaml:"discovery_prefix"`
+			} `yaml:"home_assistant"`
+		} `yaml:"mqtt"`
+
+		OTLP struct {
+			Enabled  bool              `yaml:"enabled"`
+			Protocol string            `yaml:"protocol"` // grpc or http
+			Endpoint string            `yaml:"endpoint"` // URL of OTLP receiver, http scheme disables TLS
+			Headers  map[string]string `yaml:"headers,omitempty"`
+			Interval model.Duration    `yaml:"interval"`
+		} `yaml:"otlp"`
+
+		RemoteWrite struct {
+			Enabled        bool              `yaml:"enabled"`
+			URL            string            `yaml:"url"`
+			Interval       model.Duration    `yaml:"interval"`
+			Username       string            `yaml:"username,omitempty"` // basic authentication
+			Password       string            `yaml:"password,omitempty"`
+			BearerToken    string            `yaml:"bearer_token,omitempty"`
+			ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+			WALDir         string            `yaml:"wal_dir"`
+			WALRetention   model.Duration    `yaml:"wal_retention"` // requests older than retention are dropped when endpoint is unreachable
+		} `yaml:"remote_write"`
+
+		InfluxDB struct {
+			Enabled       bool           `yaml:"enabled"`
+			URL           string         `yaml:"url"` // http(s)://host:8086 for InfluxDB v2 API or udp://host:8089
+			Org           string         `yaml:"org"`
+			Bucket        string         `yaml:"bucket"`
+			Token         string         `yaml:"token,omitempty"`
+			BatchSize     int            `yaml:"batch_size"`
+			FlushInterval model.Duration `yaml:"flush_interval"`
+		} `yaml:"influxdb"`
+
+		Control struct {
+			Enabled  bool              `yaml:"enabled"`
+			Tokens   map[string]string `yaml:"tokens"`    // user name to token, user is recorded in audit log
+			AuditLog string            `yaml:"audit_log"` // JSON lines file with every control action
+		} `yaml:"control"`
+
+		Upload struct {
+			Enabled bool              `yaml:"enabled"`
+			Tokens  map[string]string `yaml:"tokens"` // user name to token
+		} `yaml:"upload"`
+
+		Queue struct {
+			Enabled        bool              `yaml:"enabled"`
+			Path           string            `yaml:"path"`            // queue database file
+			Dir            string            `yaml:"dir"`             // directory of queued gcodes
+			Storage        string            `yaml:"storage"`         // printer storage jobs are uploaded to
+			Retries        int               `yaml:"retries"`         // dispatch retries after the first failed attempt, 0 fails job after the first failed attempt
+			Retention      model.Duration    `yaml:"retention"`       // dispatched, failed and cancelled jobs are removed after retention
+			DispatchStates []string          `yaml:"dispatch_states"` // printer states jobs are dispatched in
+			Tokens         map[string]string `yaml:"tokens"`          // user name to token
+		} `yaml:"queue"`
+
+		CameraProxy struct {
+			Enabled    bool   `yaml:"enabled"`
+			Token      string `yaml:"token,omitempty"`
+			EmbedToken string `yaml:"embed_token,omitempty"` // accepted in token query parameter, so images can be embedded without headers
+		} `yaml:"camera_proxy"`
 	} `yaml:"exporter"`
 	Printers []Printers `yaml:"printers"`
 }
 
+// Material struct containing density in g/cm3 and price per kilogram of the filament
+type Material struct {
+	Density float64 `yaml:"density"`
+	Price   float64 `yaml:"price"`
+}
+
+// Receiver struct containing the notification receiver configuration
+type Receiver struct {
+	Name     string            `yaml:"name"`
+	Type     string            `yaml:"type"` // webhook, slack, discord, teams or ntfy
+	URL      string            `yaml:"url"`
+	Token    string            `yaml:"token,omitempty"` // sent as bearer token
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Printers []string          `yaml:"printers,omitempty"` // names or addresses, empty means all printers
+	Events   []string          `yaml:"events,omitempty"`   // empty means all events
+	Template string            `yaml:"template,omitempty"` // text/template of the message
+}
+
 // Printers struct containing the printer configuration
 type Printers struct {
 	Address   string `yaml:"address"`
@@ -25,11 +151,15 @@ type Printers struct {
 	Apikey    string `yaml:"apikey,omitempty"`
 	Name      string `yaml:"name,omitempty"`
 	Type      string `yaml:"type,omitempty"`
+	Location  string `yaml:"location,omitempty"`
 	Reachable bool
+
+	PollInterval  model.Duration `yaml:"poll_interval,omitempty"`  // overrides exporter poll interval
+	ScrapeTimeout model.Duration `yaml:"scrape_timeout,omitempty"` // overrides --prusalink.scrape-timeout
 }
 
 // LoadConfig function to load and parse the configuration file
-func LoadConfig(path string, prusaLinkScrapeTimeout int) (Config, error) {
+func LoadConfig(path string, prusaLinkScrapeTimeout time.Duration) (Config, error) {
 	var config Config
 	file, err := os.ReadFile(path)
 
@@ -37,10 +167,139 @@ func LoadConfig(path string, prusaLinkScrapeTimeout int) (Config, error) {
 		return config, err
 	}
 
+	// negative value marks retries that are not set, 0 is valid and disables retries
+	config.Exporter.Notifications.Retries = -1
+	config.Exporter.Queue.Retries = -1
+
 	if err := yaml.Unmarshal(file, &config); err != nil {
 		return config, err
 	}
-	config.Exporter.ScrapeTimeout = prusaLinkScrapeTimeout
+	config.Exporter.ScrapeTimeout = model.Duration(prusaLinkScrapeTimeout)
+
+	// exporter.scrape_timeout is deprecated, the flag and per printer scrape_timeout are used instead
+	var deprecated struct {
+		Exporter struct {
+			ScrapeTimeout any `yaml:"scrape_timeout"`
+		} `yaml:"exporter"`
+	}
+
+	if yaml.Unmarshal(file, &deprecated) == nil && deprecated.Exporter.ScrapeTimeout != nil {
+		log.Warn().Msg("exporter.scrape_timeout is deprecated and ignored, use --prusalink.scrape-timeout flag or scrape_timeout of the printer")
+	}
+
+	if config.Exporter.PollInterval == 0 {
+		config.Exporter.PollInterval = model.Duration(10 * time.Second)
+	}
+
+	if config.Exporter.Refresh.Metadata == 0 {
+		config.Exporter.Refresh.Metadata = model.Duration(5 * time.Minute)
+	}
+
+	if config.Exporter.Refresh.Files == 0 {
+		config.Exporter.Refresh.Files = model.Duration(time.Hour)
+	}
+
+	// without state file every restart would count running jobs as started again
+	if config.Exporter.StateFile == "" {
+		config.Exporter.StateFile = "state.json"
+	}
+
+	if len(config.Exporter.Utilization.Windows) == 0 {
+		config.Exporter.Utilization.Windows = []model.Duration{
+			model.Duration(time.Hour),
+			model.Duration(24 * time.Hour),
+			model.Duration(7 * 24 * time.Hour),
+		}
+	}
+
+	if config.Exporter.Filament.Diameter == 0 {
+		config.Exporter.Filament.Diameter = 1.75
+	}
+
+	if config.Exporter.Notifications.RateLimit == 0 {
+		config.Exporter.Notifications.RateLimit = model.Duration(time.Minute)
+	}
+
+	if config.Exporter.Notifications.Retries < 0 {
+		config.Exporter.Notifications.Retries = 3
+	}
+
+	if config.Exporter.Notifications.OfflineAfter <= 0 {
+		config.Exporter.Notifications.OfflineAfter = 3
+	}
+
+	if config.Exporter.MQTT.ClientID == "" {
+		config.Exporter.MQTT.ClientID = "prusa_exporter"
+	}
+
+	if config.Exporter.MQTT.TopicPrefix == "" {
+		config.Exporter.MQTT.TopicPrefix = "prusa"
+	}
+
+	if config.Exporter.MQTT.HomeAssistant.DiscoveryPrefix == "" {
+		config.Exporter.MQTT.HomeAssistant.DiscoveryPrefix = "homeassistant"
+	}
+
+	if config.Exporter.OTLP.Protocol == "" {
+		config.Exporter.OTLP.Protocol = "grpc"
+	}
+
+	if config.Exporter.OTLP.Interval == 0 {
+		config.Exporter.OTLP.Interval = model.Duration(30 * time.Second)
+	}
+
+	if config.Exporter.RemoteWrite.Interval == 0 {
+		config.Exporter.RemoteWrite.Interval = model.Duration(30 * time.Second)
+	}
+
+	if config.Exporter.RemoteWrite.WALDir == "" {
+		config.Exporter.RemoteWrite.WALDir = "remote_write_wal"
+	}
+
+	if config.Exporter.RemoteWrite.WALRetention == 0 {
+		config.Exporter.RemoteWrite.WALRetention = model.Duration(24 * time.Hour)
+	}
+
+	if config.Exporter.InfluxDB.BatchSize == 0 {
+		config.Exporter.InfluxDB.BatchSize = 1000
+	}
+
+	if config.Exporter.InfluxDB.FlushInterval == 0 {
+		config.Exporter.InfluxDB.FlushInterval = model.Duration(10 * time.Second)
+	}
+
+	if config.Exporter.History.Path == "" {
+		config.Exporter.History.Path = "history.db"
+	}
+
+	if config.Exporter.Control.AuditLog == "" {
+		config.Exporter.Control.AuditLog = "control_audit.log"
+	}
+
+	if config.Exporter.Queue.Path == "" {
+		config.Exporter.Queue.Path = "queue.db"
+	}
+
+	if config.Exporter.Queue.Dir == "" {
+		config.Exporter.Queue.Dir = "queue"
+	}
+
+	if config.Exporter.Queue.Storage == "" {
+		config.Exporter.Queue.Storage = "usb"
+	}
+
+	if config.Exporter.Queue.Retries < 0 {
+		config.Exporter.Queue.Retries = 3
+	}
+
+	if config.Exporter.Queue.Retention == 0 {
+		config.Exporter.Queue.Retention = model.Duration(7 * 24 * time.Hour)
+	}
+
+	// IDLE is not included, printer reports it also when the previous print was not removed, READY is set only when printer is marked ready
+	if len(config.Exporter.Queue.DispatchStates) == 0 {
+		config.Exporter.Queue.DispatchStates = []string{"READY"}
+	}
 
 	return config, err
 }
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// loadConfig writes the YAML to temporary file and loads it
+func loadConfig(t *testing.T, data string) Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "prusa.yml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestNotificationRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		retries int
+	}{
+		{"default", "exporter:\n  notifications:\n    enabled: true\n", 3},
+		{"disabled", "exporter:\n  notifications:\n    retries: 0\n", 0},
+		{"configured", "exporter:\n  notifications:\n    retries: 5\n", 5},
+		{"missing exporter section", "printers: []\n", 3},
+	}
+
+	for _, test := range tests {
+		if retries := loadConfig(t, test.data).Exporter.Notifications.Retries; retries != test.retries {
+			t.Errorf("%s: retries = %d, expected %d", test.name, retries, test.retries)
+		}
+	}
+}
+
+func TestNotificationOfflineAfter(t *testing.T) {
+	if offlineAfter := loadConfig(t, "printers: []\n").Exporter.Notifications.OfflineAfter; offlineAfter != 3 {
+		t.Errorf("offline_after = %d, expected default 3", offlineAfter)
+	}
+
+	if offlineAfter := loadConfig(t, "exporter:\n  notifications:\n    offline_after: 1\n").Exporter.Notifications.OfflineAfter; offlineAfter != 1 {
+		t.Errorf("offline_after = %d, expected 1", offlineAfter)
+	}
+}
+
+func TestQueueDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		retries   int
+		retention time.Duration
+		states    []string
+	}{
+		{"default", "exporter:\n  queue:\n    enabled: true\n", 3, 7 * 24 * time.Hour, []string{"READY"}},
+		{"retries disabled", "exporter:\n  queue:\n    retries: 0\n", 0, 7 * 24 * time.Hour, []string{"READY"}},
+		{"configured", "exporter:\n  queue:\n    retries: 5\n    retention: 1d\n    dispatch_states: [READY, IDLE]\n", 5, 24 * time.Hour, []string{"READY", "IDLE"}},
+	}
+
+	for _, test := range tests {
+		queue := loadConfig(t, test.data).Exporter.Queue
+
+		if queue.Retries != test.retries || time.Duration(queue.Retention) != test.retention || !slices.Equal(queue.DispatchStates, test.states) {
+			t.Errorf("%s: retries = %d, retention = %v, dispatch states = %v, expected %d, %v, %v", test.name, queue.Retries, queue.Retention, queue.DispatchStates, test.retries, test.retention, test.states)
+		}
+	}
+}
+
+func TestDeprecatedScrapeTimeout(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&output)
+	defer func() { log.Logger = logger }()
+
+	if timeout := loadConfig(t, "exporter:\n  poll_interval: 10s\n").Exporter.ScrapeTimeout; time.Duration(timeout) != 10*time.Second || output.Len() != 0 {
+		t.Errorf("scrape timeout = %v with log %q, expected flag value without warning", timeout, output.String())
+	}
+
+	if timeout := loadConfig(t, "exporter:\n  scrape_timeout: 1000\n").Exporter.ScrapeTimeout; time.Duration(timeout) != 10*time.Second {
+		t.Errorf("scrape timeout = %v, expected flag value", timeout)
+	}
+
+	if !strings.Contains(output.String(), "exporter.scrape_timeout is deprecated") {
+		t.Errorf("log = %q, expected deprecation warning", output.String())
+	}
+}
+
+func TestStateFileDefault(t *testing.T) {
+	if stateFile := loadConfig(t, "printers: []\n").Exporter.StateFile; stateFile != "state.json" {
+		t.Errorf("state_file = %q, expected default state.json", stateFile)
+	}
+
+	if stateFile := loadConfig(t, "exporter:\n  state_file: /app/state.json\n").Exporter.StateFile; stateFile != "/app/state.json" {
+		t.Errorf("state_file = %q, expected /app/state.json", stateFile)
+	}
+}
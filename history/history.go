@@ -0,0 +1,215 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var recordsBucket = []byte("records")
+
+// Record is a struct that contains data about single finished print
+type Record struct {
+	Printer                   string    `json:"printer"`
+	PrinterAddress            string    `json:"printer_address"`
+	PrinterModel              string    `json:"printer_model"`
+	JobID                     float64   `json:"job_id"`
+	File                      string    `json:"file"`
+	Path                      string    `json:"path"`
+	Start                     time.Time `json:"start"`
+	End                       time.Time `json:"end"`
+	DurationSeconds           float64   `json:"duration_seconds"`   // wall clock time including pauses
+	PrintTimeSeconds          float64   `json:"print_time_seconds"` // time spent printing reported by printer
+	EstimatedPrintTimeSeconds float64   `json:"estimated_print_time_seconds"`
+	Outcome                   string    `json:"outcome"`
+	FilamentType              string    `json:"filament_type"`
+	FilamentGrams             float64   `json:"filament_grams"`
+	FilamentMeters            float64   `json:"filament_meters"`
+	FilamentCost              float64   `json:"filament_cost"`
+	Thumbnail                 string    `json:"thumbnail,omitempty"` // base64 encoded PNG
+}
+
+// Filter is a struct that contains conditions of history query, zero values match everything
+type Filter struct {
+	Printer string // name or address of the printer
+	From    time.Time
+	To      time.Time
+}
+
+// Store is an embedded database of finished prints
+type Store struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// Open opens or creates history database file, records older than retention are removed, zero retention keeps everything
+func Open(path string, retention time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordsBucket)
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store := &Store{db: db, retention: retention}
+
+	return store, store.prune()
+}
+
+// Close closes the database
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// Add stores the record, records are ordered by end of the print
+func (store *Store) Add(record Record) error {
+	value, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put(recordKey(record), value)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return store.prune()
+}
+
+// Query returns records matching the filter ordered by end of the print
+func (store *Store) Query(filter Filter) ([]Record, error) {
+	records := []Record{}
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(recordsBucket).Cursor()
+
+		for key, value := cursor.Seek(timeKey(filter.From)); key != nil; key, value = cursor.Next() {
+			var record Record
+
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+
+			if !filter.To.IsZero() && record.End.After(filter.To) {
+				break
+			}
+
+			if filter.Printer != "" && filter.Printer != record.Printer && filter.Printer != record.PrinterAddress {
+				continue
+			}
+
+			records = append(records, record)
+		}
+
+		return nil
+	})
+
+	return records, err
+}
+
+// HandleJobEvent stores finished jobs, it's meant to be subscribed to the job tracker
+func (store *Store) HandleJobEvent(event prusalink.JobEvent) {
+	if event.Type != prusalink.JobCompleted && event.Type != prusalink.JobCancelled && event.Type != prusalink.JobFailed {
+		return
+	}
+
+	if err := store.Add(NewRecord(event)); err != nil {
+		log.Error().Msg("Error while storing print history of " + event.Snapshot.Config.Address + " - " + err.Error())
+	}
+}
+
+// NewRecord returns history record of the finished job
+func NewRecord(event prusalink.JobEvent) Record {
+	printer := event.Snapshot.Config
+	file := event.Job.File.DisplayName
+
+	if file == "" {
+		file = event.Job.File.Name
+	}
+
+	record := Record{
+		Printer:                   printer.Name,
+		PrinterAddress:            printer.Address,
+		PrinterModel:              printer.Type,
+		JobID:                     event.JobID,
+		File:                      file,
+		Path:                      event.Job.File.Path,
+		Start:                     event.Started,
+		End:                       event.Snapshot.Time,
+		DurationSeconds:           event.Snapshot.Time.Sub(event.Started).Seconds(),
+		PrintTimeSeconds:          event.Duration.Seconds(),
+		EstimatedPrintTimeSeconds: event.Job.File.Meta.EstimatedPrintTime,
+		Outcome:                   event.Type,
+		FilamentType:              event.Job.File.Meta.FilamentType,
+		Thumbnail:                 event.Thumbnail,
+	}
+
+	for _, filament := range event.Filament {
+		record.FilamentGrams += filament.Grams
+		record.FilamentMeters += filament.Meters
+		record.FilamentCost += filament.Cost
+	}
+
+	return record
+}
+
+// prune removes records older than retention
+func (store *Store) prune() error {
+	if store.retention == 0 {
+		return nil
+	}
+
+	limit := timeKey(time.Now().Add(-store.retention))
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket)
+		var expired [][]byte
+
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key, limit) < 0; key, _ = cursor.Next() {
+			expired = append(expired, append([]byte{}, key...))
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// recordKey returns key of the record - end of the print followed by printer address, so keys are sorted by time
+func recordKey(record Record) []byte {
+	return append(timeKey(record.End), []byte(record.PrinterAddress)...)
+}
+
+// timeKey returns big endian encoded unix time in nanoseconds, zero time is the lowest key
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+
+	return key
+}
@@ -0,0 +1,193 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// openStore opens store in temporary directory, it's closed with the test
+func openStore(t *testing.T, path string, retention time.Duration) *Store {
+	t.Helper()
+
+	store, err := Open(path, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// files returns file names of the records in order
+func files(records []Record) []string {
+	names := []string{}
+	for _, record := range records {
+		names = append(names, record.File)
+	}
+	return names
+}
+
+func TestRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	now := time.Now()
+
+	store, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Add(Record{PrinterAddress: "192.168.1.10", File: "old.gcode", End: now.Add(-48 * time.Hour)})
+	store.Add(Record{PrinterAddress: "192.168.1.10", File: "new.gcode", End: now.Add(-time.Hour)})
+	store.Close()
+
+	// records older than retention are removed on open
+	store = openStore(t, path, 24*time.Hour)
+
+	records, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := files(records); len(names) != 1 || names[0] != "new.gcode" {
+		t.Errorf("records after open = %v, expected only new.gcode", names)
+	}
+
+	// and on every add
+	store.Add(Record{PrinterAddress: "192.168.1.10", File: "late.gcode", End: now.Add(-25 * time.Hour)})
+
+	records, _ = store.Query(Filter{})
+	if names := files(records); len(names) != 1 || names[0] != "new.gcode" {
+		t.Errorf("records after add = %v, expected only new.gcode", names)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "history.db"), 0)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	records := []Record{
+		{Printer: "xl", PrinterAddress: "192.168.1.10", File: "a.gcode", End: start},
+		{Printer: "mk4", PrinterAddress: "192.168.1.11", File: "b.gcode", End: start.Add(time.Hour)},
+		{Printer: "xl", PrinterAddress: "192.168.1.10", File: "c.gcode", End: start.Add(2 * time.Hour)},
+		// finished at the same time on other printer, both are kept
+		{Printer: "mk4", PrinterAddress: "192.168.1.11", File: "d.gcode", End: start.Add(2 * time.Hour)},
+	}
+
+	// added out of order, query returns them ordered by end
+	for _, i := range []int{3, 0, 2, 1} {
+		if err := store.Add(records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		files  []string
+	}{
+		{"everything", Filter{}, []string{"a.gcode", "b.gcode", "c.gcode", "d.gcode"}},
+		{"printer by name", Filter{Printer: "xl"}, []string{"a.gcode", "c.gcode"}},
+		{"printer by address", Filter{Printer: "192.168.1.11"}, []string{"b.gcode", "d.gcode"}},
+		{"unknown printer", Filter{Printer: "mini"}, []string{}},
+		{"from is inclusive", Filter{From: start.Add(time.Hour)}, []string{"b.gcode", "c.gcode", "d.gcode"}},
+		{"to is inclusive", Filter{To: start.Add(time.Hour)}, []string{"a.gcode", "b.gcode"}},
+		{"from and to", Filter{From: start.Add(30 * time.Minute), To: start.Add(90 * time.Minute)}, []string{"b.gcode"}},
+		{"printer and time", Filter{Printer: "mk4", From: start.Add(90 * time.Minute)}, []string{"d.gcode"}},
+	}
+
+	for _, test := range tests {
+		records, err := store.Query(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := files(records)
+		if len(names) != len(test.files) {
+			t.Errorf("%s: Query() = %v, expected %v", test.name, names, test.files)
+			continue
+		}
+
+		for i := range names {
+			if names[i] != test.files[i] {
+				t.Errorf("%s: Query() = %v, expected %v", test.name, names, test.files)
+				break
+			}
+		}
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	started := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	event := prusalink.JobEvent{
+		Type:      prusalink.JobCancelled,
+		JobID:     42,
+		Started:   started,
+		Duration:  90 * time.Minute,
+		Thumbnail: "iVBORw0KGgo=",
+		Filament: map[string]prusalink.FilamentCounters{
+			"PLA":  {Grams: 10, Meters: 3.3, Cost: 0.25},
+			"PETG": {Grams: 5, Meters: 1.6, Cost: 0.15},
+		},
+	}
+	event.Snapshot.Config = config.Printers{Address: "192.168.1.10", Name: "xl", Type: "XL"}
+	event.Snapshot.Time = started.Add(2 * time.Hour)
+	event.Job.File.Name = "BENCHY~1.BGC"
+	event.Job.File.Path = "/usb"
+	event.Job.File.Meta.EstimatedPrintTime = 5400
+	event.Job.File.Meta.FilamentType = "PLA;PETG"
+
+	record := NewRecord(event)
+
+	expected := Record{
+		Printer:                   "xl",
+		PrinterAddress:            "192.168.1.10",
+		PrinterModel:              "XL",
+		JobID:                     42,
+		File:                      "BENCHY~1.BGC", // display name is missing
+		Path:                      "/usb",
+		Start:                     started,
+		End:                       started.Add(2 * time.Hour),
+		DurationSeconds:           7200,
+		PrintTimeSeconds:          5400,
+		EstimatedPrintTimeSeconds: 5400,
+		Outcome:                   prusalink.JobCancelled,
+		FilamentType:              "PLA;PETG",
+		FilamentGrams:             15,
+		FilamentMeters:            3.3 + 1.6,
+		FilamentCost:              0.25 + 0.15,
+		Thumbnail:                 "iVBORw0KGgo=",
+	}
+
+	if record != expected {
+		t.Errorf("NewRecord() = %+v, expected %+v", record, expected)
+	}
+
+	event.Job.File.DisplayName = "benchy.bgcode"
+	if record := NewRecord(event); record.File != "benchy.bgcode" {
+		t.Errorf("file = %q, expected display name", record.File)
+	}
+}
+
+func TestHandleJobEvent(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "history.db"), 0)
+
+	for _, eventType := range []string{prusalink.JobStarted, prusalink.JobPaused, prusalink.JobResumed, prusalink.JobCompleted, prusalink.JobCancelled, prusalink.JobFailed} {
+		event := prusalink.JobEvent{Type: eventType}
+		event.Snapshot.Config.Address = "192.168.1.10"
+		event.Snapshot.Time = time.Now()
+		event.Job.File.Name = eventType
+		store.HandleJobEvent(event)
+	}
+
+	records, _ := store.Query(Filter{})
+	names := files(records)
+
+	if len(names) != 3 || names[0] != prusalink.JobCompleted || names[1] != prusalink.JobCancelled || names[2] != prusalink.JobFailed {
+		t.Errorf("stored events = %v, expected only finished jobs", names)
+	}
+}
@@ -0,0 +1,295 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// maxPacketSize is maximum size of UDP packet, larger batches are split by lines
+const maxPacketSize = 1400
+
+// maxBufferedBatches limits lines kept for retry while InfluxDB is down, the oldest lines are dropped first
+const maxBufferedBatches = 10
+
+// Writer writes printer snapshots to InfluxDB in line protocol, lines are sent in batches
+type Writer struct {
+	url           *url.URL
+	org           string
+	bucket        string
+	token         string
+	batchSize     int
+	flushInterval time.Duration
+	client        *http.Client
+	lines         []string
+	failing       bool // the last HTTP write failed, batches are retried by flush interval only
+	mutex         sync.Mutex
+}
+
+// New returns a new Writer, HTTP is used for http and https URLs, UDP for udp URL
+func New(config config.Config) (*Writer, error) {
+	settings := config.Exporter.InfluxDB
+
+	address, err := url.Parse(settings.URL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if address.Scheme != "http" && address.Scheme != "https" && address.Scheme != "udp" {
+		return nil, fmt.Errorf("unsupported InfluxDB URL scheme %s", address.Scheme)
+	}
+
+	return &Writer{
+		url:           address,
+		org:           settings.Org,
+		bucket:        settings.Bucket,
+		token:         settings.Token,
+		batchSize:     settings.BatchSize,
+		flushInterval: time.Duration(settings.FlushInterval),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Start starts periodic flushing of batched lines in background
+func (writer *Writer) Start() {
+	go func() {
+		ticker := time.NewTicker(writer.flushInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			writer.Flush()
+		}
+	}()
+}
+
+// HandleSnapshot adds points of the snapshot to the batch, it's meant to be subscribed to the poller
+func (writer *Writer) HandleSnapshot(_ prusalink.Snapshot, snapshot prusalink.Snapshot) {
+	points := getPoints(snapshot)
+
+	writer.mutex.Lock()
+	for _, point := range points {
+		writer.lines = append(writer.lines, point.encode())
+	}
+	full := len(writer.lines) >= writer.batchSize && !writer.failing
+	writer.mutex.Unlock()
+
+	if full {
+		go writer.Flush()
+	}
+}
+
+// Flush sends all batched lines, lines of failed HTTP write are kept for the next flush when the error is temporary
+func (writer *Writer) Flush() {
+	writer.mutex.Lock()
+	lines := writer.lines
+	writer.lines = nil
+	writer.mutex.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+
+	if writer.url.Scheme == "udp" {
+		if err := writer.sendUDP(lines); err != nil {
+			log.Error().Msg("Error while writing " + strconv.Itoa(len(lines)) + " lines to InfluxDB " + writer.url.Host + " - " + err.Error())
+		}
+		return
+	}
+
+	retry, err := writer.sendHTTP(lines)
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.failing = err != nil && retry
+
+	if err == nil {
+		return
+	}
+
+	if !retry {
+		log.Error().Msg("Error while writing " + strconv.Itoa(len(lines)) + " lines to InfluxDB " + writer.url.Host + ", lines are dropped - " + err.Error())
+		return
+	}
+
+	log.Error().Msg("Error while writing " + strconv.Itoa(len(lines)) + " lines to InfluxDB " + writer.url.Host + ", lines are retried - " + err.Error())
+
+	writer.lines = append(lines, writer.lines...)
+
+	if limit := writer.batchSize * maxBufferedBatches; len(writer.lines) > limit {
+		log.Warn().Msg("Dropping " + strconv.Itoa(len(writer.lines)-limit) + " oldest lines for InfluxDB " + writer.url.Host)
+		writer.lines = writer.lines[len(writer.lines)-limit:]
+	}
+}
+
+// sendHTTP sends lines to InfluxDB v2 write API and reports whether failed write should be retried
+func (writer *Writer) sendHTTP(lines []string) (bool, error) {
+	query := url.Values{}
+	query.Set("org", writer.org)
+	query.Set("bucket", writer.bucket)
+	query.Set("precision", "ns")
+
+	endpoint := *writer.url
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/api/v2/write"
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(strings.Join(lines, "\n")))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if writer.token != "" {
+		req.Header.Set("Authorization", "Token "+writer.token)
+	}
+
+	res, err := writer.client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("InfluxDB returned status %d - %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return false, nil
+}
+
+// sendUDP sends lines as UDP packets, lines are never split between packets
+func (writer *Writer) sendUDP(lines []string) error {
+	conn, err := net.Dial("udp", writer.url.Host)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	var packet bytes.Buffer
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > maxPacketSize {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+
+		packet.WriteString(line + "\n")
+	}
+
+	_, err = conn.Write(packet.Bytes())
+
+	return err
+}
+
+// getPoints returns points of the snapshot - printer state, current job and every tool, tags are the same as labels of Prometheus metrics
+func getPoints(snapshot prusalink.Snapshot) []point {
+	tags := map[string]string{}
+	for i, value := range prusalink.GetLabels(snapshot.Config, snapshot.Job) {
+		tags[tagLabels[i]] = value
+	}
+
+	summary := prusalink.GetSummary(snapshot)
+
+	printer := point{
+		measurement: "prusa_printer",
+		tags:        tags,
+		fields: map[string]any{
+			"up":    summary.Online,
+			"state": summary.State,
+		},
+		time: snapshot.Time,
+	}
+
+	if !summary.Online {
+		return []point{printer}
+	}
+
+	printer.fields["nozzle_temperature"] = summary.Temperature.Nozzle
+	printer.fields["nozzle_target_temperature"] = summary.Temperature.NozzleTarget
+	printer.fields["bed_temperature"] = summary.Temperature.Bed
+	printer.fields["bed_target_temperature"] = summary.Temperature.BedTarget
+	printer.fields["fan_hotend"] = summary.Fans.Hotend
+	printer.fields["fan_print"] = summary.Fans.Print
+	printer.fields["axis_x"] = summary.Axes.X
+	printer.fields["axis_y"] = summary.Axes.Y
+	printer.fields["axis_z"] = summary.Axes.Z
+	printer.fields["print_speed"] = snapshot.Status.Printer.Speed
+	printer.fields["flow"] = snapshot.Status.Printer.Flow
+
+	if summary.Material != "" {
+		printer.fields["material"] = summary.Material
+	}
+
+	if summary.Temperature.Chamber != nil {
+		printer.fields["chamber_temperature"] = *summary.Temperature.Chamber
+		printer.fields["chamber_target_temperature"] = *summary.Temperature.ChamberTarget
+	}
+
+	if summary.Fans.Chamber != nil {
+		printer.fields["fan_chamber"] = *summary.Fans.Chamber
+	}
+
+	points := []point{printer}
+
+	if job := summary.Job; job != nil {
+		points = append(points, point{
+			measurement: "prusa_job",
+			tags:        tags,
+			fields: map[string]any{
+				"job_id":               job.ID,
+				"state":                job.State,
+				"progress":             job.Progress,
+				"time_printing":        job.TimePrinting,
+				"time_remaining":       job.TimeRemaining,
+				"estimated_print_time": job.EstimatedPrintTime,
+			},
+			time: snapshot.Time,
+		})
+	}
+
+	for _, index := range snapshot.Printer.Temperature.ToolIndexes() {
+		tool := snapshot.Printer.Temperature.Tools[index]
+		toolTags := map[string]string{"printer_tool": "tool" + strconv.Itoa(index)}
+		for key, value := range tags {
+			toolTags[key] = value
+		}
+
+		fields := map[string]any{
+			"temperature":        tool.Actual,
+			"target_temperature": tool.Target,
+		}
+
+		if tool.NozzleDiameter > 0 {
+			fields["nozzle_diameter"] = tool.NozzleDiameter
+		}
+
+		if tool.Material != "" {
+			fields["material"] = tool.Material
+		}
+
+		points = append(points, point{measurement: "prusa_tool", tags: toolTags, fields: fields, time: snapshot.Time})
+	}
+
+	return points
+}
@@ -0,0 +1,231 @@
+package influx
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		point point
+		line  string
+	}{
+		{
+			name: "fields are sorted and typed",
+			point: point{
+				measurement: "prusa_printer",
+				tags:        map[string]string{"printer_name": "xl", "printer_model": "XL"},
+				fields:      map[string]any{"up": true, "nozzle_temperature": 215.5, "state": "PRINTING"},
+				time:        time.Unix(0, 1700000000000000000),
+			},
+			line: `prusa_printer,printer_model=XL,printer_name=xl nozzle_temperature=215.5,state="PRINTING",up=true 1700000000000000000`,
+		},
+		{
+			name: "special characters are escaped",
+			point: point{
+				measurement: "prusa job,x",
+				tags:        map[string]string{"printer_job_name": `a b,c=d`, "printer_job_path": `C:\prints\`},
+				fields:      map[string]any{"file": `say "hi" \o/`},
+				time:        time.Unix(0, 1),
+			},
+			line: `prusa\ job\,x,printer_job_name=a\ b\,c\=d,printer_job_path=C:\\prints\\ file="say \"hi\" \\o/" 1`,
+		},
+		{
+			name: "empty tags are skipped",
+			point: point{
+				measurement: "prusa_printer",
+				tags:        map[string]string{"printer_name": "xl", "printer_job_name": ""},
+				fields:      map[string]any{"up": false},
+				time:        time.Unix(0, 1),
+			},
+			line: `prusa_printer,printer_name=xl up=false 1`,
+		},
+	}
+
+	for _, test := range tests {
+		if line := test.point.encode(); line != test.line {
+			t.Errorf("%s: encode() =\n%s\nexpected\n%s", test.name, line, test.line)
+		}
+	}
+}
+
+// receiver is InfluxDB test server answering with statuses in order, the last status is repeated
+type receiver struct {
+	statuses []int
+	bodies   []string
+	requests []*http.Request
+	mutex    sync.Mutex
+}
+
+func (receiver *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	status := receiver.statuses[min(len(receiver.requests), len(receiver.statuses)-1)]
+	receiver.requests = append(receiver.requests, r)
+	receiver.bodies = append(receiver.bodies, string(body))
+
+	w.WriteHeader(status)
+}
+
+func newTestWriter(t *testing.T, address string) *Writer {
+	t.Helper()
+
+	var cfg config.Config
+	cfg.Exporter.InfluxDB.URL = address
+	cfg.Exporter.InfluxDB.Org = "farm"
+	cfg.Exporter.InfluxDB.Bucket = "prusa"
+	cfg.Exporter.InfluxDB.Token = "secret"
+	cfg.Exporter.InfluxDB.BatchSize = 2
+	cfg.Exporter.InfluxDB.FlushInterval = model.Duration(time.Hour)
+
+	writer, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writer
+}
+
+func testSnapshot(name string) prusalink.Snapshot {
+	snapshot := prusalink.Snapshot{
+		Config: config.Printers{Address: "192.168.1.10", Name: name, Type: "MK4"},
+		Up:     true,
+		Time:   time.Unix(0, 1700000000000000000),
+	}
+	snapshot.Status.Printer.State = "IDLE"
+
+	return snapshot
+}
+
+func TestWriteHTTP(t *testing.T) {
+	influx := &receiver{statuses: []int{http.StatusNoContent}}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+
+	writer := newTestWriter(t, server.URL)
+	writer.HandleSnapshot(prusalink.Snapshot{}, testSnapshot("mk4"))
+	writer.Flush()
+
+	if len(influx.requests) != 1 {
+		t.Fatalf("InfluxDB got %d requests, expected 1", len(influx.requests))
+	}
+
+	r := influx.requests[0]
+
+	if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("org") != "farm" || r.URL.Query().Get("bucket") != "prusa" || r.URL.Query().Get("precision") != "ns" {
+		t.Errorf("request = %s, expected write API with org, bucket and precision", r.URL)
+	}
+
+	if authorization := r.Header.Get("Authorization"); authorization != "Token secret" {
+		t.Errorf("Authorization = %q, expected token", authorization)
+	}
+
+	if !strings.HasPrefix(influx.bodies[0], "prusa_printer,printer_address=192.168.1.10,printer_model=MK4,printer_name=mk4 ") {
+		t.Errorf("body = %q, expected prusa_printer point", influx.bodies[0])
+	}
+}
+
+func TestWriteHTTPRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		sent     bool
+	}{
+		{"server error is retried", []int{http.StatusServiceUnavailable, http.StatusNoContent}, 2, true},
+		{"too many requests is retried", []int{http.StatusTooManyRequests, http.StatusNoContent}, 2, true},
+		{"bad request is dropped", []int{http.StatusBadRequest, http.StatusNoContent}, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			influx := &receiver{statuses: test.statuses}
+			server := httptest.NewServer(influx)
+			defer server.Close()
+
+			writer := newTestWriter(t, server.URL)
+			writer.HandleSnapshot(prusalink.Snapshot{}, testSnapshot("mk4"))
+
+			writer.Flush()
+			writer.Flush()
+
+			if len(influx.requests) != test.requests {
+				t.Fatalf("InfluxDB got %d requests, expected %d", len(influx.requests), test.requests)
+			}
+
+			if test.sent && influx.bodies[1] != influx.bodies[0] {
+				t.Errorf("retried body = %q, expected %q", influx.bodies[1], influx.bodies[0])
+			}
+		})
+	}
+}
+
+func TestWriteHTTPRetryLimit(t *testing.T) {
+	writer := newTestWriter(t, "http://127.0.0.1:1")
+
+	for i := range maxBufferedBatches * 2 {
+		writer.lines = append(writer.lines, "line"+string(rune('a'+i)))
+	}
+
+	writer.Flush()
+
+	if limit := writer.batchSize * maxBufferedBatches; len(writer.lines) != limit {
+		t.Fatalf("%d lines are kept, expected %d", len(writer.lines), limit)
+	}
+
+	if writer.lines[len(writer.lines)-1] != "line"+string(rune('a'+maxBufferedBatches*2-1)) {
+		t.Errorf("the newest line was dropped, got %v", writer.lines)
+	}
+
+	if !writer.failing {
+		t.Error("writer is not marked as failing")
+	}
+}
+
+func TestWriteUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writer := newTestWriter(t, "udp://"+conn.LocalAddr().String())
+
+	// two lines don't fit into one packet, short lines are sent together
+	long := strings.Repeat("x", maxPacketSize/2)
+	writer.lines = []string{"a " + long, "b " + long, "c 1", "d 2"}
+	writer.Flush()
+
+	expected := []string{"a " + long + "\n", "b " + long + "\nc 1\nd 2\n"}
+	buffer := make([]byte, 65536)
+
+	for _, packet := range expected {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buffer[:n]) != packet {
+			t.Errorf("packet = %q, expected %q", buffer[:n], packet)
+		}
+
+		if n > maxPacketSize {
+			t.Errorf("packet has %d bytes, expected at most %d", n, maxPacketSize)
+		}
+	}
+}
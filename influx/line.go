@@ -0,0 +1,75 @@
+package influx
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tagLabels are names of tags in the order of prusalink.GetLabels values
+var tagLabels = []string{"printer_address", "printer_model", "printer_name", "printer_job_name", "printer_job_path"}
+
+// backslashes are escaped too, otherwise value ending with backslash escapes the following separator
+var (
+	measurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `, "=", `\=`)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// point is a single line of line protocol
+type point struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]any // float64, bool or string
+	time        time.Time
+}
+
+// encode returns the point in line protocol, tags and fields are sorted and tags with empty value are skipped
+func (point point) encode() string {
+	var line strings.Builder
+
+	line.WriteString(measurementEscaper.Replace(point.measurement))
+
+	for _, key := range sortedKeys(point.tags) {
+		if point.tags[key] == "" {
+			continue
+		}
+		line.WriteString("," + tagEscaper.Replace(key) + "=" + tagEscaper.Replace(point.tags[key]))
+	}
+
+	for i, key := range sortedKeys(point.fields) {
+		if i == 0 {
+			line.WriteString(" ")
+		} else {
+			line.WriteString(",")
+		}
+
+		line.WriteString(tagEscaper.Replace(key) + "=")
+
+		switch value := point.fields[key].(type) {
+		case float64:
+			line.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			line.WriteString(strconv.FormatBool(value))
+		case string:
+			line.WriteString(`"` + stringEscaper.Replace(value) + `"`)
+		}
+	}
+
+	line.WriteString(" " + strconv.FormatInt(point.time.UnixNano(), 10))
+
+	return line.String()
+}
+
+// sortedKeys returns sorted keys of the map
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
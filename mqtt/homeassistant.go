@@ -0,0 +1,176 @@
+package mqtt
+
+import (
+	"strings"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// entity is a Home Assistant entity read from printer state topic
+type entity struct {
+	component   string // sensor or binary_sensor
+	key         string
+	name        string
+	template    string
+	unit        string
+	deviceClass string
+	stateClass  string
+	icon        string
+}
+
+// entities published for every printer, chamber entities are added only for printers with chamber sensor
+var entities = []entity{
+	{component: "sensor", key: "nozzle_temperature", name: "Nozzle temperature", template: "{{ value_json.temperature.nozzle }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"},
+	{component: "sensor", key: "nozzle_target_temperature", name: "Nozzle target temperature", template: "{{ value_json.temperature.nozzle_target }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"},
+	{component: "sensor", key: "bed_temperature", name: "Bed temperature", template: "{{ value_json.temperature.bed }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"},
+	{component: "sensor", key: "bed_target_temperature", name: "Bed target temperature", template: "{{ value_json.temperature.bed_target }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"},
+	{component: "sensor", key: "progress", name: "Progress", template: "{{ value_json.job.progress if value_json.job else 0 }}", unit: "%", stateClass: "measurement", icon: "mdi:progress-clock"},
+	{component: "sensor", key: "time_remaining", name: "Time remaining", template: "{{ value_json.job.time_remaining if value_json.job else 0 }}", unit: "s", deviceClass: "duration"},
+	{component: "sensor", key: "job_file", name: "Job", template: "{{ value_json.job.file if value_json.job else '' }}", icon: "mdi:file-outline"},
+	{component: "sensor", key: "state", name: "State", template: "{{ value_json.state }}", icon: "mdi:printer-3d"},
+	{component: "sensor", key: "filament", name: "Filament", template: "{{ value_json.material }}", icon: "mdi:printer-3d-nozzle"},
+	{component: "sensor", key: "hotend_fan", name: "Hotend fan", template: "{{ value_json.fans.hotend }}", unit: "RPM", stateClass: "measurement", icon: "mdi:fan"},
+	{component: "sensor", key: "print_fan", name: "Print fan", template: "{{ value_json.fans.print }}", unit: "RPM", stateClass: "measurement", icon: "mdi:fan"},
+	{component: "binary_sensor", key: "printing", name: "Printing", template: "{{ 'ON' if value_json.state == 'PRINTING' else 'OFF' }}", deviceClass: "running"},
+	{component: "binary_sensor", key: "mmu", name: "MMU", template: "{{ 'ON' if value_json.mmu else 'OFF' }}", icon: "mdi:tray-full"},
+}
+
+// chamberEntities are published only for printers with chamber sensor
+var chamberEntities = []entity{
+	{component: "sensor", key: "chamber_temperature", name: "Chamber temperature", template: "{{ value_json.temperature.chamber }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"},
+	{component: "sensor", key: "chamber_target_temperature", name: "Chamber target temperature", template: "{{ value_json.temperature.chamber_target }}", unit: "°C", deviceClass: "temperature", stateClass: "measurement"},
+}
+
+// subscribeHomeAssistant republishes discovery configs when Home Assistant comes online, it's called after every connect
+func (publisher *Publisher) subscribeHomeAssistant(client paho.Client) {
+	if publisher.discoveryPrefix == "" {
+		return
+	}
+
+	publisher.resetDiscovery()
+
+	client.Subscribe(publisher.discoveryPrefix+"/status", publisher.qos, func(_ paho.Client, message paho.Message) {
+		if string(message.Payload()) == Online {
+			log.Debug().Msg("Home Assistant is online, discovery configs are published again")
+			publisher.resetDiscovery()
+		}
+	})
+}
+
+// resetDiscovery marks all printers as not discovered, so configs are published with the next poll
+func (publisher *Publisher) resetDiscovery() {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	publisher.discovered = map[string]bool{}
+}
+
+// publishDiscovery publishes Home Assistant discovery configs of the printer once per connection
+func (publisher *Publisher) publishDiscovery(snapshot prusalink.Snapshot, summary prusalink.Summary) {
+	if publisher.discoveryPrefix == "" || !publisher.client.IsConnectionOpen() {
+		return
+	}
+
+	publisher.mutex.Lock()
+	discovered := publisher.discovered[summary.Address]
+	publisher.discovered[summary.Address] = true
+	publisher.mutex.Unlock()
+
+	if discovered {
+		return
+	}
+
+	nodeID := discoveryNodeID(summary)
+	device := discoveryDevice(summary, nodeID)
+
+	availability := []map[string]string{
+		{"topic": publisher.StatusTopic()},
+		{"topic": publisher.PrinterTopic(snapshot, "availability")},
+	}
+
+	printerEntities := entities
+	if summary.Temperature.Chamber != nil {
+		printerEntities = append(append([]entity{}, entities...), chamberEntities...)
+	}
+
+	for _, entity := range printerEntities {
+		payload := map[string]any{
+			"name":              entity.name,
+			"unique_id":         nodeID + "_" + entity.key,
+			"object_id":         nodeID + "_" + entity.key,
+			"state_topic":       publisher.PrinterTopic(snapshot, "state"),
+			"value_template":    entity.template,
+			"availability":      availability,
+			"availability_mode": "all",
+			"device":            device,
+		}
+
+		setOptional(payload, "unit_of_measurement", entity.unit)
+		setOptional(payload, "device_class", entity.deviceClass)
+		setOptional(payload, "state_class", entity.stateClass)
+		setOptional(payload, "icon", entity.icon)
+
+		publisher.publishJSON(publisher.discoveryTopic(entity.component, nodeID, entity.key), payload)
+	}
+
+	// online sensor reads availability directly, so it's not unavailable when printer is offline
+	publisher.publishJSON(publisher.discoveryTopic("binary_sensor", nodeID, "online"), map[string]any{
+		"name":         "Online",
+		"unique_id":    nodeID + "_online",
+		"object_id":    nodeID + "_online",
+		"state_topic":  publisher.PrinterTopic(snapshot, "availability"),
+		"payload_on":   Online,
+		"payload_off":  Offline,
+		"device_class": "connectivity",
+		"availability": availability[:1],
+		"device":       device,
+	})
+
+	log.Debug().Msg("Home Assistant discovery published for " + summary.Address)
+}
+
+// discoveryTopic returns topic of Home Assistant discovery config
+func (publisher *Publisher) discoveryTopic(component string, nodeID string, key string) string {
+	return publisher.discoveryPrefix + "/" + component + "/" + nodeID + "/" + key + "/config"
+}
+
+// discoveryDevice returns Home Assistant device of the printer
+func discoveryDevice(summary prusalink.Summary, nodeID string) map[string]any {
+	name := summary.Name
+	if name == "" {
+		name = "Prusa " + summary.Model
+	}
+
+	device := map[string]any{
+		"identifiers":  []string{nodeID},
+		"name":         name,
+		"manufacturer": "Prusa Research",
+	}
+
+	setOptional(device, "model", summary.Model)
+	setOptional(device, "sw_version", summary.Firmware)
+	setOptional(device, "serial_number", summary.Serial)
+	setOptional(device, "suggested_area", summary.Location)
+
+	return device
+}
+
+// discoveryNodeID returns stable id of the printer - configured address is used, serial number is known only after metadata
+// are read and switching to it would create second device, name is not used so the device survives renames
+func discoveryNodeID(summary prusalink.Summary) string {
+	return "prusa_" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, summary.Address)
+}
+
+// setOptional sets the value only when it's not empty
+func setOptional(payload map[string]any, key string, value string) {
+	if value != "" {
+		payload[key] = value
+	}
+}
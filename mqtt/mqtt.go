@@ -0,0 +1,149 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// Availability payloads
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Publisher publishes printer snapshots to MQTT broker, all messages are retained
+type Publisher struct {
+	client paho.Client
+	prefix string
+	qos    byte
+
+	discoveryPrefix string          // empty when Home Assistant discovery is disabled
+	discovered      map[string]bool // printers with published discovery configs
+	mutex           sync.Mutex
+}
+
+// New returns a new Publisher connected to the broker, exporter availability is published to <prefix>/status with Last Will set to offline
+func New(config config.Config) *Publisher {
+	settings := config.Exporter.MQTT
+
+	publisher := &Publisher{
+		prefix:     settings.TopicPrefix,
+		qos:        settings.QoS,
+		discovered: map[string]bool{},
+	}
+
+	if settings.HomeAssistant.Enabled {
+		publisher.discoveryPrefix = settings.HomeAssistant.DiscoveryPrefix
+	}
+
+	options := paho.NewClientOptions().
+		AddBroker(settings.Broker).
+		SetClientID(settings.ClientID).
+		SetUsername(settings.Username).
+		SetPassword(settings.Password).
+		SetWill(publisher.StatusTopic(), Offline, settings.QoS, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(client paho.Client) {
+			log.Info().Msg("Connected to MQTT broker " + settings.Broker)
+			client.Publish(publisher.StatusTopic(), settings.QoS, true, Online)
+			publisher.subscribeHomeAssistant(client)
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Error().Msg("Connection to MQTT broker " + settings.Broker + " lost - " + err.Error())
+		})
+
+	publisher.client = paho.NewClient(options)
+
+	// connection is retried in background, messages are dropped until it's established
+	if token := publisher.client.Connect(); !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		log.Warn().Msg("MQTT broker " + settings.Broker + " is not reachable yet, connection is retried in background")
+	}
+
+	return publisher
+}
+
+// Close publishes offline status and disconnects from the broker
+func (publisher *Publisher) Close() {
+	publisher.client.Publish(publisher.StatusTopic(), publisher.qos, true, Offline).WaitTimeout(time.Second)
+	publisher.client.Disconnect(250)
+}
+
+// StatusTopic returns topic of exporter availability
+func (publisher *Publisher) StatusTopic() string {
+	return publisher.prefix + "/status"
+}
+
+// PrinterTopic returns topic of the printer, printer name is used when it's configured
+func (publisher *Publisher) PrinterTopic(snapshot prusalink.Snapshot, topic string) string {
+	return publisher.prefix + "/" + TopicName(snapshot) + "/" + topic
+}
+
+// HandleSnapshot publishes availability, full state and its parts of the printer, it's meant to be subscribed to the poller
+func (publisher *Publisher) HandleSnapshot(_ prusalink.Snapshot, snapshot prusalink.Snapshot) {
+	summary := prusalink.GetSummary(snapshot)
+
+	availability := Offline
+	if summary.Online {
+		availability = Online
+	}
+
+	publisher.publish(publisher.PrinterTopic(snapshot, "availability"), availability)
+	publisher.publishJSON(publisher.PrinterTopic(snapshot, "state"), summary)
+
+	if !summary.Online {
+		return // the last known values are kept retained
+	}
+
+	publisher.publishDiscovery(snapshot, summary)
+
+	publisher.publishJSON(publisher.PrinterTopic(snapshot, "temperature"), summary.Temperature)
+	publisher.publishJSON(publisher.PrinterTopic(snapshot, "fans"), summary.Fans)
+	publisher.publishJSON(publisher.PrinterTopic(snapshot, "axes"), summary.Axes)
+	publisher.publishJSON(publisher.PrinterTopic(snapshot, "job"), summary.Job)
+}
+
+// publishJSON publishes value encoded as JSON
+func (publisher *Publisher) publishJSON(topic string, value any) {
+	payload, err := json.Marshal(value)
+
+	if err != nil {
+		log.Error().Msg("Error while encoding MQTT message for " + topic + " - " + err.Error())
+		return
+	}
+
+	publisher.publish(topic, string(payload))
+}
+
+// publish publishes retained message, delivery is not awaited so slow broker does not block polling
+func (publisher *Publisher) publish(topic string, payload string) {
+	if !publisher.client.IsConnectionOpen() {
+		return // retained state is refreshed by the next poll after reconnect
+	}
+
+	token := publisher.client.Publish(topic, publisher.qos, true, payload)
+
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Error().Msg("Error while publishing MQTT message to " + topic + " - " + token.Error().Error())
+		}
+	}()
+}
+
+// TopicName returns printer name usable as single topic level, address is used when name is not configured
+func TopicName(snapshot prusalink.Snapshot) string {
+	name := snapshot.Config.Name
+
+	if name == "" {
+		name = snapshot.Config.Address
+	}
+
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(name)
+}
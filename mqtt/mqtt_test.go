@@ -0,0 +1,200 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// startBroker starts in-process MQTT broker and returns its URL
+func startBroker(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	broker := server.New(nil)
+	broker.AddHook(new(auth.AllowHook), nil)
+
+	if err := broker.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: address})); err != nil {
+		t.Fatal(err)
+	}
+
+	go broker.Serve()
+	t.Cleanup(func() { broker.Close() })
+
+	return "tcp://" + address
+}
+
+// subscriber collects the last message of every topic
+type subscriber struct {
+	messages map[string]string
+	mutex    sync.Mutex
+}
+
+// subscribe connects to the broker and subscribes to all topics
+func subscribe(t *testing.T, broker string) *subscriber {
+	t.Helper()
+
+	collected := &subscriber{messages: map[string]string{}}
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID("test-subscriber"))
+
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscriber can't connect to %s - %v", broker, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+
+	token := client.Subscribe("#", 1, func(_ paho.Client, message paho.Message) {
+		collected.mutex.Lock()
+		defer collected.mutex.Unlock()
+		collected.messages[message.Topic()] = string(message.Payload())
+	})
+
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscriber can't subscribe - %v", token.Error())
+	}
+
+	return collected
+}
+
+// wait returns the last message of the topic once its payload satisfies the condition
+func (collected *subscriber) wait(t *testing.T, topic string, condition func(string) bool) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		collected.mutex.Lock()
+		payload, ok := collected.messages[topic]
+		collected.mutex.Unlock()
+
+		if ok && condition(payload) {
+			return payload
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("message of %s was not received", topic)
+	return ""
+}
+
+// anyPayload accepts every payload
+func anyPayload(string) bool {
+	return true
+}
+
+func testSnapshot(up bool) prusalink.Snapshot {
+	snapshot := prusalink.Snapshot{
+		Config:   config.Printers{Address: "192.168.1.10", Name: "my xl", Type: "XL"},
+		Up:       up,
+		Time:     time.Now(),
+		LastSeen: time.Now(),
+	}
+	snapshot.Status.Printer.State = "PRINTING"
+	snapshot.Status.Printer.TempNozzle = 215
+	snapshot.Status.Printer.TempBed = 60
+
+	return snapshot
+}
+
+func newTestPublisher(t *testing.T, broker string) *Publisher {
+	var cfg config.Config
+	cfg.Exporter.MQTT.Broker = broker
+	cfg.Exporter.MQTT.ClientID = "test-publisher"
+	cfg.Exporter.MQTT.TopicPrefix = "prusa"
+	cfg.Exporter.MQTT.HomeAssistant.Enabled = true
+	cfg.Exporter.MQTT.HomeAssistant.DiscoveryPrefix = "homeassistant"
+
+	return New(cfg)
+}
+
+func TestPublisher(t *testing.T) {
+	broker := startBroker(t)
+	publisher := newTestPublisher(t, broker)
+	messages := subscribe(t, broker)
+
+	messages.wait(t, "prusa/status", func(payload string) bool { return payload == Online })
+
+	publisher.HandleSnapshot(prusalink.Snapshot{}, testSnapshot(true))
+
+	messages.wait(t, "prusa/my_xl/availability", func(payload string) bool { return payload == Online })
+
+	var summary prusalink.Summary
+	json.Unmarshal([]byte(messages.wait(t, "prusa/my_xl/state", anyPayload)), &summary)
+
+	if summary.State != "PRINTING" || summary.Address != "192.168.1.10" {
+		t.Errorf("state = %+v, expected printing printer", summary)
+	}
+
+	var temperature prusalink.SummaryTemperature
+	json.Unmarshal([]byte(messages.wait(t, "prusa/my_xl/temperature", anyPayload)), &temperature)
+
+	if temperature.Nozzle != 215 || temperature.Bed != 60 {
+		t.Errorf("temperature = %+v, expected nozzle 215 and bed 60", temperature)
+	}
+
+	var discovery map[string]any
+	json.Unmarshal([]byte(messages.wait(t, "homeassistant/sensor/prusa_192_168_1_10/nozzle_temperature/config", anyPayload)), &discovery)
+
+	if discovery["unique_id"] != "prusa_192_168_1_10_nozzle_temperature" || discovery["state_topic"] != "prusa/my_xl/state" {
+		t.Errorf("discovery = %v, expected unique id and state topic of the printer", discovery)
+	}
+
+	messages.wait(t, "homeassistant/binary_sensor/prusa_192_168_1_10/online/config", anyPayload)
+
+	publisher.HandleSnapshot(prusalink.Snapshot{}, testSnapshot(false))
+	messages.wait(t, "prusa/my_xl/availability", func(payload string) bool { return payload == Offline })
+
+	publisher.Close()
+	messages.wait(t, "prusa/status", func(payload string) bool { return payload == Offline })
+}
+
+func TestDiscoveryNodeIDIsStable(t *testing.T) {
+	summary := prusalink.Summary{Address: "192.168.1.10", Name: "my xl"}
+	before := discoveryNodeID(summary)
+
+	// serial number is read later with metadata, printer can be renamed
+	summary.Serial = "CZPX4523X004XK12345"
+	summary.Name = "left xl"
+
+	if after := discoveryNodeID(summary); after != before {
+		t.Errorf("discoveryNodeID() = %s after serial number is known, expected %s", after, before)
+	}
+
+	if before != "prusa_192_168_1_10" {
+		t.Errorf("discoveryNodeID() = %s, expected prusa_192_168_1_10", before)
+	}
+}
+
+func TestTopicName(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		topic   string
+	}{
+		{"my xl", "192.168.1.10", "my_xl"},
+		{"a/b+c#", "192.168.1.10", "a_b_c_"},
+		{"", "192.168.1.10", "192.168.1.10"},
+	}
+
+	for _, test := range tests {
+		snapshot := prusalink.Snapshot{Config: config.Printers{Name: test.name, Address: test.address}}
+		if topic := TopicName(snapshot); topic != test.topic {
+			t.Errorf("TopicName(%q) = %q, expected %q", test.name, topic, test.topic)
+		}
+	}
+}
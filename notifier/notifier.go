@@ -0,0 +1,324 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// Printer events observed by the poller, job events use names of prusalink job events
+const (
+	EventAttention = "attention"
+	EventOffline   = "offline"
+	EventOnline    = "online"
+)
+
+// defaultTemplate is used for receivers without template
+const defaultTemplate = `{{.Printer}}: {{.Title}}{{if .Job}} - {{.Job}}{{end}}{{if .Duration}} after {{.Duration}}{{end}}`
+
+// titles of the events used in messages
+var titles = map[string]string{
+	prusalink.JobStarted:   "print started",
+	prusalink.JobPaused:    "print paused",
+	prusalink.JobResumed:   "print resumed",
+	prusalink.JobCompleted: "print completed",
+	prusalink.JobCancelled: "print cancelled",
+	prusalink.JobFailed:    "print failed",
+	EventAttention:         "printer needs attention",
+	EventOffline:           "printer is offline",
+	EventOnline:            "printer is back online",
+}
+
+// Notification is a struct that contains data about single event, it's passed to message templates
+type Notification struct {
+	Event           string    `json:"event"`
+	Title           string    `json:"title"`
+	Message         string    `json:"message"`
+	Printer         string    `json:"printer"` // name of the printer, address is used when name is not configured
+	PrinterAddress  string    `json:"printer_address"`
+	PrinterModel    string    `json:"printer_model"`
+	Location        string    `json:"location,omitempty"`
+	Job             string    `json:"job,omitempty"`
+	JobID           float64   `json:"job_id,omitempty"`
+	Progress        float64   `json:"progress,omitempty"`
+	Duration        string    `json:"duration,omitempty"` // time spent printing
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	Thumbnail       string    `json:"thumbnail,omitempty"` // base64 encoded PNG
+	Time            time.Time `json:"time"`
+}
+
+// receiver is a configured receiver with parsed template
+type receiver struct {
+	config.Receiver
+	template *template.Template
+}
+
+// printerStatus is a struct that contains availability of the printer as seen by the notifier
+type printerStatus struct {
+	seen     bool // printer was online at least once
+	failures int  // consecutive failed polls
+	offline  bool // offline notification was sent
+}
+
+// Notifier sends notifications about printer events to configured receivers
+type Notifier struct {
+	receivers    []receiver
+	rateLimit    time.Duration
+	retries      int
+	backoff      time.Duration // delay before the first retry, it's doubled for every next retry
+	offlineAfter int
+	client       *http.Client
+	sent         map[string]time.Time // last notification by receiver, printer and event
+	printers     map[string]*printerStatus
+	mutex        sync.Mutex
+}
+
+// New returns a new Notifier, receivers with invalid template fall back to the default template
+func New(config config.Config) *Notifier {
+	settings := config.Exporter.Notifications
+
+	notifier := &Notifier{
+		rateLimit:    time.Duration(settings.RateLimit),
+		retries:      settings.Retries,
+		backoff:      time.Second,
+		offlineAfter: max(settings.OfflineAfter, 1),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		sent:         map[string]time.Time{},
+		printers:     map[string]*printerStatus{},
+	}
+
+	for _, configured := range settings.Receivers {
+		text := configured.Template
+		if text == "" {
+			text = defaultTemplate
+		}
+
+		tmpl, err := template.New(configured.Name).Parse(text)
+		if err != nil {
+			log.Error().Msg("Error while parsing template of receiver " + configured.Name + " - " + err.Error())
+			tmpl = template.Must(template.New(configured.Name).Parse(defaultTemplate))
+		}
+
+		notifier.receivers = append(notifier.receivers, receiver{Receiver: configured, template: tmpl})
+	}
+
+	return notifier
+}
+
+// HandleJobEvent sends notification about job event, it's meant to be subscribed to the job tracker
+func (notifier *Notifier) HandleJobEvent(event prusalink.JobEvent) {
+	notification := newNotification(event.Type, event.Snapshot)
+	notification.Job = event.Job.File.DisplayName
+	notification.JobID = event.JobID
+	notification.Progress = event.Job.Progress
+	notification.Thumbnail = event.Thumbnail
+
+	if notification.Job == "" {
+		notification.Job = event.Job.File.Name
+	}
+
+	if event.Duration > 0 {
+		notification.Duration = event.Duration.String()
+		notification.DurationSeconds = event.Duration.Seconds()
+	}
+
+	notifier.Notify(notification)
+}
+
+// HandleSnapshot sends notifications when printer goes offline, comes back or needs attention, it's meant to be subscribed to the poller
+// printer is reported offline after offlineAfter consecutive failed polls, so single failed poll does not send offline and online notifications
+func (notifier *Notifier) HandleSnapshot(previous prusalink.Snapshot, snapshot prusalink.Snapshot) {
+	if event := notifier.availability(snapshot); event != "" {
+		notifier.Notify(newNotification(event, snapshot))
+		return
+	}
+
+	if previous.Up && snapshot.Up && prusalink.GetPrinterState(snapshot) == "ATTENTION" && prusalink.GetPrinterState(previous) != "ATTENTION" {
+		notifier.Notify(newNotification(EventAttention, snapshot))
+	}
+}
+
+// availability updates availability of the printer and returns offline or online event when it changed
+func (notifier *Notifier) availability(snapshot prusalink.Snapshot) string {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	status, ok := notifier.printers[snapshot.Config.Address]
+	if !ok {
+		status = &printerStatus{}
+		notifier.printers[snapshot.Config.Address] = status
+	}
+
+	if snapshot.Up {
+		status.seen = true
+		status.failures = 0
+
+		if status.offline {
+			status.offline = false
+			return EventOnline
+		}
+
+		return ""
+	}
+
+	status.failures++
+
+	// printer that was never online since start is not reported
+	if status.seen && !status.offline && status.failures >= notifier.offlineAfter {
+		status.offline = true
+		return EventOffline
+	}
+
+	return ""
+}
+
+// Notify sends notification to all receivers routed for its printer and event, sending is done in background
+func (notifier *Notifier) Notify(notification Notification) {
+	for _, receiver := range notifier.receivers {
+		if !receiver.accepts(notification) || !notifier.allow(receiver, notification) {
+			continue
+		}
+
+		message := notification
+		message.Message = receiver.render(notification)
+
+		go notifier.send(receiver, message)
+	}
+}
+
+// allow returns false when the same notification was sent to the receiver within rate limit
+func (notifier *Notifier) allow(receiver receiver, notification Notification) bool {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	key := receiver.Name + "/" + notification.PrinterAddress + "/" + notification.Event
+
+	if last, ok := notifier.sent[key]; ok && notification.Time.Sub(last) < notifier.rateLimit {
+		log.Debug().Msg("Notification " + notification.Event + " of " + notification.Printer + " to " + receiver.Name + " is rate limited")
+		return false
+	}
+
+	notifier.sent[key] = notification.Time
+
+	return true
+}
+
+// send delivers notification to the receiver, failed requests are retried with exponential backoff
+func (notifier *Notifier) send(receiver receiver, notification Notification) {
+	backoff := notifier.backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := notifier.deliver(receiver, notification)
+
+		if err == nil {
+			log.Debug().Msg("Notification " + notification.Event + " of " + notification.Printer + " sent to " + receiver.Name)
+			return
+		}
+
+		if !retry || attempt >= notifier.retries {
+			log.Error().Msg("Error while sending notification to " + receiver.Name + " - " + err.Error())
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// deliver sends single request to the receiver and reports whether failed request should be retried
+func (notifier *Notifier) deliver(receiver receiver, notification Notification) (bool, error) {
+	body, headers, err := formatPayload(receiver.Type, notification)
+
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest("POST", receiver.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return false, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	for key, value := range receiver.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if receiver.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+receiver.Token)
+	}
+
+	res, err := notifier.client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("receiver returned status %d", res.StatusCode)
+	}
+
+	return false, nil
+}
+
+// accepts returns true if the receiver is routed for printer and event of the notification
+func (receiver receiver) accepts(notification Notification) bool {
+	if len(receiver.Events) > 0 && !slices.Contains(receiver.Events, notification.Event) {
+		return false
+	}
+
+	if len(receiver.Printers) == 0 {
+		return true
+	}
+
+	return slices.Contains(receiver.Printers, notification.Printer) || slices.Contains(receiver.Printers, notification.PrinterAddress)
+}
+
+// render returns message of the notification rendered by receiver template
+func (receiver receiver) render(notification Notification) string {
+	var message strings.Builder
+
+	if err := receiver.template.Execute(&message, notification); err != nil {
+		log.Error().Msg("Error while rendering template of receiver " + receiver.Name + " - " + err.Error())
+		return notification.Printer + ": " + notification.Title
+	}
+
+	return message.String()
+}
+
+// newNotification returns notification of the event with printer data from the snapshot
+func newNotification(event string, snapshot prusalink.Snapshot) Notification {
+	printer := snapshot.Config
+	name := printer.Name
+
+	if name == "" {
+		name = printer.Address
+	}
+
+	return Notification{
+		Event:          event,
+		Title:          titles[event],
+		Printer:        name,
+		PrinterAddress: printer.Address,
+		PrinterModel:   printer.Type,
+		Location:       printer.Location,
+		Time:           snapshot.Time,
+	}
+}
@@ -0,0 +1,351 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// request is a request received by the test receiver
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver returns test server answering with statuses in order, the last status is repeated
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan request, *atomic.Int32) {
+	t.Helper()
+
+	requests := make(chan request, 10)
+	count := &atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		index := int(count.Add(1)) - 1
+
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status = statuses[min(index, len(statuses)-1)]
+		}
+
+		w.WriteHeader(status)
+		requests <- request{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests, count
+}
+
+// newTestNotifier returns notifier with single receiver and without delays between retries
+func newTestNotifier(receiver config.Receiver, retries int) *Notifier {
+	var cfg config.Config
+	cfg.Exporter.Notifications.Retries = retries
+	cfg.Exporter.Notifications.OfflineAfter = 3
+	cfg.Exporter.Notifications.Receivers = []config.Receiver{receiver}
+
+	notifier := New(cfg)
+	notifier.backoff = time.Millisecond
+
+	return notifier
+}
+
+func receive(t *testing.T, requests chan request) request {
+	t.Helper()
+
+	select {
+	case received := <-requests:
+		return received
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not received")
+		return request{}
+	}
+}
+
+func testNotification() Notification {
+	return Notification{
+		Event:          prusalink.JobCompleted,
+		Title:          titles[prusalink.JobCompleted],
+		Printer:        "xl",
+		PrinterAddress: "192.168.1.10",
+		PrinterModel:   "XL",
+		Job:            "benchy.bgcode",
+		Time:           time.Now(),
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	tests := []struct {
+		receiverType string
+		field        string
+	}{
+		{"webhook", "message"},
+		{"slack", "text"},
+		{"discord", "content"},
+		{"teams", "text"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.receiverType, func(t *testing.T) {
+			server, requests, _ := newReceiver(t)
+			notifier := newTestNotifier(config.Receiver{Name: test.receiverType, Type: test.receiverType, URL: server.URL, Token: "secret"}, 0)
+
+			notifier.Notify(testNotification())
+			received := receive(t, requests)
+
+			if contentType := received.header.Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Content-Type = %q, expected application/json", contentType)
+			}
+
+			if authorization := received.header.Get("Authorization"); authorization != "Bearer secret" {
+				t.Errorf("Authorization = %q, expected bearer token", authorization)
+			}
+
+			var payload map[string]any
+			if err := json.Unmarshal(received.body, &payload); err != nil {
+				t.Fatal(err)
+			}
+
+			if message := payload[test.field]; message != "xl: print completed - benchy.bgcode" {
+				t.Errorf("%s = %q, expected rendered message", test.field, message)
+			}
+
+			if test.receiverType == "webhook" && (payload["event"] != prusalink.JobCompleted || payload["printer_address"] != "192.168.1.10") {
+				t.Errorf("webhook payload = %v, expected event and printer address", payload)
+			}
+
+			if test.receiverType == "teams" && (payload["@type"] != "MessageCard" || payload["summary"] != "print completed") {
+				t.Errorf("teams payload = %v, expected message card", payload)
+			}
+		})
+	}
+}
+
+func TestNtfyPayload(t *testing.T) {
+	server, requests, _ := newReceiver(t)
+	notifier := newTestNotifier(config.Receiver{Name: "ntfy", Type: "ntfy", URL: server.URL}, 0)
+
+	notification := testNotification()
+	notification.Event = prusalink.JobFailed
+	notification.Title = titles[prusalink.JobFailed]
+	notifier.Notify(notification)
+
+	received := receive(t, requests)
+
+	if string(received.body) != "xl: print failed - benchy.bgcode" {
+		t.Errorf("body = %q, expected rendered message", received.body)
+	}
+
+	if title := received.header.Get("Title"); title != "xl: print failed" {
+		t.Errorf("Title = %q, expected printer and title", title)
+	}
+
+	if priority := received.header.Get("Priority"); priority != "high" {
+		t.Errorf("Priority = %q, expected high for failed job", priority)
+	}
+
+	thumbnail := []byte("\x89PNG thumbnail")
+	notification = testNotification()
+	notification.Thumbnail = base64.StdEncoding.EncodeToString(thumbnail)
+	notifier.Notify(notification)
+
+	received = receive(t, requests)
+
+	if string(received.body) != string(thumbnail) {
+		t.Errorf("body = %q, expected thumbnail attachment", received.body)
+	}
+
+	if message := received.header.Get("Message"); message != "xl: print completed - benchy.bgcode" {
+		t.Errorf("Message = %q, expected rendered message", message)
+	}
+
+	if filename := received.header.Get("Filename"); filename != "thumbnail.png" {
+		t.Errorf("Filename = %q, expected thumbnail.png", filename)
+	}
+}
+
+func TestDiscordThumbnail(t *testing.T) {
+	server, requests, _ := newReceiver(t)
+	notifier := newTestNotifier(config.Receiver{Name: "discord", Type: "discord", URL: server.URL}, 0)
+
+	thumbnail := []byte("\x89PNG thumbnail")
+	notification := testNotification()
+	notification.Thumbnail = base64.StdEncoding.EncodeToString(thumbnail)
+	notifier.Notify(notification)
+
+	received := receive(t, requests)
+
+	mediaType, params, err := mime.ParseMediaType(received.header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Content-Type = %q, expected multipart/form-data", received.header.Get("Content-Type"))
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(received.body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload struct {
+		Content string `json:"content"`
+		Embeds  []struct {
+			Image struct {
+				URL string `json:"url"`
+			} `json:"image"`
+		} `json:"embeds"`
+	}
+	if err := json.Unmarshal([]byte(form.Value["payload_json"][0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Content != "xl: print completed - benchy.bgcode" {
+		t.Errorf("content = %q, expected rendered message", payload.Content)
+	}
+
+	if len(payload.Embeds) != 1 || payload.Embeds[0].Image.URL != "attachment://thumbnail.png" {
+		t.Errorf("embeds = %+v, expected attached thumbnail", payload.Embeds)
+	}
+
+	files := form.File["files[0]"]
+	if len(files) != 1 || files[0].Filename != "thumbnail.png" {
+		t.Fatalf("files = %v, expected thumbnail.png", files)
+	}
+
+	file, _ := files[0].Open()
+	defer file.Close()
+
+	if data, _ := io.ReadAll(file); !bytes.Equal(data, thumbnail) {
+		t.Errorf("attachment = %q, expected thumbnail", data)
+	}
+}
+
+func TestPayloadsWithoutThumbnail(t *testing.T) {
+	notification := testNotification()
+	notification.Thumbnail = base64.StdEncoding.EncodeToString([]byte("\x89PNG thumbnail"))
+
+	// chat webhooks without image upload send the text only
+	for _, receiverType := range []string{"slack", "teams"} {
+		body, headers, err := formatPayload(receiverType, notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if headers["Content-Type"] != "application/json" || bytes.Contains(body, []byte(notification.Thumbnail)) {
+			t.Errorf("%s: payload = %s, expected JSON message without thumbnail", receiverType, body)
+		}
+	}
+
+	// invalid thumbnail is ignored
+	notification.Thumbnail = "not base64"
+	if _, headers, err := formatPayload("discord", notification); err != nil || headers["Content-Type"] != "application/json" {
+		t.Errorf("discord: Content-Type = %q (%v), expected JSON message", headers["Content-Type"], err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		statuses []int
+		requests int32
+	}{
+		{"server error is retried", 3, []int{500, 502, 200}, 3},
+		{"too many requests is retried", 3, []int{429, 200}, 2},
+		{"retries are limited", 2, []int{500}, 3},
+		{"zero retries", 0, []int{500}, 1},
+		{"client error is not retried", 3, []int{400}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests, count := newReceiver(t, test.statuses...)
+			notifier := newTestNotifier(config.Receiver{Name: "webhook", URL: server.URL}, test.retries)
+
+			notification := testNotification()
+			notifier.send(notifier.receivers[0], notification)
+
+			for range test.requests {
+				receive(t, requests)
+			}
+
+			if got := count.Load(); got != test.requests {
+				t.Errorf("receiver got %d requests, expected %d", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestOfflineDebounce(t *testing.T) {
+	server, requests, count := newReceiver(t)
+	notifier := newTestNotifier(config.Receiver{Name: "webhook", URL: server.URL}, 0)
+	notifier.rateLimit = 0
+
+	now := time.Now()
+	poll := func(previous prusalink.Snapshot, up bool) prusalink.Snapshot {
+		now = now.Add(10 * time.Second)
+		snapshot := prusalink.Snapshot{Config: config.Printers{Address: "192.168.1.10", Name: "xl"}, Up: up, Time: now}
+		notifier.HandleSnapshot(previous, snapshot)
+		return snapshot
+	}
+
+	snapshot := poll(prusalink.Snapshot{}, true)
+
+	// single failed poll is not reported
+	snapshot = poll(snapshot, false)
+	snapshot = poll(snapshot, true)
+
+	for range 2 {
+		snapshot = poll(snapshot, false)
+	}
+
+	if got := count.Load(); got != 0 {
+		t.Fatalf("receiver got %d requests before %d failed polls, expected none", got, notifier.offlineAfter)
+	}
+
+	snapshot = poll(snapshot, false)
+	snapshot = poll(snapshot, false)
+
+	var payload Notification
+	json.Unmarshal(receive(t, requests).body, &payload)
+	if payload.Event != EventOffline {
+		t.Errorf("event = %q, expected offline", payload.Event)
+	}
+
+	poll(snapshot, true)
+
+	json.Unmarshal(receive(t, requests).body, &payload)
+	if payload.Event != EventOnline {
+		t.Errorf("event = %q, expected online", payload.Event)
+	}
+
+	if got := count.Load(); got != 2 {
+		t.Errorf("receiver got %d requests, expected offline and online", got)
+	}
+}
+
+func TestOfflineNeverSeenPrinter(t *testing.T) {
+	server, _, count := newReceiver(t)
+	notifier := newTestNotifier(config.Receiver{Name: "webhook", URL: server.URL}, 0)
+
+	var previous prusalink.Snapshot
+	for range 5 {
+		snapshot := prusalink.Snapshot{Config: config.Printers{Address: "192.168.1.10"}, Time: time.Now()}
+		notifier.HandleSnapshot(previous, snapshot)
+		previous = snapshot
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := count.Load(); got != 0 {
+		t.Errorf("receiver got %d requests, expected none for printer that was never online", got)
+	}
+}
@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"strings"
+
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// formatPayload returns request body and headers of the notification in format of the receiver type
+// thumbnail of the job is sent to webhook, discord and ntfy receivers - slack and teams incoming webhooks accept only images with public URL, their messages are sent without it
+func formatPayload(receiverType string, notification Notification) ([]byte, map[string]string, error) {
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+
+	switch receiverType {
+	case "", "webhook":
+		body, err := json.Marshal(notification)
+		return body, jsonHeaders, err
+	case "slack":
+		body, err := json.Marshal(map[string]string{"text": notification.Message})
+		return body, jsonHeaders, err
+	case "discord":
+		return formatDiscord(notification)
+	case "teams":
+		body, err := json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  notification.Title,
+			"text":     notification.Message,
+		})
+		return body, jsonHeaders, err
+	case "ntfy":
+		return formatNtfy(notification)
+	default:
+		return nil, nil, fmt.Errorf("unknown receiver type %s", receiverType)
+	}
+}
+
+// formatDiscord returns discord message, thumbnail is uploaded as multipart attachment and shown in embed
+func formatDiscord(notification Notification) ([]byte, map[string]string, error) {
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	message := map[string]any{"content": notification.Message}
+
+	thumbnail, err := base64.StdEncoding.DecodeString(notification.Thumbnail)
+
+	if notification.Thumbnail == "" || err != nil {
+		body, err := json.Marshal(message)
+		return body, jsonHeaders, err
+	}
+
+	message["embeds"] = []map[string]any{{"image": map[string]string{"url": "attachment://thumbnail.png"}}}
+	message["attachments"] = []map[string]any{{"id": 0, "filename": "thumbnail.png"}}
+
+	payload, err := json.Marshal(message)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	if err := writer.WriteField("payload_json", string(payload)); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := writer.CreateFormFile("files[0]", "thumbnail.png")
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := file.Write(thumbnail); err != nil {
+		return nil, nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return body.Bytes(), map[string]string{"Content-Type": writer.FormDataContentType()}, nil
+}
+
+// formatNtfy returns ntfy request, thumbnail is sent as attachment with the message in header
+func formatNtfy(notification Notification) ([]byte, map[string]string, error) {
+	headers := map[string]string{
+		"Title": notification.Printer + ": " + notification.Title,
+		"Tags":  notification.Event,
+	}
+
+	if notification.Event == prusalink.JobFailed || notification.Event == EventOffline || notification.Event == EventAttention {
+		headers["Priority"] = "high"
+	}
+
+	if notification.Thumbnail == "" {
+		return []byte(notification.Message), headers, nil
+	}
+
+	thumbnail, err := base64.StdEncoding.DecodeString(notification.Thumbnail)
+
+	if err != nil {
+		return []byte(notification.Message), headers, nil
+	}
+
+	headers["Message"] = strings.ReplaceAll(notification.Message, "\n", `\n`) // ntfy converts escaped new lines back
+	headers["Filename"] = "thumbnail.png"
+
+	return thumbnail, headers, nil
+}
@@ -0,0 +1,151 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+	bridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Exporter periodically pushes prusa_* metrics of every printer to OTLP receiver, each printer is sent as its own resource
+type Exporter struct {
+	printers []config.Printers
+	poller   *prusalink.Poller
+	exporter metric.Exporter
+	interval time.Duration
+}
+
+// New returns a new Exporter for configured protocol, connection to the receiver is established lazily
+func New(config config.Config, poller *prusalink.Poller) (*Exporter, error) {
+	settings := config.Exporter.OTLP
+	ctx := context.Background()
+
+	var exporter metric.Exporter
+	var err error
+
+	switch settings.Protocol {
+	case "grpc":
+		exporter, err = otlpmetricgrpc.New(ctx,
+			otlpmetricgrpc.WithEndpointURL(settings.Endpoint),
+			otlpmetricgrpc.WithHeaders(settings.Headers))
+	case "http":
+		exporter, err = otlpmetrichttp.New(ctx,
+			otlpmetrichttp.WithEndpointURL(settings.Endpoint),
+			otlpmetrichttp.WithHeaders(settings.Headers))
+	default:
+		err = fmt.Errorf("unknown OTLP protocol %s", settings.Protocol)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		printers: config.Printers,
+		poller:   poller,
+		exporter: exporter,
+		interval: time.Duration(settings.Interval),
+	}, nil
+}
+
+// Start starts pushing of metrics in background
+func (exporter *Exporter) Start() {
+	go func() {
+		ticker := time.NewTicker(exporter.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			exporter.push()
+		}
+	}()
+}
+
+// Shutdown flushes and closes the exporter
+func (exporter *Exporter) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := exporter.exporter.Shutdown(ctx); err != nil {
+		log.Error().Msg("Error while shutting down OTLP exporter - " + err.Error())
+	}
+}
+
+// push exports metrics of all printers
+func (exporter *Exporter) push() {
+	for _, printer := range exporter.printers {
+		metrics, err := exporter.collect(printer)
+
+		if err != nil {
+			log.Error().Msg("Error while converting metrics of " + printer.Address + " to OTLP - " + err.Error())
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), exporter.interval)
+		err = exporter.exporter.Export(ctx, metrics)
+		cancel()
+
+		if err != nil {
+			log.Error().Msg("Error while exporting OTLP metrics of " + printer.Address + " - " + err.Error())
+		}
+	}
+}
+
+// collect gathers metrics of single printer from the same collector as /probe endpoint and converts them to OTLP data
+func (exporter *Exporter) collect(printer config.Printers) (*metricdata.ResourceMetrics, error) {
+	registry := prometheus.NewRegistry()
+
+	if err := registry.Register(prusalink.NewProbeCollector(printer, exporter.poller)); err != nil {
+		return nil, err
+	}
+
+	scopes, err := bridge.NewMetricProducer(bridge.WithGatherer(registry)).Produce(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricdata.ResourceMetrics{
+		Resource:     printerResource(printer, exporter.poller),
+		ScopeMetrics: scopes,
+	}, nil
+}
+
+// printerResource returns OTLP resource of the printer, serial and firmware are known after the first successful poll
+func printerResource(printer config.Printers, poller *prusalink.Poller) *resource.Resource {
+	attributes := []attribute.KeyValue{
+		attribute.String("service.name", "prusa_exporter"),
+		attribute.String("printer.address", printer.Address),
+		attribute.String("printer.name", printer.Name),
+		attribute.String("printer.model", printer.Type),
+	}
+
+	if printer.Location != "" {
+		attributes = append(attributes, attribute.String("printer.location", printer.Location))
+	}
+
+	if snapshot, ok := poller.Snapshot(printer.Address); ok {
+		summary := prusalink.GetSummary(snapshot)
+		attributes[3] = attribute.String("printer.model", summary.Model)
+
+		if summary.Serial != "" {
+			attributes = append(attributes, attribute.String("printer.serial", summary.Serial))
+		}
+
+		if summary.Firmware != "" {
+			attributes = append(attributes, attribute.String("printer.firmware", summary.Firmware))
+		}
+	}
+
+	return resource.NewSchemaless(attributes...)
+}
@@ -0,0 +1,215 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	colmetric "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// startPrinter serves PrusaLink fixtures, /api/v1/status is served from v1/status.json
+func startPrinter(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := filepath.Join("..", "prusalink", "api", "buddy", strings.TrimPrefix(r.URL.Path, "/api/")+".json")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// newTestConfig returns configuration with single printer polled once
+func newTestConfig(t *testing.T) config.Config {
+	var cfg config.Config
+	cfg.Exporter.PollInterval = model.Duration(time.Hour)
+	cfg.Exporter.ScrapeTimeout = model.Duration(5 * time.Second)
+	cfg.Exporter.OTLP.Interval = model.Duration(5 * time.Second)
+	cfg.Exporter.OTLP.Headers = map[string]string{"X-Scope-OrgID": "farm"}
+	cfg.Printers = []config.Printers{{Address: startPrinter(t), Name: "mk4", Type: "MK4", Apikey: "secret", Location: "lab"}}
+
+	return cfg
+}
+
+// startPoller starts the poller and waits for the first successful poll
+func startPoller(t *testing.T, cfg config.Config) *prusalink.Poller {
+	t.Helper()
+
+	poller := prusalink.NewPoller(cfg)
+	poller.Start()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if snapshot, ok := poller.Snapshot(cfg.Printers[0].Address); ok && snapshot.Up {
+			return poller
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("printer was not polled")
+	return nil
+}
+
+// checkRequest checks resource attributes and metrics of the exported printer
+func checkRequest(t *testing.T, request *colmetric.ExportMetricsServiceRequest, address string) {
+	t.Helper()
+
+	if len(request.GetResourceMetrics()) != 1 {
+		t.Fatalf("request has %d resources, expected 1", len(request.GetResourceMetrics()))
+	}
+
+	resourceMetrics := request.GetResourceMetrics()[0]
+	attributes := map[string]string{}
+	for _, attribute := range resourceMetrics.GetResource().GetAttributes() {
+		attributes[attribute.GetKey()] = attribute.GetValue().GetStringValue()
+	}
+
+	expected := map[string]string{
+		"service.name":     "prusa_exporter",
+		"printer.address":  address,
+		"printer.name":     "mk4",
+		"printer.location": "lab",
+		"printer.model":    "MK4",
+	}
+
+	for key, value := range expected {
+		if attributes[key] != value {
+			t.Errorf("resource attribute %s = %q, expected %q", key, attributes[key], value)
+		}
+	}
+
+	if attributes["printer.serial"] == "" {
+		t.Errorf("resource attributes = %v, expected serial from the poll", attributes)
+	}
+
+	names := map[string]bool{}
+	for _, scope := range resourceMetrics.GetScopeMetrics() {
+		for _, metric := range scope.GetMetrics() {
+			names[metric.GetName()] = true
+		}
+	}
+
+	for _, name := range []string{"prusa_up", "prusa_temperature_celsius", "prusa_printer_state"} {
+		if !names[name] {
+			t.Errorf("metric %s was not exported, got %d metrics", name, len(names))
+		}
+	}
+}
+
+func TestExportHTTP(t *testing.T) {
+	cfg := newTestConfig(t)
+	poller := startPoller(t, cfg)
+
+	requests := make(chan *colmetric.ExportMetricsServiceRequest, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("X-Scope-OrgID") != "farm" {
+			t.Errorf("request to %s with headers %v, expected /v1/metrics with configured header", r.URL.Path, r.Header)
+		}
+
+		request := &colmetric.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			t.Error(err)
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		requests <- request
+	}))
+	defer receiver.Close()
+
+	cfg.Exporter.OTLP.Protocol = "http"
+	cfg.Exporter.OTLP.Endpoint = receiver.URL + "/v1/metrics"
+
+	exporter, err := New(cfg, poller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Shutdown()
+
+	exporter.push()
+
+	select {
+	case request := <-requests:
+		checkRequest(t, request, cfg.Printers[0].Address)
+	case <-time.After(5 * time.Second):
+		t.Fatal("metrics were not exported")
+	}
+}
+
+// metricsService is OTLP gRPC receiver passing requests to the channel
+type metricsService struct {
+	colmetric.UnimplementedMetricsServiceServer
+	requests chan *colmetric.ExportMetricsServiceRequest
+}
+
+// Export receives metrics
+func (service *metricsService) Export(_ context.Context, request *colmetric.ExportMetricsServiceRequest) (*colmetric.ExportMetricsServiceResponse, error) {
+	service.requests <- request
+	return &colmetric.ExportMetricsServiceResponse{}, nil
+}
+
+func TestExportGRPC(t *testing.T) {
+	cfg := newTestConfig(t)
+	poller := startPoller(t, cfg)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &metricsService{requests: make(chan *colmetric.ExportMetricsServiceRequest, 1)}
+	server := grpc.NewServer()
+	colmetric.RegisterMetricsServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	cfg.Exporter.OTLP.Protocol = "grpc"
+	cfg.Exporter.OTLP.Endpoint = "http://" + listener.Addr().String()
+
+	exporter, err := New(cfg, poller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Shutdown()
+
+	exporter.push()
+
+	select {
+	case request := <-service.requests:
+		checkRequest(t, request, cfg.Printers[0].Address)
+	case <-time.After(5 * time.Second):
+		t.Fatal("metrics were not exported")
+	}
+}
+
+func TestUnknownProtocol(t *testing.T) {
+	var cfg config.Config
+	cfg.Exporter.OTLP.Protocol = "udp"
+
+	if _, err := New(cfg, nil); err == nil {
+		t.Error("New() returned no error for unknown protocol")
+	}
+}
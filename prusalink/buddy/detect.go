@@ -0,0 +1,316 @@
+package prusalink
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Confidence levels of printer model detection
+const (
+	ConfidenceNone       = "none"
+	ConfidenceLow        = "low"
+	ConfidenceMedium     = "medium"
+	ConfidenceHigh       = "high"
+	ConfidenceConfigured = "configured"
+)
+
+// Detection is the result of printer model detection
+type Detection struct {
+	Model      string
+	Confidence string
+	Tools      int // number of extruders of the current printer profile, more than one is voted as XL
+}
+
+// modelVote is a single hint about printer model with its weight
+type modelVote struct {
+	model  string
+	weight int
+	source string
+}
+
+var (
+	// exact values of /api/version original field
+	originalModels = map[string]string{
+		"PrusaLink I3MK3S":  "I3MK3S",
+		"PrusaLink I3MK3":   "I3MK3",
+		"PrusaLink I3MK25S": "I3MK25S",
+		"PrusaLink I3MK25":  "I3MK25",
+	}
+
+	// default hostnames of printers in lower case - does not work with changed hostname, other hints are needed then
+	hostnameModels = map[string]string{
+		"prusamini":      "MINI",
+		"prusa-mini":     "MINI",
+		"prusamk4":       "MK4", // older firmware uses the same hostname for MK3.5, MK3.9 and MK4S
+		"prusa-mk4":      "MK4",
+		"prusa-mk4s":     "MK4S",
+		"prusa-mk35":     "MK35",
+		"prusa-mk39":     "MK39",
+		"prusaxl":        "XL",
+		"prusa-xl":       "XL",
+		"prusa-coreone":  "COREONE",
+		"prusa-core-one": "COREONE",
+		"prusa_ix":       "IX", // can be found in src/common/config.h in firmware source code
+		"prusa-ix":       "IX",
+		"prusa-sl1":      "SL1",
+		"prusa-sl1s":     "SL1S",
+	}
+
+	// hostnames shared by more printer models
+	ambiguousHostnames = map[string]bool{
+		"prusamk4": true,
+	}
+
+	// substrings of printer profile model, ordered from the most specific
+	profileModels = []struct {
+		needle string
+		model  string
+	}{
+		{"SL1S", "SL1S"},
+		{"SLA", "SL1"},
+		{"COREONE", "COREONE"},
+		{"MK4S", "MK4S"},
+		{"MK4", "MK4"},
+		{"MK3.9", "MK39"},
+		{"MK3.5", "MK35"},
+		{"MINI", "MINI"},
+		{"XL", "XL"},
+		{"MK3S", "I3MK3S"},
+		{"MK2.5S", "I3MK25S"},
+		{"MK2.5", "I3MK25"},
+		{"MK3", "I3MK3"},
+	}
+
+	// printer type codes reported in printer field of /api/version and /api/v1/info - type.version.subversion as used by Prusa Connect
+	printerTypeModels = map[string]string{
+		"1.2.5": "I3MK25",
+		"1.2.6": "I3MK25S",
+		"1.3.0": "I3MK3",
+		"1.3.1": "I3MK3S",
+		"1.3.5": "MK35",
+		"1.3.9": "MK39",
+		"1.4.0": "MK4",
+		"1.4.1": "MK4S",
+		"2.1.0": "MINI",
+		"3.1.0": "XL",
+		"4.1.0": "IX",
+		"5.1.0": "SL1",
+		"5.1.1": "SL1S",
+		"7.1.0": "COREONE",
+	}
+
+	// product codes from serial numbers in format CZPXwwyyXcccX..., collected from known printers
+	// MK3.5, MK3.9, MK4 and MK4S share the code of the i3 family, so the code alone only narrows Buddy printers down to MK4
+	serialModels = map[string]string{
+		"004": "I3MK3S",
+		"017": "MINI",
+	}
+
+	// serial codes shared by Einsy and Buddy printers, the model is chosen by firmware
+	buddySerialModels = map[string]string{
+		"004": "MK4",
+	}
+
+	// models running on Einsy boards, used to rule out hints not matching the firmware
+	einsyModels = map[string]bool{
+		"I3MK3S":  true,
+		"I3MK3":   true,
+		"I3MK25S": true,
+		"I3MK25":  true,
+	}
+
+	detections      = map[string]cachedDetection{}
+	detectionsMutex sync.Mutex
+)
+
+// cachedDetection is a detection of the printer model with time when it was done
+type cachedDetection struct {
+	Detection
+	time time.Time
+}
+
+// DetectPrinterModel combines /api/version, /api/v1/info, printer profiles and serial number to detect model of the printer
+func DetectPrinterModel(printer config.Printers) (Detection, error) {
+	version, err := GetVersion(printer)
+	if err != nil {
+		return Detection{Model: "unknown", Confidence: ConfidenceNone}, err
+	}
+
+	info, err := GetInfo(printer)
+	if err != nil {
+		log.Debug().Msg("Info endpoint not available for model detection at " + printer.Address + " - " + err.Error())
+	}
+
+	profiles, err := GetPrinterProfiles(printer)
+	if err != nil {
+		log.Debug().Msg("Printer profiles endpoint not available for model detection at " + printer.Address + " - " + err.Error())
+	}
+
+	detection := detectModel(version, info, profiles)
+
+	log.Trace().Msg(detection.Model + " detected for " + printer.Address + " (" + printer.Name + ") with " + detection.Confidence + " confidence")
+
+	return detection, nil
+}
+
+// detectModel evaluates all model hints and returns model with the highest weight
+func detectModel(version Version, info Info, profiles PrinterProfiles) Detection {
+	var votes []modelVote
+
+	if model, ok := originalModels[version.Original]; ok {
+		votes = append(votes, modelVote{model, 3, "original"})
+	}
+
+	for _, code := range []string{version.Printer, info.Printer} {
+		if model, ok := printerTypeModels[code]; ok {
+			votes = append(votes, modelVote{model, 3, "printer type"})
+			break
+		}
+	}
+
+	for _, hostname := range []string{version.Hostname, info.Hostname} {
+		if model, ok := hostnameModels[strings.ToLower(hostname)]; ok {
+			weight := 3
+			if ambiguousHostnames[strings.ToLower(hostname)] {
+				weight = 2
+			}
+			votes = append(votes, modelVote{model, weight, "hostname"})
+			break
+		}
+	}
+
+	tools := 0
+	for _, profile := range profiles.Profiles {
+		if !profile.Current {
+			continue
+		}
+		tools = int(profile.Extruder.Count)
+		for _, candidate := range profileModels {
+			if strings.Contains(strings.ToUpper(strings.ReplaceAll(profile.Model, " ", "")), candidate.needle) {
+				votes = append(votes, modelVote{candidate.model, 2, "profile"})
+				break
+			}
+		}
+	}
+
+	// only XL has more toolheads, MMU is reported as single extruder
+	if tools > 1 {
+		votes = append(votes, modelVote{"XL", 2, "tools"})
+	}
+
+	code := getSerialProductCode(info.Serial)
+	if model, ok := buddySerialModels[code]; ok && version.Firmware != "" && !isEinsyFirmware(version.Firmware) {
+		votes = append(votes, modelVote{model, 1, "serial"})
+	} else if model, ok := serialModels[code]; ok {
+		votes = append(votes, modelVote{model, 1, "serial"})
+	}
+
+	weights := map[string]int{}
+	best := ""
+	for _, vote := range votes {
+		if isEinsyFirmware(version.Firmware) != einsyModels[vote.model] && version.Firmware != "" {
+			log.Trace().Msg("Ignoring " + vote.model + " from " + vote.source + " because of firmware " + version.Firmware)
+			continue
+		}
+		weights[vote.model] += vote.weight
+		if best == "" || weights[vote.model] > weights[best] {
+			best = vote.model
+		}
+	}
+
+	if best == "" {
+		return Detection{Model: "unknown", Confidence: ConfidenceNone, Tools: tools}
+	}
+
+	confidence := ConfidenceLow
+	if weights[best] >= 3 {
+		confidence = ConfidenceHigh
+	} else if weights[best] == 2 {
+		confidence = ConfidenceMedium
+	}
+
+	if len(weights) > 1 && confidence != ConfidenceLow {
+		if confidence == ConfidenceHigh {
+			confidence = ConfidenceMedium
+		} else {
+			confidence = ConfidenceLow
+		}
+	}
+
+	return Detection{Model: best, Confidence: confidence, Tools: tools}
+}
+
+// isUncertain returns true for confidence levels that are only a guess of the model
+func isUncertain(confidence string) bool {
+	return confidence == ConfidenceNone || confidence == ConfidenceLow
+}
+
+// NormalizeModel returns comparable model name - "MK3SMMU3" and "I3MK3S" are both "MK3S", "MK4IS" is "MK4", "MK3.9" is "MK39"
+func NormalizeModel(model string) string {
+	model = strings.NewReplacer(".", "", " ", "", "-", "", "_", "").Replace(strings.ToUpper(model))
+	model = strings.TrimPrefix(model, "I3")
+
+	if index := strings.Index(model, "MMU"); index > 0 {
+		model = model[:index]
+	}
+
+	if strings.HasPrefix(model, "XL") { // XL2IS, XL5IS, ... are sliced for multi-tool XL
+		return "XL"
+	}
+
+	return strings.TrimSuffix(model, "IS")
+}
+
+// IsModelMismatch returns true if gcode was sliced for different printer model than the given one, unknown models never mismatch
+func IsModelMismatch(slicedFor string, model string) bool {
+	if slicedFor == "" || model == "" || model == "unknown" {
+		return false
+	}
+
+	return NormalizeModel(slicedFor) != NormalizeModel(model)
+}
+
+// getSerialProductCode returns product code from serial number in format CZPXwwyyXcccX...
+func getSerialProductCode(serial string) string {
+	if len(serial) < 13 || !strings.HasPrefix(serial, "CZPX") || serial[8] != 'X' || serial[12] != 'X' {
+		return ""
+	}
+
+	return serial[9:12]
+}
+
+// isEinsyFirmware returns true for firmware of Einsy boards - 3.x.x, Buddy boards use 4.x.x and higher
+func isEinsyFirmware(firmware string) bool {
+	return strings.HasPrefix(firmware, "3.")
+}
+
+// getDetection returns cached detection of the printer model, failed detections are not cached
+// unknown models and models detected with low confidence are detected again with metadata refresh, printer may have answered incompletely
+func getDetection(printer config.Printers) Detection {
+	detectionsMutex.Lock()
+	cached, ok := detections[printer.Address]
+	detectionsMutex.Unlock()
+
+	if ok && (!isUncertain(cached.Confidence) || time.Since(cached.time) < time.Duration(configuration.Exporter.Refresh.Metadata)) {
+		return cached.Detection
+	}
+
+	detection, err := DetectPrinterModel(printer)
+	if err != nil {
+		log.Error().Msg("Error while detecting model of printer at " + printer.Address + " - " + err.Error())
+		if ok {
+			return cached.Detection
+		}
+		return detection
+	}
+
+	detectionsMutex.Lock()
+	detections[printer.Address] = cachedDetection{Detection: detection, time: time.Now()}
+	detectionsMutex.Unlock()
+
+	return detection
+}
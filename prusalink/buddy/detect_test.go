@@ -0,0 +1,334 @@
+package prusalink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+func profilesOf(t *testing.T, model string, extruders int) PrinterProfiles {
+	t.Helper()
+
+	var profiles PrinterProfiles
+	data := `{"profiles":[{"current":true,"model":"` + model + `","extruder":{"count":` + strconv.Itoa(extruders) + `}}]}`
+
+	if err := json.Unmarshal([]byte(data), &profiles); err != nil {
+		t.Fatal(err)
+	}
+
+	return profiles
+}
+
+func TestDetectModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    Version
+		info       Info
+		profile    string
+		extruders  int
+		model      string
+		confidence string
+	}{
+		{
+			name:       "nothing known",
+			model:      "unknown",
+			confidence: ConfidenceNone,
+		},
+		{
+			name:       "einsy original",
+			version:    Version{Original: "PrusaLink I3MK3S", Firmware: "3.14.1"},
+			model:      "I3MK3S",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "einsy original with profile",
+			version:    Version{Original: "PrusaLink I3MK25S", Firmware: "3.14.1"},
+			profile:    "Original Prusa i3 MK2.5S",
+			extruders:  1,
+			model:      "I3MK25S",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "buddy printer type code",
+			version:    Version{Printer: "1.4.1", Hostname: "prusamk4", Firmware: "6.2.0"},
+			model:      "MK4S",
+			confidence: ConfidenceMedium,
+		},
+		{
+			name:       "printer type code in info",
+			version:    Version{Hostname: "farm-3", Firmware: "6.2.0"},
+			info:       Info{Printer: "7.1.0"},
+			model:      "COREONE",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "shared mk4 hostname",
+			version:    Version{Hostname: "prusamk4", Firmware: "6.1.0"},
+			model:      "MK4",
+			confidence: ConfidenceMedium,
+		},
+		{
+			name:       "mk39 hostname",
+			version:    Version{Hostname: "prusa-mk39", Firmware: "6.1.0"},
+			model:      "MK39",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "changed hostname with xl profile",
+			version:    Version{Hostname: "left-corner", Firmware: "6.1.0"},
+			profile:    "XL",
+			extruders:  5,
+			model:      "XL",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "changed hostname with core one profile",
+			version:    Version{Hostname: "left-corner", Firmware: "6.2.0"},
+			profile:    "Core One",
+			extruders:  1,
+			model:      "COREONE",
+			confidence: ConfidenceMedium,
+		},
+		{
+			name:       "buddy serial only",
+			version:    Version{Hostname: "left-corner", Firmware: "6.1.0"},
+			info:       Info{Serial: "CZPX4523X004XK12345"},
+			model:      "MK4",
+			confidence: ConfidenceLow,
+		},
+		{
+			name:       "einsy serial only",
+			version:    Version{Firmware: "3.14.1"},
+			info:       Info{Serial: "CZPX4523X004XK12345"},
+			model:      "I3MK3S",
+			confidence: ConfidenceLow,
+		},
+		{
+			name:       "mini serial",
+			version:    Version{Hostname: "left-corner", Firmware: "6.1.0"},
+			info:       Info{Serial: "CZPX2320X017XC01234"},
+			model:      "MINI",
+			confidence: ConfidenceLow,
+		},
+		{
+			name:       "einsy hint ignored on buddy firmware",
+			version:    Version{Original: "PrusaLink I3MK3S", Hostname: "prusa-xl", Firmware: "6.1.0"},
+			model:      "XL",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "printer type code wins over renamed hostname",
+			version:    Version{Hostname: "prusa-mk39", Printer: "1.4.0", Firmware: "6.1.0"},
+			model:      "MK4",
+			confidence: ConfidenceMedium,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var profiles PrinterProfiles
+			if test.profile != "" {
+				profiles = profilesOf(t, test.profile, test.extruders)
+			}
+
+			detection := detectModel(test.version, test.info, profiles)
+
+			if detection.Model != test.model || detection.Confidence != test.confidence {
+				t.Errorf("detectModel() = %s with %s confidence, expected %s with %s confidence", detection.Model, detection.Confidence, test.model, test.confidence)
+			}
+
+			if detection.Tools != test.extruders {
+				t.Errorf("detectModel() tools = %d, expected %d", detection.Tools, test.extruders)
+			}
+		})
+	}
+}
+
+func TestGetSerialProductCode(t *testing.T) {
+	tests := []struct {
+		serial string
+		code   string
+	}{
+		{"CZPX4523X004XK12345", "004"},
+		{"CZPX2320X017XC01234", "017"},
+		{"CZPX2320X017X", "017"},
+		{"CZPX2320X017", ""},
+		{"SN12345678901234", ""},
+		{"CZPX2320Y017XC01234", ""},
+		{"CZPX2320X017YC01234", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if code := getSerialProductCode(test.serial); code != test.code {
+			t.Errorf("getSerialProductCode(%q) = %q, expected %q", test.serial, code, test.code)
+		}
+	}
+}
+
+func TestIsEinsyFirmware(t *testing.T) {
+	tests := []struct {
+		firmware string
+		einsy    bool
+	}{
+		{"3.14.1-7860", true},
+		{"3.10.0", true},
+		{"4.7.2", false},
+		{"6.2.0+8899", false},
+		{"", false},
+		{"13.0.0", false},
+	}
+
+	for _, test := range tests {
+		if einsy := isEinsyFirmware(test.firmware); einsy != test.einsy {
+			t.Errorf("isEinsyFirmware(%q) = %t, expected %t", test.firmware, einsy, test.einsy)
+		}
+	}
+}
+
+// detectionPrinter returns test printer answering version with the hostname and legacy profiles endpoint with the profile, other endpoints are not found
+func detectionPrinter(t *testing.T, hostname *atomic.Value, versions *atomic.Int32, profile string) config.Printers {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			versions.Add(1)
+			w.Write([]byte(`{"hostname":"` + hostname.Load().(string) + `"}`))
+		case "/api/printerprofiles":
+			if profile == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"profiles":[{"current":true,"model":"` + profile + `","extruder":{"count":1}}]}`))
+		default:
+			// JSON error page must not be read as the endpoint data
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"title":"Not Found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return config.Printers{Address: strings.TrimPrefix(server.URL, "http://"), ScrapeTimeout: model.Duration(time.Second)}
+}
+
+func TestGetPrinterProfilesFallback(t *testing.T) {
+	var hostname atomic.Value
+	hostname.Store("")
+	printer := detectionPrinter(t, &hostname, &atomic.Int32{}, "Original Prusa MK4S")
+
+	profiles, err := GetPrinterProfiles(printer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(profiles.Profiles) != 1 || profiles.Profiles[0].Model != "Original Prusa MK4S" {
+		t.Errorf("GetPrinterProfiles() = %+v, expected profile from /api/printerprofiles", profiles)
+	}
+
+	if _, err := GetInfo(printer); err == nil {
+		t.Errorf("GetInfo() returned no error for 404")
+	}
+}
+
+func TestGetDetectionRefresh(t *testing.T) {
+	configuration.Exporter.Refresh.Metadata = model.Duration(time.Hour)
+	defer func() { configuration.Exporter.Refresh.Metadata = 0 }()
+
+	var hostname atomic.Value
+	var versions atomic.Int32
+	hostname.Store("printer")
+	printer := detectionPrinter(t, &hostname, &versions, "")
+
+	backdate := func() {
+		detectionsMutex.Lock()
+		cached := detections[printer.Address]
+		cached.time = cached.time.Add(-2 * time.Hour)
+		detections[printer.Address] = cached
+		detectionsMutex.Unlock()
+	}
+
+	first := getDetection(printer)
+	getDetection(printer)
+
+	if versions.Load() != 1 {
+		t.Errorf("printer was detected %d times within metadata refresh, expected once", versions.Load())
+	}
+
+	// printer answers completely after restart of its network stack
+	hostname.Store("prusa-mk39")
+	backdate()
+
+	if detection := getDetection(printer); detection.Model != "MK39" || versions.Load() != 2 {
+		t.Errorf("getDetection() = %+v after %d detections, expected MK39 detected again after %+v", detection, versions.Load(), first)
+	}
+
+	backdate()
+	getDetection(printer)
+
+	if versions.Load() != 2 {
+		t.Errorf("confident detection was repeated, %d detections", versions.Load())
+	}
+}
+
+func TestNormalizeModel(t *testing.T) {
+	tests := []struct {
+		model      string
+		normalized string
+	}{
+		{"MK4", "MK4"},
+		{"MK4IS", "MK4"},
+		{"MK4S", "MK4S"},
+		{"MK3.9", "MK39"},
+		{"MK39IS", "MK39"},
+		{"MK3SMMU3", "MK3S"},
+		{"I3MK3S", "MK3S"},
+		{"COREONE", "COREONE"},
+		{"Core One", "COREONE"},
+		{"core-one", "COREONE"},
+		{"XL5IS", "XL"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if normalized := NormalizeModel(test.model); normalized != test.normalized {
+			t.Errorf("NormalizeModel(%q) = %q, expected %q", test.model, normalized, test.normalized)
+		}
+	}
+}
+
+func TestIsModelMismatch(t *testing.T) {
+	tests := []struct {
+		slicedFor string
+		model     string
+		mismatch  bool
+	}{
+		{"MK4IS", "MK4", false},
+		{"MK4", "MK4S", true},
+		{"MK4S", "MK4", true},
+		{"MK3.9", "MK4", true},
+		{"MK3.9", "MK39", false},
+		{"MK4IS", "MK39", true},
+		{"COREONE", "COREONE", false},
+		{"Core One", "COREONE", false},
+		{"XL2IS", "XL", false},
+		{"MK3SMMU3", "I3MK3S", false},
+		{"", "MK4", false},
+		{"MK4", "", false},
+		{"MK4", "unknown", false},
+	}
+
+	for _, test := range tests {
+		if mismatch := IsModelMismatch(test.slicedFor, test.model); mismatch != test.mismatch {
+			t.Errorf("IsModelMismatch(%q, %q) = %t, expected %t", test.slicedFor, test.model, mismatch, test.mismatch)
+		}
+	}
+}
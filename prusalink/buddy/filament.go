@@ -0,0 +1,194 @@
+package prusalink
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilamentCounters is a struct that contains amount and cost of used filament
+type FilamentCounters struct {
+	Grams  float64 `json:"grams"`
+	Meters float64 `json:"meters"`
+	Cost   float64 `json:"cost"`
+}
+
+// FilamentUsage is a struct that contains filament usage of single tool from /api/job
+type FilamentUsage struct {
+	Length float64 `json:"length"` // in millimeters
+	Volume float64 `json:"volume"` // in cubic centimeters
+}
+
+// MetaValues is a list of per extruder values from gcode metadata - single number or comma separated string is reported
+type MetaValues []float64
+
+// UnmarshalJSON parses number or string with comma or semicolon separated numbers, null is no value
+func (values *MetaValues) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*values = nil
+		return nil
+	}
+
+	var number float64
+
+	if err := json.Unmarshal(data, &number); err == nil {
+		*values = MetaValues{number}
+		return nil
+	}
+
+	var text string
+
+	if err := json.Unmarshal(data, &text); err != nil {
+		*values = nil
+		return nil // unknown format is ignored to keep the rest of the job readable
+	}
+
+	*values = nil
+
+	for _, part := range strings.FieldsFunc(text, isListSeparator) {
+		if value, err := strconv.ParseFloat(strings.TrimSpace(part), 64); err == nil {
+			*values = append(*values, value)
+		}
+	}
+
+	return nil
+}
+
+// Sum returns sum of all values
+func (values MetaValues) Sum() float64 {
+	sum := 0.0
+
+	for _, value := range values {
+		sum += value
+	}
+
+	return sum
+}
+
+// densities of common materials in g/cm3, can be overridden in configuration
+var defaultDensities = map[string]float64{
+	"PLA":  1.24,
+	"PETG": 1.27,
+	"PCTG": 1.23,
+	"ASA":  1.07,
+	"ABS":  1.04,
+	"PC":   1.20,
+	"PA":   1.14,
+	"FLEX": 1.21,
+	"TPU":  1.21,
+	"HIPS": 1.04,
+	"PVA":  1.23,
+	"PP":   0.90,
+}
+
+// getMaterialDensity returns density of the material in g/cm3, PLA density is used for unknown materials
+func getMaterialDensity(material string) float64 {
+	if settings, ok := configuration.Exporter.Filament.Materials[material]; ok && settings.Density > 0 {
+		return settings.Density
+	}
+
+	if density, ok := defaultDensities[material]; ok {
+		return density
+	}
+
+	return defaultDensities["PLA"]
+}
+
+// getMaterialPrice returns price of the material per kilogram
+func getMaterialPrice(material string) float64 {
+	return configuration.Exporter.Filament.Materials[material].Price
+}
+
+// getJobFilament returns estimated filament usage of the whole job per material - gcode metadata are preferred, /api/job is used otherwise
+func getJobFilament(snapshot Snapshot) map[string]FilamentCounters {
+	meta := snapshot.JobV1.File.Meta
+	grams, millimeters, volumes := meta.FilamentUsedGrams, meta.FilamentUsedMillimeters, meta.FilamentUsedVolume
+
+	if len(grams) == 0 && len(millimeters) == 0 && len(volumes) == 0 {
+		tools := make([]string, 0, len(snapshot.Job.Job.Filament))
+		for tool := range snapshot.Job.Job.Filament {
+			tools = append(tools, tool)
+		}
+		sort.Strings(tools)
+
+		for _, tool := range tools {
+			millimeters = append(millimeters, snapshot.Job.Job.Filament[tool].Length)
+			volumes = append(volumes, snapshot.Job.Job.Filament[tool].Volume)
+		}
+	}
+
+	materials := strings.FieldsFunc(strings.ToUpper(meta.FilamentType), isListSeparator)
+	if len(materials) == 0 && snapshot.Printer.Telemetry.Material != "" && !strings.Contains(snapshot.Printer.Telemetry.Material, "-") {
+		materials = []string{strings.ToUpper(snapshot.Printer.Telemetry.Material)}
+	}
+	if len(materials) == 0 {
+		materials = []string{"UNKNOWN"}
+	}
+
+	diameter := configuration.Exporter.Filament.Diameter
+	if diameter == 0 {
+		diameter = 1.75
+	}
+	area := math.Pi * diameter * diameter / 4 // in mm2
+
+	usage := map[string]FilamentCounters{}
+	extruders := max(len(grams), len(millimeters), len(volumes))
+
+	for i := 0; i < extruders; i++ {
+		material := strings.TrimSpace(materials[min(i, len(materials)-1)])
+		density := getMaterialDensity(material)
+		length, weight := valueAt(millimeters, i), valueAt(grams, i)
+
+		if length == 0 && valueAt(volumes, i) > 0 {
+			length = valueAt(volumes, i) * 1000 / area
+		}
+		if length == 0 && weight > 0 {
+			length = weight / density * 1000 / area
+		}
+		if weight == 0 {
+			weight = length * area / 1000 * density
+		}
+		if weight == 0 {
+			continue
+		}
+
+		counters := usage[material]
+		counters.Grams += weight
+		counters.Meters += length / 1000
+		counters.Cost += weight / 1000 * getMaterialPrice(material)
+		usage[material] = counters
+	}
+
+	return usage
+}
+
+// scaleFilament returns filament usage multiplied by ratio, used for jobs that did not finish
+func scaleFilament(usage map[string]FilamentCounters, ratio float64) map[string]FilamentCounters {
+	scaled := map[string]FilamentCounters{}
+
+	for material, counters := range usage {
+		scaled[material] = FilamentCounters{
+			Grams:  counters.Grams * ratio,
+			Meters: counters.Meters * ratio,
+			Cost:   counters.Cost * ratio,
+		}
+	}
+
+	return scaled
+}
+
+// valueAt returns value at index or zero
+func valueAt(values []float64, index int) float64 {
+	if index < len(values) {
+		return values[index]
+	}
+
+	return 0
+}
+
+// isListSeparator returns true for separators of per extruder values in gcode metadata
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ';'
+}
@@ -0,0 +1,255 @@
+package prusalink
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// area of 1.75 mm filament cross section in mm2
+var filamentArea = math.Pi * 1.75 * 1.75 / 4
+
+// equalFilament returns true if usage is equal to expected within rounding error
+func equalFilament(usage map[string]FilamentCounters, expected map[string]FilamentCounters) bool {
+	if len(usage) != len(expected) {
+		return false
+	}
+
+	for material, counters := range expected {
+		actual, ok := usage[material]
+		if !ok || math.Abs(actual.Grams-counters.Grams) > 1e-9 || math.Abs(actual.Meters-counters.Meters) > 1e-9 || math.Abs(actual.Cost-counters.Cost) > 1e-9 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestMetaValuesUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		values MetaValues
+	}{
+		{"number", `3.72`, MetaValues{3.72}},
+		{"comma separated", `"1234.56, 0.00, 78.9"`, MetaValues{1234.56, 0, 78.9}},
+		{"semicolon separated", `"1.5;2.5"`, MetaValues{1.5, 2.5}},
+		{"single value string", `"12.5"`, MetaValues{12.5}},
+		{"invalid parts are skipped", `"1.5, n/a, 2"`, MetaValues{1.5, 2}},
+		{"empty string", `""`, nil},
+		{"null", `null`, nil},
+		{"unknown format", `{"tool0":1}`, nil},
+	}
+
+	for _, test := range tests {
+		values := MetaValues{42} // previous value is always replaced
+
+		if err := json.Unmarshal([]byte(test.data), &values); err != nil {
+			t.Errorf("%s: UnmarshalJSON(%s) returned error %v", test.name, test.data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: UnmarshalJSON(%s) = %v, expected %v", test.name, test.data, values, test.values)
+		}
+	}
+}
+
+func TestGetJobFilament(t *testing.T) {
+	pla, petg := defaultDensities["PLA"], defaultDensities["PETG"]
+
+	tests := []struct {
+		name      string
+		meta      string
+		filament  map[string]FilamentUsage // /api/job of legacy firmware
+		material  string                   // material loaded in the printer
+		materials map[string]config.Material
+		diameter  float64
+		expected  map[string]FilamentCounters
+	}{
+		{
+			name:     "scalar values",
+			meta:     `{"filament_type":"PLA","filament used [mm]":1000,"filament used [g]":3}`,
+			expected: map[string]FilamentCounters{"PLA": {Grams: 3, Meters: 1}},
+		},
+		{
+			name:     "weight from length",
+			meta:     `{"filament_type":"PETG","filament used [mm]":"1000"}`,
+			expected: map[string]FilamentCounters{"PETG": {Grams: filamentArea * petg, Meters: 1}},
+		},
+		{
+			name:     "length from volume",
+			meta:     `{"filament_type":"PLA","filament used [cm3]":2.4}`,
+			expected: map[string]FilamentCounters{"PLA": {Grams: 2.4 * pla, Meters: 2.4 / filamentArea}},
+		},
+		{
+			name:     "length from weight",
+			meta:     `{"filament_type":"PETG","filament used [g]":5}`,
+			expected: map[string]FilamentCounters{"PETG": {Grams: 5, Meters: 5 / petg / filamentArea}},
+		},
+		{
+			name:     "unknown material has density of PLA",
+			meta:     `{"filament_type":"PEKK","filament used [mm]":1000}`,
+			expected: map[string]FilamentCounters{"PEKK": {Grams: filamentArea * pla, Meters: 1}},
+		},
+		{
+			name:     "multi-material",
+			meta:     `{"filament_type":"PLA;PETG","filament used [mm]":"1000.00, 500.00","filament used [g]":"3.00, 1.50"}`,
+			expected: map[string]FilamentCounters{"PLA": {Grams: 3, Meters: 1}, "PETG": {Grams: 1.5, Meters: 0.5}},
+		},
+		{
+			name:     "tools with the same material are summed",
+			meta:     `{"filament_type":"pla,pla,petg","filament used [g]":"1, 2, 0.5","filament used [mm]":"300, 600, 150"}`,
+			expected: map[string]FilamentCounters{"PLA": {Grams: 3, Meters: 0.9}, "PETG": {Grams: 0.5, Meters: 0.15}},
+		},
+		{
+			name:     "last material is used for remaining tools",
+			meta:     `{"filament_type":"PETG","filament used [g]":"1, 2","filament used [mm]":"300, 600"}`,
+			expected: map[string]FilamentCounters{"PETG": {Grams: 3, Meters: 0.9}},
+		},
+		{
+			name:     "unused tools are skipped",
+			meta:     `{"filament_type":"PLA;PETG","filament used [g]":"2, 0","filament used [mm]":"700, 0"}`,
+			expected: map[string]FilamentCounters{"PLA": {Grams: 2, Meters: 0.7}},
+		},
+		{
+			name:     "legacy job with loaded material",
+			meta:     `{}`,
+			filament: map[string]FilamentUsage{"tool1": {Volume: 1}, "tool0": {Length: 1000}},
+			material: "petg",
+			expected: map[string]FilamentCounters{"PETG": {Grams: filamentArea*petg + petg, Meters: 1 + 1/filamentArea}},
+		},
+		{
+			name:     "legacy job without material",
+			meta:     `{}`,
+			filament: map[string]FilamentUsage{"tool0": {Length: 1000}},
+			material: " - ",
+			expected: map[string]FilamentCounters{"UNKNOWN": {Grams: filamentArea * pla, Meters: 1}},
+		},
+		{
+			name:     "missing weight falls back to /api/job",
+			meta:     `{"filament_type":"PLA","filament used [g]":null}`,
+			filament: map[string]FilamentUsage{"tool0": {Length: 1000}},
+			expected: map[string]FilamentCounters{"PLA": {Grams: filamentArea * pla, Meters: 1}},
+		},
+		{
+			name:     "missing usage",
+			meta:     `{"filament_type":"PLA"}`,
+			expected: map[string]FilamentCounters{},
+		},
+		{
+			name:      "configured density and price",
+			meta:      `{"filament_type":"PETG","filament used [mm]":1000}`,
+			materials: map[string]config.Material{"PETG": {Density: 1.5, Price: 20}},
+			expected:  map[string]FilamentCounters{"PETG": {Grams: filamentArea * 1.5, Meters: 1, Cost: filamentArea * 1.5 / 1000 * 20}},
+		},
+		{
+			name:      "price without density",
+			meta:      `{"filament_type":"PLA","filament used [g]":500,"filament used [mm]":1000}`,
+			materials: map[string]config.Material{"PLA": {Price: 25}},
+			expected:  map[string]FilamentCounters{"PLA": {Grams: 500, Meters: 1, Cost: 12.5}},
+		},
+		{
+			name:     "configured diameter",
+			meta:     `{"filament_type":"PLA","filament used [cm3]":1}`,
+			diameter: 2.85,
+			expected: map[string]FilamentCounters{"PLA": {Grams: pla, Meters: 1 / (math.Pi * 2.85 * 2.85 / 4)}},
+		},
+	}
+
+	defer func() { configuration.Exporter.Filament = config.Config{}.Exporter.Filament }()
+
+	for _, test := range tests {
+		configuration.Exporter.Filament.Materials = test.materials
+		configuration.Exporter.Filament.Diameter = test.diameter
+
+		var snapshot Snapshot
+		if err := json.Unmarshal([]byte(test.meta), &snapshot.JobV1.File.Meta); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		snapshot.Job.Job.Filament = test.filament
+		snapshot.Printer.Telemetry.Material = test.material
+
+		if usage := getJobFilament(snapshot); !equalFilament(usage, test.expected) {
+			t.Errorf("%s: getJobFilament() = %v, expected %v", test.name, usage, test.expected)
+		}
+	}
+}
+
+func TestScaleFilament(t *testing.T) {
+	usage := map[string]FilamentCounters{
+		"PLA":  {Grams: 10, Meters: 3, Cost: 0.5},
+		"PETG": {Grams: 4, Meters: 1, Cost: 0.2},
+	}
+
+	tests := []struct {
+		ratio    float64
+		expected map[string]FilamentCounters
+	}{
+		{1, usage},
+		{0.5, map[string]FilamentCounters{"PLA": {Grams: 5, Meters: 1.5, Cost: 0.25}, "PETG": {Grams: 2, Meters: 0.5, Cost: 0.1}}},
+		{0, map[string]FilamentCounters{"PLA": {}, "PETG": {}}},
+	}
+
+	for _, test := range tests {
+		if scaled := scaleFilament(usage, test.ratio); !equalFilament(scaled, test.expected) {
+			t.Errorf("scaleFilament(%v) = %v, expected %v", test.ratio, scaled, test.expected)
+		}
+	}
+
+	if usage["PLA"].Grams != 10 {
+		t.Errorf("scaleFilament() modified the usage to %v", usage)
+	}
+
+	if scaled := scaleFilament(nil, 0.5); scaled == nil || len(scaled) != 0 {
+		t.Errorf("scaleFilament(nil) = %v, expected empty map", scaled)
+	}
+}
+
+func TestJobTrackerFilament(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		progress float64
+		grams    float64
+	}{
+		{"completed job uses all filament", "FINISHED", 95, 10},
+		{"cancelled job uses filament up to progress", "STOPPED", 40, 4},
+		{"failed job uses filament up to progress", "ERROR", 25, 2.5},
+		{"progress is capped", "STOPPED", 120, 10},
+	}
+
+	for _, test := range tests {
+		tracker := NewJobTracker("")
+
+		var used map[string]FilamentCounters
+		tracker.Subscribe(func(event JobEvent) {
+			if event.Type != JobStarted {
+				used = event.Filament
+			}
+		})
+
+		printing := jobSnapshot(8, "PRINTING", 10, 60)
+		printing.JobV1.File.Meta.FilamentType = "PLA"
+		printing.JobV1.File.Meta.FilamentUsedGrams = MetaValues{10}
+		printing.JobV1.File.Meta.FilamentUsedMillimeters = MetaValues{3000}
+
+		tracker.Update(Snapshot{}, jobSnapshot(0, "IDLE", 0, 0))
+		tracker.Update(Snapshot{}, printing)
+		tracker.Update(Snapshot{}, jobSnapshot(8, test.state, test.progress, 120)) // metadata is not refreshed at the end
+
+		ratio := test.grams / 10
+		expected := map[string]FilamentCounters{"PLA": {Grams: test.grams, Meters: 3 * ratio}}
+
+		if !equalFilament(used, expected) {
+			t.Errorf("%s: event filament = %v, expected %v", test.name, used, expected)
+		}
+
+		if counters := tracker.Counters("192.168.1.10"); !equalFilament(counters.Filament, expected) {
+			t.Errorf("%s: counted filament = %v, expected %v", test.name, counters.Filament, expected)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package prusalink
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Job lifecycle event types
+const (
+	JobStarted   = "started"
+	JobPaused    = "paused"
+	JobResumed   = "resumed"
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+// JobEvent is a struct that describes observed transition of the print job
+type JobEvent struct {
+	Type      string
+	Snapshot  Snapshot // snapshot where the transition was observed
+	JobID     float64
+	Job       JobV1 // the latest known data of the job, file metadata are kept even if the printer does not report them anymore
+	Started   time.Time
+	Duration  time.Duration               // time spent printing
+	Thumbnail string                      // base64 encoded thumbnail captured while printing
+	Filament  map[string]FilamentCounters // filament used by the job per material, only for finished jobs
+}
+
+// JobCounters is a struct that contains cumulative job counters of the printer
+type JobCounters struct {
+	Started      float64                     `json:"started"`
+	Completed    float64                     `json:"completed"`
+	Cancelled    float64                     `json:"cancelled"`
+	Failed       float64                     `json:"failed"`
+	PrintSeconds float64                     `json:"print_seconds"`
+	Filament     map[string]FilamentCounters `json:"filament"` // by material
+}
+
+// trackedJob is a struct that contains state of the printer between polls, it's persisted in the state file
+type trackedJob struct {
+	JobID        float64                     `json:"job_id"`
+	State        string                      `json:"state"`
+	Active       bool                        `json:"active"` // job is running and its end was not observed yet
+	Progress     float64                     `json:"progress"`
+	TimePrinting float64                     `json:"time_printing"`
+	Started      time.Time                   `json:"started"`
+	Job          JobV1                       `json:"job"`
+	Counters     JobCounters                 `json:"counters"`
+	Filament     map[string]FilamentCounters `json:"filament"` // estimated usage of the whole job
+	Thumbnail    string                      `json:"-"`
+	Utilization  *printerUtilization         `json:"utilization,omitempty"` // filled only while the state file is written and read
+}
+
+// JobTracker watches job id and state across polls and counts job lifecycle transitions
+type JobTracker struct {
+	stateFile   string
+	printers    map[string]*trackedJob
+	subscribers []func(JobEvent)
+	utilization *UtilizationTracker // its data are persisted in the state file too
+	saved       time.Time           // time of the last write of the state file
+	mutex       sync.Mutex
+}
+
+// stateSaveInterval is how often is the state file written when there is no job transition
+const stateSaveInterval = time.Minute
+
+// terminal job states and their outcomes
+var jobOutcomes = map[string]string{
+	"FINISHED": JobCompleted,
+	"STOPPED":  JobCancelled,
+	"ERROR":    JobFailed,
+}
+
+// NewJobTracker returns a new JobTracker, state is restored from the state file if it's configured
+func NewJobTracker(stateFile string) *JobTracker {
+	tracker := &JobTracker{
+		stateFile: stateFile,
+		printers:  map[string]*trackedJob{},
+	}
+
+	if stateFile == "" {
+		return tracker
+	}
+
+	data, err := os.ReadFile(stateFile)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Msg("Error while reading state file " + stateFile + " - " + err.Error())
+		}
+		return tracker
+	}
+
+	if err := json.Unmarshal(data, &tracker.printers); err != nil {
+		log.Error().Msg("Error while parsing state file " + stateFile + " - " + err.Error())
+	}
+
+	return tracker
+}
+
+// PersistUtilization restores utilization data from the state file and keeps them in the state file from now on
+func (tracker *JobTracker) PersistUtilization(utilization *UtilizationTracker) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	for address, tracked := range tracker.printers {
+		if tracked.Utilization != nil {
+			utilization.restore(address, tracked.Utilization)
+			tracked.Utilization = nil
+		}
+
+		// printer was never seen online, the entry only carried its utilization
+		if tracked.State == "" {
+			delete(tracker.printers, address)
+		}
+	}
+
+	tracker.utilization = utilization
+}
+
+// Save writes tracked state to the state file, it's meant to be called on exit
+func (tracker *JobTracker) Save() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.save()
+}
+
+// Subscribe registers function called on every job event
+func (tracker *JobTracker) Subscribe(subscriber func(JobEvent)) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.subscribers = append(tracker.subscribers, subscriber)
+}
+
+// Counters returns job counters of the printer with given address
+func (tracker *JobTracker) Counters(address string) JobCounters {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if tracked, ok := tracker.printers[address]; ok {
+		counters := tracked.Counters
+		counters.Filament = map[string]FilamentCounters{}
+		for material, filament := range tracked.Counters.Filament {
+			counters.Filament[material] = filament
+		}
+		return counters
+	}
+
+	return JobCounters{}
+}
+
+// Update compares the snapshot with tracked state of the printer, it's called by the poller after every poll
+func (tracker *JobTracker) Update(_ Snapshot, snapshot Snapshot) {
+	if !snapshot.Up {
+		return
+	}
+
+	jobID, state, progress, timePrinting := getJobState(snapshot)
+
+	tracker.mutex.Lock()
+
+	tracked, known := tracker.printers[snapshot.Config.Address]
+	if !known {
+		tracked = &trackedJob{}
+		tracker.printers[snapshot.Config.Address] = tracked
+	}
+
+	previous := *tracked
+
+	var events []JobEvent
+	_, terminal := jobOutcomes[state]
+
+	if tracked.Active && jobID != tracked.JobID {
+		// end of the previous job was missed, outcome is inferred from the last known progress
+		outcome := JobCancelled
+		if tracked.Progress >= 100 {
+			outcome = JobCompleted
+		}
+		events = append(events, tracker.finish(tracked, snapshot, outcome))
+	}
+
+	switch {
+	case jobID == 0:
+		// printer is idle, the last job id is kept so the same job is not counted again
+	case jobID != tracked.JobID && !known:
+		// job was already running when the exporter started without state, its start is unknown
+		log.Debug().Msg("Adopting job " + formatJobID(jobID) + " at " + snapshot.Config.Address)
+		tracked.JobID = jobID
+		tracked.Active = !terminal
+		tracked.TimePrinting = timePrinting
+		tracked.Started = snapshot.Time.Add(-time.Duration(timePrinting) * time.Second)
+		if tracked.Active {
+			// counted as started, otherwise its outcome would be counted without start
+			tracked.Counters.Started++
+		}
+	case jobID != tracked.JobID:
+		tracked.JobID = jobID
+		tracked.Active = true
+		tracked.TimePrinting = 0
+		tracked.Started = snapshot.Time.Add(-time.Duration(timePrinting) * time.Second)
+		tracked.Job = JobV1{}
+		tracked.Filament = nil
+		tracked.Thumbnail = ""
+		if snapshot.JobV1.ID == jobID {
+			tracked.Job = snapshot.JobV1
+		}
+		tracked.Counters.Started++
+		events = append(events, tracker.event(tracked, snapshot, JobStarted))
+	}
+
+	if jobID != 0 && jobID == tracked.JobID && tracked.Active {
+		if timePrinting > tracked.TimePrinting {
+			tracked.Counters.PrintSeconds += timePrinting - tracked.TimePrinting
+			tracked.TimePrinting = timePrinting
+		}
+		tracked.Progress = progress
+
+		if snapshot.JobImage != "" {
+			tracked.Thumbnail = snapshot.JobImage
+		}
+
+		if filament := getJobFilament(snapshot); len(filament) > 0 {
+			tracked.Filament = filament
+		}
+
+		if snapshot.JobV1.ID == jobID {
+			tracked.Job = snapshot.JobV1
+		}
+
+		if state == "PAUSED" && tracked.State != "PAUSED" {
+			events = append(events, tracker.event(tracked, snapshot, JobPaused))
+		} else if state == "PRINTING" && tracked.State == "PAUSED" {
+			events = append(events, tracker.event(tracked, snapshot, JobResumed))
+		}
+
+		if terminal {
+			events = append(events, tracker.finish(tracked, snapshot, jobOutcomes[state]))
+		}
+	}
+
+	tracked.State = state
+
+	// state file is written on transitions, printing time and utilization are written once per stateSaveInterval
+	changed := !known || len(events) > 0 || tracked.JobID != previous.JobID || tracked.State != previous.State || tracked.Active != previous.Active || tracked.Counters.Started != previous.Counters.Started
+	if changed || time.Since(tracker.saved) >= stateSaveInterval {
+		tracker.save()
+	}
+
+	subscribers := tracker.subscribers
+	tracker.mutex.Unlock()
+
+	for _, event := range events {
+		log.Debug().Msg("Job " + formatJobID(event.JobID) + " " + event.Type + " at " + snapshot.Config.Address)
+		for _, subscriber := range subscribers {
+			subscriber(event)
+		}
+	}
+}
+
+// finish counts the outcome of the tracked job and marks it as inactive
+func (tracker *JobTracker) finish(tracked *trackedJob, snapshot Snapshot, outcome string) JobEvent {
+	switch outcome {
+	case JobCompleted:
+		tracked.Counters.Completed++
+	case JobCancelled:
+		tracked.Counters.Cancelled++
+	case JobFailed:
+		tracked.Counters.Failed++
+	}
+
+	tracked.Active = false
+
+	// unfinished jobs used only part of the filament
+	ratio := 1.0
+	if outcome != JobCompleted {
+		ratio = min(tracked.Progress, 100) / 100
+	}
+
+	used := scaleFilament(tracked.Filament, ratio)
+
+	if tracked.Counters.Filament == nil {
+		tracked.Counters.Filament = map[string]FilamentCounters{}
+	}
+
+	for material, filament := range used {
+		total := tracked.Counters.Filament[material]
+		total.Grams += filament.Grams
+		total.Meters += filament.Meters
+		total.Cost += filament.Cost
+		tracked.Counters.Filament[material] = total
+	}
+
+	event := tracker.event(tracked, snapshot, outcome)
+	event.Filament = used
+
+	return event
+}
+
+// event returns job event of the tracked job
+func (tracker *JobTracker) event(tracked *trackedJob, snapshot Snapshot, eventType string) JobEvent {
+	return JobEvent{
+		Type:      eventType,
+		Snapshot:  snapshot,
+		JobID:     tracked.JobID,
+		Job:       tracked.Job,
+		Started:   tracked.Started,
+		Duration:  time.Duration(tracked.TimePrinting) * time.Second,
+		Thumbnail: tracked.Thumbnail,
+	}
+}
+
+// save writes tracked state to the state file, file is replaced atomically
+func (tracker *JobTracker) save() {
+	if tracker.stateFile == "" {
+		return
+	}
+
+	printers := map[string]trackedJob{}
+	for address, tracked := range tracker.printers {
+		printers[address] = *tracked
+	}
+
+	if tracker.utilization != nil {
+		for address, utilization := range tracker.utilization.export() {
+			tracked := printers[address]
+			tracked.Utilization = utilization
+			printers[address] = tracked
+		}
+	}
+
+	data, err := json.Marshal(printers)
+
+	if err != nil {
+		log.Error().Msg("Error while encoding state - " + err.Error())
+		return
+	}
+
+	if err := os.WriteFile(tracker.stateFile+".tmp", data, 0o644); err != nil {
+		log.Error().Msg("Error while writing state file " + tracker.stateFile + " - " + err.Error())
+		return
+	}
+
+	if err := os.Rename(tracker.stateFile+".tmp", tracker.stateFile); err != nil {
+		log.Error().Msg("Error while writing state file " + tracker.stateFile + " - " + err.Error())
+		return
+	}
+
+	tracker.saved = time.Now()
+}
+
+// getJobState returns job id, state, progress in percent and printing time in seconds - /api/v1/job is preferred, /api/v1/status is used when printer is idle or does not support it
+func getJobState(snapshot Snapshot) (float64, string, float64, float64) {
+	if snapshot.JobV1.ID != 0 {
+		return snapshot.JobV1.ID, snapshot.JobV1.State, snapshot.JobV1.Progress, snapshot.JobV1.TimePrinting
+	}
+
+	return snapshot.Status.Job.ID, snapshot.Status.Printer.State, snapshot.Status.Job.Progress, snapshot.Status.Job.TimePrinting
+}
+
+// formatJobID returns job id as string
+func formatJobID(jobID float64) string {
+	return strconv.FormatFloat(jobID, 'f', -1, 64)
+}
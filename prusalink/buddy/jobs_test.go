@@ -0,0 +1,124 @@
+package prusalink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// jobSnapshot returns snapshot of the printer with the job in given state
+func jobSnapshot(jobID float64, state string, progress float64, timePrinting float64) Snapshot {
+	snapshot := Snapshot{
+		Config: config.Printers{Address: "192.168.1.10"},
+		Up:     true,
+		Time:   time.Now(),
+	}
+	snapshot.JobV1.ID = jobID
+	snapshot.JobV1.State = state
+	snapshot.JobV1.Progress = progress
+	snapshot.JobV1.TimePrinting = timePrinting
+	snapshot.Status.Printer.State = state
+
+	return snapshot
+}
+
+func TestJobTrackerAdoptedJobIsStarted(t *testing.T) {
+	tracker := NewJobTracker("")
+
+	var events []string
+	tracker.Subscribe(func(event JobEvent) {
+		events = append(events, event.Type)
+	})
+
+	tracker.Update(Snapshot{}, jobSnapshot(7, "PRINTING", 40, 600))
+	tracker.Update(Snapshot{}, jobSnapshot(7, "PRINTING", 90, 1200))
+	tracker.Update(Snapshot{}, jobSnapshot(7, "FINISHED", 100, 1300))
+
+	counters := tracker.Counters("192.168.1.10")
+
+	if counters.Started != 1 || counters.Completed != 1 {
+		t.Errorf("counters = %+v, expected 1 started and 1 completed", counters)
+	}
+
+	if counters.PrintSeconds != 700 {
+		t.Errorf("print seconds = %v, expected 700 observed after adoption", counters.PrintSeconds)
+	}
+
+	if len(events) != 1 || events[0] != JobCompleted {
+		t.Errorf("events = %v, expected only completed", events)
+	}
+}
+
+func TestJobTrackerAdoptedFinishedJobIsNotCounted(t *testing.T) {
+	tracker := NewJobTracker("")
+
+	tracker.Update(Snapshot{}, jobSnapshot(7, "FINISHED", 100, 1300))
+
+	if counters := tracker.Counters("192.168.1.10"); counters.Started != 0 || counters.Completed != 0 {
+		t.Errorf("counters = %+v, expected nothing counted", counters)
+	}
+}
+
+func TestJobTrackerSavesOnChange(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	tracker := NewJobTracker(stateFile)
+
+	saved := func() bool {
+		_, err := os.Stat(stateFile)
+		if err == nil {
+			os.Remove(stateFile)
+		}
+		return err == nil
+	}
+
+	tracker.Update(Snapshot{}, jobSnapshot(0, "IDLE", 0, 0))
+	if !saved() {
+		t.Fatal("state of new printer was not saved")
+	}
+
+	tracker.Update(Snapshot{}, jobSnapshot(0, "IDLE", 0, 0))
+	if saved() {
+		t.Error("state was saved without change")
+	}
+
+	tracker.Update(Snapshot{}, jobSnapshot(8, "PRINTING", 0, 10))
+	if !saved() {
+		t.Error("state was not saved after job start")
+	}
+
+	tracker.Update(Snapshot{}, jobSnapshot(8, "PRINTING", 5, 20))
+	if saved() {
+		t.Error("state was saved for printing time within save interval")
+	}
+
+	tracker.Update(Snapshot{}, jobSnapshot(8, "PAUSED", 5, 20))
+	if !saved() {
+		t.Error("state was not saved after pause")
+	}
+
+	tracker.Update(Snapshot{}, jobSnapshot(8, "FINISHED", 100, 30))
+	restored := NewJobTracker(stateFile)
+
+	if counters := restored.Counters("192.168.1.10"); counters.Started != 1 || counters.Completed != 1 || counters.PrintSeconds != 30 {
+		t.Errorf("restored counters = %+v, expected 1 started, 1 completed and 30 seconds", counters)
+	}
+}
+
+func TestJobTrackerRestartDoesNotCountRunningJobAgain(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	tracker := NewJobTracker(stateFile)
+	tracker.Update(Snapshot{}, jobSnapshot(7, "PRINTING", 40, 600))
+
+	// exporter restarts while the job is still printing
+	restarted := NewJobTracker(stateFile)
+	restarted.Update(Snapshot{}, jobSnapshot(7, "PRINTING", 50, 700))
+	restarted.Update(Snapshot{}, jobSnapshot(7, "FINISHED", 100, 1300))
+
+	if counters := restarted.Counters("192.168.1.10"); counters.Started != 1 || counters.Completed != 1 {
+		t.Errorf("counters = %+v, expected the job started once and completed once", counters)
+	}
+}
@@ -0,0 +1,268 @@
+package prusalink
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Snapshot is a struct that holds all data scraped from the printer in one poll
+type Snapshot struct {
+	Config          config.Printers // printer configuration, Type contains detected model when it's not configured
+	ModelConfidence string
+	Up              bool
+	Time            time.Time // time of the poll
+	LastSeen        time.Time // time of the last successful poll
+	LastError       string    // the last error that made the printer down, it's kept after printer recovers
+	LastErrorTime   time.Time
+	Job             Job
+	JobV1           JobV1
+	Printer         Printer
+	Version         Version
+	Status          Status
+	Info            Info
+	Storage         StorageV1
+	Files           Files
+	Cameras         Cameras
+	JobImage        string    // base64 encoded thumbnail of the job, only while printing
+	MetadataTime    time.Time // time of the last refresh of version, info, storage and cameras
+	FilesTime       time.Time // time of the last refresh of files
+}
+
+// Poller periodically scrapes all printers and keeps their latest snapshots
+type Poller struct {
+	printers    []config.Printers
+	interval    time.Duration
+	snapshots   map[string]Snapshot
+	subscribers []func(previous Snapshot, current Snapshot)
+	mutex       sync.RWMutex
+
+	Jobs        *JobTracker
+	Utilization *UtilizationTracker
+}
+
+// NewPoller returns a new Poller for configured printers
+func NewPoller(config config.Config) *Poller {
+	configuration = config
+
+	poller := &Poller{
+		printers:  config.Printers,
+		interval:  time.Duration(config.Exporter.PollInterval),
+		snapshots: map[string]Snapshot{},
+		Jobs:      NewJobTracker(config.Exporter.StateFile),
+	}
+
+	windows := []time.Duration{}
+	for _, window := range config.Exporter.Utilization.Windows {
+		windows = append(windows, time.Duration(window))
+	}
+
+	// polls delayed by more than few intervals mean the exporter was not running
+	longest := poller.interval
+	for _, printer := range config.Printers {
+		longest = max(longest, time.Duration(printer.PollInterval))
+	}
+	poller.Utilization = NewUtilizationTracker(windows, 3*longest)
+	poller.Jobs.PersistUtilization(poller.Utilization)
+
+	poller.Subscribe(poller.Jobs.Update)
+	poller.Subscribe(poller.Utilization.Update)
+	poller.Jobs.Subscribe(poller.Utilization.HandleJobEvent)
+
+	return poller
+}
+
+// Subscribe registers function called after every poll of a printer with its previous and current snapshot
+func (poller *Poller) Subscribe(subscriber func(previous Snapshot, current Snapshot)) {
+	poller.mutex.Lock()
+	defer poller.mutex.Unlock()
+
+	poller.subscribers = append(poller.subscribers, subscriber)
+}
+
+// Start starts polling of all printers in background, printer poll interval overrides the exporter one
+func (poller *Poller) Start() {
+	for _, printer := range poller.printers {
+		go func(printer config.Printers) {
+			interval := poller.interval
+			if printer.PollInterval != 0 {
+				interval = time.Duration(printer.PollInterval)
+			}
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				previous, _ := poller.Snapshot(printer.Address)
+				poller.update(poll(printer, previous))
+				<-ticker.C
+			}
+		}(printer)
+	}
+}
+
+// update stores the snapshot and notifies subscribers
+func (poller *Poller) update(snapshot Snapshot) {
+	poller.mutex.Lock()
+	previous := poller.snapshots[snapshot.Config.Address]
+	if !snapshot.Up {
+		snapshot.LastSeen = previous.LastSeen
+	}
+	if snapshot.LastError == "" {
+		snapshot.LastError = previous.LastError
+		snapshot.LastErrorTime = previous.LastErrorTime
+	}
+	poller.snapshots[snapshot.Config.Address] = snapshot
+	subscribers := poller.subscribers
+	poller.mutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(previous, snapshot)
+	}
+}
+
+// Snapshot returns the latest snapshot of the printer with given address
+func (poller *Poller) Snapshot(address string) (Snapshot, bool) {
+	poller.mutex.RLock()
+	defer poller.mutex.RUnlock()
+
+	snapshot, ok := poller.snapshots[address]
+
+	return snapshot, ok
+}
+
+// Snapshots returns the latest snapshots of all polled printers in configuration order
+func (poller *Poller) Snapshots() []Snapshot {
+	poller.mutex.RLock()
+	defer poller.mutex.RUnlock()
+
+	snapshots := []Snapshot{}
+
+	for _, printer := range poller.printers {
+		if snapshot, ok := poller.snapshots[printer.Address]; ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots
+}
+
+// poll scrapes endpoints of the printer, printer is considered down when job, printer or version endpoint fails
+// metadata and files are refreshed by their refresh classes, otherwise they are taken from the previous snapshot - all of them are refreshed after printer recovers
+func poll(s config.Printers, previous Snapshot) Snapshot {
+	log.Debug().Msg("Printer scraping at " + s.Address)
+
+	snapshot := Snapshot{
+		Config:          s,
+		ModelConfidence: ConfidenceConfigured,
+		Time:            time.Now(),
+		Version:         previous.Version,
+		Info:            previous.Info,
+		Storage:         previous.Storage,
+		Cameras:         previous.Cameras,
+		Files:           previous.Files,
+		MetadataTime:    previous.MetadataTime,
+		FilesTime:       previous.FilesTime,
+	}
+
+	refreshMetadata := !previous.Up || snapshot.Time.Sub(previous.MetadataTime) >= time.Duration(configuration.Exporter.Refresh.Metadata)
+	refreshFiles := !previous.Up || snapshot.Time.Sub(previous.FilesTime) >= time.Duration(configuration.Exporter.Refresh.Files)
+
+	if s.Type == "" {
+		detection := getDetection(s)
+		snapshot.Config.Type = detection.Model
+		snapshot.ModelConfidence = detection.Confidence
+	}
+
+	var err error
+
+	snapshot.Job, err = GetJob(s)
+	if err != nil {
+		log.Error().Msg("Error while scraping job endpoint at " + s.Address + " - " + err.Error())
+		return failedSnapshot(snapshot, "job endpoint - "+err.Error())
+	}
+
+	snapshot.Printer, err = GetPrinter(s)
+	if err != nil {
+		log.Error().Msg("Error while scraping printer endpoint at " + s.Address + " - " + err.Error())
+		return failedSnapshot(snapshot, "printer endpoint - "+err.Error())
+	}
+
+	if refreshMetadata {
+		snapshot.Version, err = GetVersion(s)
+		if err != nil {
+			log.Error().Msg("Error while scraping version endpoint at " + s.Address + " - " + err.Error())
+			return failedSnapshot(snapshot, "version endpoint - "+err.Error())
+		}
+	}
+
+	snapshot.Status, err = GetStatus(s)
+
+	if err != nil {
+		log.Error().Msg("Error while scraping status endpoint at " + s.Address + " - " + err.Error())
+	}
+
+	if refreshMetadata {
+		// failed endpoint keeps the previous value until the next refresh, endpoints missing on older firmware would be scraped every poll otherwise
+		snapshot.MetadataTime = snapshot.Time
+
+		if info, err := GetInfo(s); err != nil {
+			log.Error().Msg("Error while scraping info endpoint at " + s.Address + " - " + err.Error())
+		} else {
+			snapshot.Info = info
+		}
+
+		if storage, err := GetStorageV1(s); err != nil {
+			log.Error().Msg("Error while scraping storage endpoint at " + s.Address + " - " + err.Error())
+		} else {
+			snapshot.Storage = storage
+		}
+
+		if cameras, err := GetCameras(s); err != nil {
+			log.Error().Msg("Error while scraping cameras endpoint at " + s.Address + " - " + err.Error())
+		} else {
+			snapshot.Cameras = cameras
+		}
+	}
+
+	if refreshFiles {
+		snapshot.FilesTime = snapshot.Time
+
+		if files, err := GetFiles(s); err != nil {
+			log.Error().Msg("Error while scraping files endpoint at " + s.Address + " - " + err.Error())
+		} else {
+			snapshot.Files = files
+		}
+	}
+
+	snapshot.JobV1, err = GetJobV1(s)
+
+	if err != nil {
+		log.Error().Msg("Error while scraping job v1 endpoint at " + s.Address + " - " + err.Error())
+	}
+
+	if GetPrinterState(snapshot) == "PRINTING" {
+		snapshot.JobImage, err = GetJobImage(s, snapshot.Job.Job.File.Path)
+
+		if err != nil {
+			log.Error().Msg("Error while scraping image endpoint at " + s.Address + " - " + err.Error())
+		}
+	}
+
+	snapshot.Up = true
+	snapshot.LastSeen = snapshot.Time
+
+	log.Debug().Msg("Scraping done at " + s.Address)
+
+	return snapshot
+}
+
+// failedSnapshot returns snapshot of the printer that is down because of the error
+func failedSnapshot(snapshot Snapshot, message string) Snapshot {
+	snapshot.LastError = message
+	snapshot.LastErrorTime = snapshot.Time
+
+	return snapshot
+}
@@ -1,16 +1,20 @@
 package prusalink
 
 import (
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/pstrobl96/prusa_exporter/config"
-	"github.com/rs/zerolog/log"
 )
 
 // Collector is a struct of all printer metrics
 type Collector struct {
+	printers                  []config.Printers
+	poller                    *Poller
 	printerTemp               *prometheus.Desc
 	printerTempTarget         *prometheus.Desc
 	printerPrintTime          *prometheus.Desc
@@ -21,6 +25,7 @@ type Collector struct {
 	printerUp                 *prometheus.Desc
 	printerNozzleSize         *prometheus.Desc
 	printerStatus             *prometheus.Desc
+	printerState              *prometheus.Desc
 	printerAxis               *prometheus.Desc
 	printerFlow               *prometheus.Desc
 	printerInfo               *prometheus.Desc
@@ -28,30 +33,109 @@ type Collector struct {
 	printerFanSpeedRpm        *prometheus.Desc
 	printerPrintSpeedRatio    *prometheus.Desc
 	printerJobImage           *prometheus.Desc
+	printerToolNozzleSize     *prometheus.Desc
+	printerToolMaterial       *prometheus.Desc
+	printerActiveTool         *prometheus.Desc
+	printerFilesSize          *prometheus.Desc
+	printerStorageFree        *prometheus.Desc
+	printerStorageTotal       *prometheus.Desc
+	printerStorageAvailable   *prometheus.Desc
+	printerStorageReadOnly    *prometheus.Desc
+	printerStoragePrintFiles  *prometheus.Desc
+	printerStorageSystemFiles *prometheus.Desc
+	printerCameraInfo         *prometheus.Desc
+	printerCameraConnected    *prometheus.Desc
+	printerCameraDetected     *prometheus.Desc
+	printerCameraRegistered   *prometheus.Desc
+	jobEstimatedPrintTime     *prometheus.Desc
+	jobLayerHeight            *prometheus.Desc
+	jobInfo                   *prometheus.Desc
+	jobInaccurateEstimates    *prometheus.Desc
+	jobModelMismatch          *prometheus.Desc
+	jobsStarted               *prometheus.Desc
+	jobsCompleted             *prometheus.Desc
+	jobsCancelled             *prometheus.Desc
+	jobsFailed                *prometheus.Desc
+	printSeconds              *prometheus.Desc
+	filamentUsedGrams         *prometheus.Desc
+	filamentUsedMeters        *prometheus.Desc
+	filamentCost              *prometheus.Desc
+	jobFilamentGrams          *prometheus.Desc
+	jobFilamentMeters         *prometheus.Desc
+	stateSeconds              *prometheus.Desc
+	utilizationRatio          *prometheus.Desc
+	availabilityRatio         *prometheus.Desc
+	jobSuccessRatio           *prometheus.Desc
 }
 
-// NewCollector returns a new Collector for printer metrics
-func NewCollector(config config.Config) *Collector {
+// NewCollector returns a new Collector for printer metrics polled by the poller
+func NewCollector(config config.Config, poller *Poller) *Collector {
 	configuration = config
+	return newCollector(config.Printers, poller)
+}
+
+// NewProbeCollector returns a new Collector exposing only the given printer, used for multi-target probing
+func NewProbeCollector(printer config.Printers, poller *Poller) *Collector {
+	return newCollector([]config.Printers{printer}, poller)
+}
+
+func newCollector(printers []config.Printers, poller *Poller) *Collector {
 	defaultLabels := []string{"printer_address", "printer_model", "printer_name", "printer_job_name", "printer_job_path"}
+	printerLabels := []string{"printer_address", "printer_model", "printer_name"}
 	return &Collector{
+		printers:                  printers,
+		poller:                    poller,
 		printerTemp:               prometheus.NewDesc("prusa_temperature_celsius", "Current temp of printer in Celsius", append(defaultLabels, "printer_heated_element"), nil),
 		printerTempTarget:         prometheus.NewDesc("prusa_temperature_target_celsius", "Target temp of printer in Celsius", append(defaultLabels, "printer_heated_element"), nil),
 		printerPrintTimeRemaining: prometheus.NewDesc("prusa_printing_time_remaining_seconds", "Returns time that remains for completion of current print", defaultLabels, nil),
 		printerPrintProgressRatio: prometheus.NewDesc("prusa_printing_progress_ratio", "Returns information about completion of current print in ratio (0.0-1.0)", defaultLabels, nil),
-		printerFiles:              prometheus.NewDesc("prusa_files_count", "Number of files in storage", append(defaultLabels, "printer_storage"), nil),
+		printerFiles:              prometheus.NewDesc("prusa_files_count", "Number of gcode files in storage", append(defaultLabels, "printer_storage"), nil),
 		printerMaterial:           prometheus.NewDesc("prusa_material_info", "Returns information about loaded filament. Returns 0 if there is no loaded filament", append(defaultLabels, "printer_filament"), nil),
 		printerPrintTime:          prometheus.NewDesc("prusa_print_time_seconds", "Returns information about current print time.", defaultLabels, nil),
 		printerUp:                 prometheus.NewDesc("prusa_up", "Return information about online printers. If printer is registered as offline then returned value is 0.", []string{"printer_address", "printer_model", "printer_name"}, nil),
 		printerNozzleSize:         prometheus.NewDesc("prusa_nozzle_size_meters", "Returns information about selected nozzle size.", defaultLabels, nil),
-		printerStatus:             prometheus.NewDesc("prusa_status_info", "Returns information status of printer.", append(defaultLabels, "printer_state"), nil),
+		printerStatus:             prometheus.NewDesc("prusa_status_info", "Returns information status of printer. Deprecated, use prusa_printer_state.", append(defaultLabels, "printer_state"), nil),
+		printerState:              prometheus.NewDesc("prusa_printer_state", "Returns 1 for current state of the printer, 0 for other states. UNKNOWN is 1 when the state is not recognized.", append(printerLabels, "state"), nil),
 		printerAxis:               prometheus.NewDesc("prusa_axis", "Returns information about position of axis.", append(defaultLabels, "printer_axis"), nil),
 		printerFlow:               prometheus.NewDesc("prusa_print_flow_ratio", "Returns information about of filament flow in ratio (0.0 - 1.0).", defaultLabels, nil),
-		printerInfo:               prometheus.NewDesc("prusa_info", "Returns information about printer.", append(defaultLabels, "api_version", "server_version", "version_text", "prusalink_name", "printer_location", "serial_number", "printer_hostname"), nil),
+		printerInfo:               prometheus.NewDesc("prusa_info", "Returns information about printer.", append(defaultLabels, "api_version", "server_version", "version_text", "prusalink_name", "printer_location", "serial_number", "printer_hostname", "printer_model_confidence"), nil),
 		printerMMU:                prometheus.NewDesc("prusa_mmu", "Returns information if MMU is enabled.", defaultLabels, nil),
 		printerFanSpeedRpm:        prometheus.NewDesc("prusa_fan_speed_rpm", "Returns information about speed of hotend fan in rpm.", append(defaultLabels, "fan"), nil),
 		printerPrintSpeedRatio:    prometheus.NewDesc("prusa_print_speed_ratio", "Current setting of printer speed in values from 0.0 - 1.0", []string{"printer_address", "printer_model", "printer_name", "printer_job_name", "printer_job_path"}, nil),
 		printerJobImage:           prometheus.NewDesc("prusa_job_image", "Returns information about image of current print job.", append(defaultLabels, "printer_job_image"), nil),
+		printerToolNozzleSize:     prometheus.NewDesc("prusa_tool_nozzle_size_meters", "Returns nozzle diameter of the toolhead in meters.", append(defaultLabels, "printer_tool"), nil),
+		printerToolMaterial:       prometheus.NewDesc("prusa_tool_material_info", "Returns information about filament loaded in the toolhead. Returns 0 if there is no loaded filament", append(defaultLabels, "printer_tool", "printer_filament"), nil),
+		printerActiveTool:         prometheus.NewDesc("prusa_active_tool", "Returns index of the active toolhead.", defaultLabels, nil),
+		printerFilesSize:          prometheus.NewDesc("prusa_files_size_bytes", "Total size of gcode files in storage in bytes", append(defaultLabels, "printer_storage"), nil),
+		printerStorageFree:        prometheus.NewDesc("prusa_storage_free_bytes", "Free space of storage in bytes", append(defaultLabels, "printer_storage", "printer_storage_name"), nil),
+		printerStorageTotal:       prometheus.NewDesc("prusa_storage_total_bytes", "Total space of storage in bytes", append(defaultLabels, "printer_storage", "printer_storage_name"), nil),
+		printerStorageAvailable:   prometheus.NewDesc("prusa_storage_available", "Returns 1 if storage is available, 0 otherwise", append(defaultLabels, "printer_storage", "printer_storage_name"), nil),
+		printerStorageReadOnly:    prometheus.NewDesc("prusa_storage_read_only", "Returns 1 if storage is read only, 0 otherwise", append(defaultLabels, "printer_storage", "printer_storage_name"), nil),
+		printerStoragePrintFiles:  prometheus.NewDesc("prusa_storage_print_files_bytes", "Size of print files in storage in bytes", append(defaultLabels, "printer_storage", "printer_storage_name"), nil),
+		printerStorageSystemFiles: prometheus.NewDesc("prusa_storage_system_files_bytes", "Size of system files in storage in bytes", append(defaultLabels, "printer_storage", "printer_storage_name"), nil),
+		printerCameraInfo:         prometheus.NewDesc("prusa_camera_info", "Returns information about camera connected to the printer.", append(defaultLabels, "camera_id", "name", "driver", "resolution", "trigger_scheme"), nil),
+		printerCameraConnected:    prometheus.NewDesc("prusa_camera_connected", "Returns 1 if camera is connected, 0 otherwise", append(defaultLabels, "camera_id"), nil),
+		printerCameraDetected:     prometheus.NewDesc("prusa_camera_detected", "Returns 1 if camera is detected, 0 otherwise", append(defaultLabels, "camera_id"), nil),
+		printerCameraRegistered:   prometheus.NewDesc("prusa_camera_registered", "Returns 1 if camera is registered to Prusa Connect, 0 otherwise", append(defaultLabels, "camera_id"), nil),
+		jobEstimatedPrintTime:     prometheus.NewDesc("prusa_job_estimated_print_time_seconds", "Estimated print time of current job from gcode metadata.", defaultLabels, nil),
+		jobLayerHeight:            prometheus.NewDesc("prusa_job_layer_height_meters", "Layer height of current job from gcode metadata.", defaultLabels, nil),
+		jobInfo:                   prometheus.NewDesc("prusa_job_info", "Returns information about current job.", append(defaultLabels, "job_id", "filament_type", "sliced_for_model"), nil),
+		jobInaccurateEstimates:    prometheus.NewDesc("prusa_job_inaccurate_estimates", "Returns 1 if printer reports estimates of current job as inaccurate, 0 otherwise", defaultLabels, nil),
+		jobModelMismatch:          prometheus.NewDesc("prusa_job_model_mismatch", "Returns 1 if current job was sliced for different printer model, 0 otherwise", append(defaultLabels, "sliced_for_model"), nil),
+		jobsStarted:               prometheus.NewDesc("prusa_jobs_started_total", "Number of started print jobs.", printerLabels, nil),
+		jobsCompleted:             prometheus.NewDesc("prusa_jobs_completed_total", "Number of successfully finished print jobs.", printerLabels, nil),
+		jobsCancelled:             prometheus.NewDesc("prusa_jobs_cancelled_total", "Number of cancelled print jobs.", printerLabels, nil),
+		jobsFailed:                prometheus.NewDesc("prusa_jobs_failed_total", "Number of print jobs that ended with error.", printerLabels, nil),
+		printSeconds:              prometheus.NewDesc("prusa_print_seconds_total", "Total time spent printing in seconds.", printerLabels, nil),
+		filamentUsedGrams:         prometheus.NewDesc("prusa_filament_used_grams_total", "Filament used by finished print jobs in grams, partially printed jobs are counted by their progress.", append(printerLabels, "material"), nil),
+		filamentUsedMeters:        prometheus.NewDesc("prusa_filament_used_meters_total", "Filament used by finished print jobs in meters, partially printed jobs are counted by their progress.", append(printerLabels, "material"), nil),
+		filamentCost:              prometheus.NewDesc("prusa_filament_cost_total", "Cost of filament used by finished print jobs based on configured material prices.", append(printerLabels, "material", "currency"), nil),
+		jobFilamentGrams:          prometheus.NewDesc("prusa_job_filament_grams", "Estimated filament usage of current job in grams.", append(defaultLabels, "material"), nil),
+		jobFilamentMeters:         prometheus.NewDesc("prusa_job_filament_meters", "Estimated filament usage of current job in meters.", append(defaultLabels, "material"), nil),
+		stateSeconds:              prometheus.NewDesc("prusa_state_seconds_total", "Total time spent by the printer in state in seconds.", append(printerLabels, "state"), nil),
+		utilizationRatio:          prometheus.NewDesc("prusa_utilization_ratio", "Ratio of observed time spent printing in the rolling window (0.0 - 1.0).", append(printerLabels, "window"), nil),
+		availabilityRatio:         prometheus.NewDesc("prusa_availability_ratio", "Ratio of observed time the printer was online and without error in the rolling window (0.0 - 1.0).", append(printerLabels, "window"), nil),
+		jobSuccessRatio:           prometheus.NewDesc("prusa_job_success_ratio", "Ratio of completed jobs to all finished jobs in the rolling window (0.0 - 1.0), missing when no job finished.", append(printerLabels, "window"), nil),
 	}
 }
 
@@ -68,195 +152,422 @@ func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.printerUp
 	ch <- collector.printerNozzleSize
 	ch <- collector.printerStatus
+	ch <- collector.printerState
 	ch <- collector.printerAxis
 	ch <- collector.printerFlow
 	ch <- collector.printerInfo
 	ch <- collector.printerMMU
 	ch <- collector.printerFanSpeedRpm
 	ch <- collector.printerJobImage
+	ch <- collector.printerToolNozzleSize
+	ch <- collector.printerToolMaterial
+	ch <- collector.printerActiveTool
+	ch <- collector.printerFilesSize
+	ch <- collector.printerStorageFree
+	ch <- collector.printerStorageTotal
+	ch <- collector.printerStorageAvailable
+	ch <- collector.printerStorageReadOnly
+	ch <- collector.printerStoragePrintFiles
+	ch <- collector.printerStorageSystemFiles
+	ch <- collector.printerCameraInfo
+	ch <- collector.printerCameraConnected
+	ch <- collector.printerCameraDetected
+	ch <- collector.printerCameraRegistered
+	ch <- collector.jobEstimatedPrintTime
+	ch <- collector.jobLayerHeight
+	ch <- collector.jobInfo
+	ch <- collector.jobInaccurateEstimates
+	ch <- collector.jobModelMismatch
+	ch <- collector.jobsStarted
+	ch <- collector.jobsCompleted
+	ch <- collector.jobsCancelled
+	ch <- collector.jobsFailed
+	ch <- collector.printSeconds
+	ch <- collector.filamentUsedGrams
+	ch <- collector.filamentUsedMeters
+	ch <- collector.filamentCost
+	ch <- collector.jobFilamentGrams
+	ch <- collector.jobFilamentMeters
+	ch <- collector.stateSeconds
+	ch <- collector.utilizationRatio
+	ch <- collector.availabilityRatio
+	ch <- collector.jobSuccessRatio
 }
 
 // Collect implements prometheus.Collector
 func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
 
-	var wg sync.WaitGroup
-	for _, s := range configuration.Printers {
-		wg.Add(1)
-		go func(s config.Printers) {
-			defer wg.Done()
+	for _, s := range collector.printers {
+		snapshot, ok := collector.poller.Snapshot(s.Address)
 
-			log.Debug().Msg("Printer scraping at " + s.Address)
-			printerUp := prometheus.MustNewConstMetric(collector.printerUp, prometheus.GaugeValue,
+		if !ok {
+			ch <- prometheus.MustNewConstMetric(collector.printerUp, prometheus.GaugeValue,
 				0, s.Address, s.Type, s.Name)
+			continue
+		}
 
-			job, err := GetJob(s)
-			if err != nil {
-				log.Error().Msg("Error while scraping job endpoint at " + s.Address + " - " + err.Error())
-				ch <- printerUp
-				return
-			}
+		collector.collectSnapshot(ch, snapshot)
+	}
+}
 
-			printer, err := GetPrinter(s)
-			if err != nil {
-				log.Error().Msg("Error while scraping printer endpoint at " + s.Address + " - " + err.Error())
-				ch <- printerUp
-				return
-			}
+// collectSnapshot sends metrics of single printer snapshot
+func (collector *Collector) collectSnapshot(ch chan<- prometheus.Metric, snapshot Snapshot) {
+	s := snapshot.Config
 
-			version, err := GetVersion(s)
-			if err != nil {
-				log.Error().Msg("Error while scraping version endpoint at " + s.Address + " - " + err.Error())
-				ch <- printerUp
-				return
-			}
+	collector.collectJobCounters(ch, s)
+	collector.collectUtilization(ch, s)
 
-			status, err := GetStatus(s)
+	if !snapshot.Up {
+		ch <- prometheus.MustNewConstMetric(collector.printerUp, prometheus.GaugeValue,
+			0, s.Address, s.Type, s.Name)
+		return
+	}
 
-			if err != nil {
-				log.Error().Msg("Error while scraping status endpoint at " + s.Address + " - " + err.Error())
-			}
+	job := snapshot.Job
+	jobV1 := snapshot.JobV1
+	printer := snapshot.Printer
+	version := snapshot.Version
+	status := snapshot.Status
+	info := snapshot.Info
+	storage := snapshot.Storage
+	files := snapshot.Files
+	cameras := snapshot.Cameras
+	modelConfidence := snapshot.ModelConfidence
+
+	printerInfo := prometheus.MustNewConstMetric(
+		collector.printerInfo, prometheus.GaugeValue,
+		1,
+		GetLabels(s, job, version.API, version.Server, version.Text, info.Name, info.Location, info.Serial, info.Hostname, modelConfidence)...)
+
+	ch <- printerInfo
+
+	printerFanHotend := prometheus.MustNewConstMetric(collector.printerFanSpeedRpm, prometheus.GaugeValue,
+		status.Printer.FanHotend, GetLabels(s, job, "hotend")...)
+
+	ch <- printerFanHotend
+
+	printerFanPrint := prometheus.MustNewConstMetric(collector.printerFanSpeedRpm, prometheus.GaugeValue,
+		status.Printer.FanPrint, GetLabels(s, job, "print")...)
+
+	ch <- printerFanPrint
+
+	printerNozzleSize := prometheus.MustNewConstMetric(collector.printerNozzleSize, prometheus.GaugeValue,
+		info.NozzleDiameter, GetLabels(s, job)...)
+
+	ch <- printerNozzleSize
+
+	printSpeed := prometheus.MustNewConstMetric(
+		collector.printerPrintSpeedRatio, prometheus.GaugeValue,
+		printer.Telemetry.PrintSpeed/100,
+		s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+
+	ch <- printSpeed
+
+	printTime := prometheus.MustNewConstMetric(
+		collector.printerPrintTime, prometheus.GaugeValue,
+		job.Progress.PrintTime,
+		s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+
+	ch <- printTime
+
+	printTimeRemaining := prometheus.MustNewConstMetric(
+		collector.printerPrintTimeRemaining, prometheus.GaugeValue,
+		job.Progress.PrintTimeLeft,
+		s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+
+	ch <- printTimeRemaining
+
+	printProgress := prometheus.MustNewConstMetric(
+		collector.printerPrintProgressRatio, prometheus.GaugeValue,
+		job.Progress.Completion,
+		s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+
+	ch <- printProgress
+
+	material := prometheus.MustNewConstMetric(
+		collector.printerMaterial, prometheus.GaugeValue,
+		BoolToFloat(!(strings.Contains(printer.Telemetry.Material, "-"))),
+		s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path, printer.Telemetry.Material)
+
+	ch <- material
+
+	printerAxisX := prometheus.MustNewConstMetric(
+		collector.printerAxis, prometheus.GaugeValue,
+		printer.Telemetry.AxisX,
+		GetLabels(s, job, "x")...)
+
+	ch <- printerAxisX
+
+	printerAxisY := prometheus.MustNewConstMetric(
+		collector.printerAxis, prometheus.GaugeValue,
+		printer.Telemetry.AxisY,
+		GetLabels(s, job, "y")...)
+
+	ch <- printerAxisY
+
+	printerAxisZ := prometheus.MustNewConstMetric(
+		collector.printerAxis, prometheus.GaugeValue,
+		printer.Telemetry.AxisZ,
+		GetLabels(s, job, "z")...)
+
+	ch <- printerAxisZ
+
+	printerFlow := prometheus.MustNewConstMetric(collector.printerFlow, prometheus.GaugeValue,
+		status.Printer.Flow/100, GetLabels(s, job)...)
+
+	ch <- printerFlow
 
-			info, err := GetInfo(s)
+	printerMMU := prometheus.MustNewConstMetric(collector.printerMMU, prometheus.GaugeValue,
+		BoolToFloat(info.Mmu), GetLabels(s, job)...)
+	ch <- printerMMU
 
-			if err != nil {
-				log.Error().Msg("Error while scraping info endpoint at " + s.Address + " - " + err.Error())
+	printerBedTemp := prometheus.MustNewConstMetric(collector.printerTemp, prometheus.GaugeValue,
+		printer.Temperature.Bed.Actual, GetLabels(s, job, "bed")...)
+
+	ch <- printerBedTemp
+
+	printerBedTempTarget := prometheus.MustNewConstMetric(collector.printerTempTarget, prometheus.GaugeValue,
+		printer.Temperature.Bed.Target, GetLabels(s, job, "bed")...)
+
+	ch <- printerBedTempTarget
+
+	if chamber := getChamber(printer, status); chamber != nil {
+		ch <- prometheus.MustNewConstMetric(collector.printerTemp, prometheus.GaugeValue,
+			chamber.Actual, GetLabels(s, job, "chamber")...)
+
+		ch <- prometheus.MustNewConstMetric(collector.printerTempTarget, prometheus.GaugeValue,
+			chamber.Target, GetLabels(s, job, "chamber")...)
+	}
+
+	if status.Printer.FanChamber != nil {
+		ch <- prometheus.MustNewConstMetric(collector.printerFanSpeedRpm, prometheus.GaugeValue,
+			*status.Printer.FanChamber, GetLabels(s, job, "chamber")...)
+	}
+
+	tools := printer.Temperature.ToolIndexes()
+
+	for _, index := range tools {
+		tool := printer.Temperature.Tools[index]
+		toolName := "tool" + strconv.Itoa(index)
+
+		ch <- prometheus.MustNewConstMetric(collector.printerTempTarget, prometheus.GaugeValue,
+			tool.Target, GetLabels(s, job, toolName)...)
+
+		ch <- prometheus.MustNewConstMetric(collector.printerTemp, prometheus.GaugeValue,
+			tool.Actual, GetLabels(s, job, toolName)...)
+
+		// single tool printers report nozzle and material only for the whole printer
+		if len(tools) == 1 {
+			if tool.NozzleDiameter == 0 {
+				tool.NozzleDiameter = info.NozzleDiameter
 			}
+			if tool.Material == "" {
+				tool.Material = printer.Telemetry.Material
+			}
+		}
 
-			printerInfo := prometheus.MustNewConstMetric(
-				collector.printerInfo, prometheus.GaugeValue,
-				1,
-				GetLabels(s, job, version.API, version.Server, version.Text, info.Name, info.Location, info.Serial, info.Hostname)...)
+		if tool.NozzleDiameter > 0 {
+			ch <- prometheus.MustNewConstMetric(collector.printerToolNozzleSize, prometheus.GaugeValue,
+				tool.NozzleDiameter/1000, GetLabels(s, job, toolName)...)
+		}
 
-			ch <- printerInfo
+		if tool.Material != "" {
+			ch <- prometheus.MustNewConstMetric(collector.printerToolMaterial, prometheus.GaugeValue,
+				BoolToFloat(!(strings.Contains(tool.Material, "-"))), GetLabels(s, job, toolName, tool.Material)...)
+		}
+	}
 
-			printerFanHotend := prometheus.MustNewConstMetric(collector.printerFanSpeedRpm, prometheus.GaugeValue,
-				status.Printer.FanHotend, GetLabels(s, job, "hotend")...)
+	if status.Printer.ActiveTool != nil {
+		ch <- prometheus.MustNewConstMetric(collector.printerActiveTool, prometheus.GaugeValue,
+			*status.Printer.ActiveTool, GetLabels(s, job)...)
+	} else if len(tools) == 1 {
+		ch <- prometheus.MustNewConstMetric(collector.printerActiveTool, prometheus.GaugeValue,
+			float64(tools[0]), GetLabels(s, job)...)
+	}
 
-			ch <- printerFanHotend
+	for _, storage := range storage.StorageList {
+		storageLabels := GetLabels(s, job, strings.ToLower(storage.Type), storage.Name)
 
-			printerFanPrint := prometheus.MustNewConstMetric(collector.printerFanSpeedRpm, prometheus.GaugeValue,
-				status.Printer.FanPrint, GetLabels(s, job, "print")...)
+		if storage.FreeSpace != nil {
+			ch <- prometheus.MustNewConstMetric(collector.printerStorageFree, prometheus.GaugeValue,
+				*storage.FreeSpace, storageLabels...)
+		}
 
-			ch <- printerFanPrint
+		if storage.TotalSpace != nil {
+			ch <- prometheus.MustNewConstMetric(collector.printerStorageTotal, prometheus.GaugeValue,
+				*storage.TotalSpace, storageLabels...)
+		}
 
-			printerNozzleSize := prometheus.MustNewConstMetric(collector.printerNozzleSize, prometheus.GaugeValue,
-				info.NozzleDiameter, GetLabels(s, job)...)
+		ch <- prometheus.MustNewConstMetric(collector.printerStorageAvailable, prometheus.GaugeValue,
+			BoolToFloat(storage.Available), storageLabels...)
 
-			ch <- printerNozzleSize
+		ch <- prometheus.MustNewConstMetric(collector.printerStorageReadOnly, prometheus.GaugeValue,
+			BoolToFloat(storage.ReadOnly), storageLabels...)
 
-			printSpeed := prometheus.MustNewConstMetric(
-				collector.printerPrintSpeedRatio, prometheus.GaugeValue,
-				printer.Telemetry.PrintSpeed/100,
-				s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+		ch <- prometheus.MustNewConstMetric(collector.printerStoragePrintFiles, prometheus.GaugeValue,
+			storage.PrintFiles, storageLabels...)
 
-			ch <- printSpeed
+		ch <- prometheus.MustNewConstMetric(collector.printerStorageSystemFiles, prometheus.GaugeValue,
+			storage.SystemFiles, storageLabels...)
+	}
 
-			printTime := prometheus.MustNewConstMetric(
-				collector.printerPrintTime, prometheus.GaugeValue,
-				job.Progress.PrintTime,
-				s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+	for _, camera := range cameras.CameraList {
+		ch <- prometheus.MustNewConstMetric(collector.printerCameraInfo, prometheus.GaugeValue,
+			1, GetLabels(s, job, camera.CameraID, camera.Config.Name, camera.Config.Driver, camera.Config.Resolution, camera.Config.TriggerScheme)...)
 
-			ch <- printTime
+		ch <- prometheus.MustNewConstMetric(collector.printerCameraConnected, prometheus.GaugeValue,
+			BoolToFloat(camera.Connected), GetLabels(s, job, camera.CameraID)...)
 
-			printTimeRemaining := prometheus.MustNewConstMetric(
-				collector.printerPrintTimeRemaining, prometheus.GaugeValue,
-				job.Progress.PrintTimeLeft,
-				s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+		ch <- prometheus.MustNewConstMetric(collector.printerCameraDetected, prometheus.GaugeValue,
+			BoolToFloat(camera.Detected), GetLabels(s, job, camera.CameraID)...)
 
-			ch <- printTimeRemaining
+		ch <- prometheus.MustNewConstMetric(collector.printerCameraRegistered, prometheus.GaugeValue,
+			BoolToFloat(camera.Registered), GetLabels(s, job, camera.CameraID)...)
+	}
 
-			printProgress := prometheus.MustNewConstMetric(
-				collector.printerPrintProgressRatio, prometheus.GaugeValue,
-				job.Progress.Completion,
-				s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path)
+	if jobV1.ID != 0 {
+		meta := jobV1.File.Meta
+		estimatedPrintTime := meta.EstimatedPrintTime
 
-			ch <- printProgress
+		if estimatedPrintTime == 0 {
+			estimatedPrintTime = job.Job.EstimatedPrintTime
+		}
 
-			material := prometheus.MustNewConstMetric(
-				collector.printerMaterial, prometheus.GaugeValue,
-				BoolToFloat(!(strings.Contains(printer.Telemetry.Material, "-"))),
-				s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path, printer.Telemetry.Material)
+		ch <- prometheus.MustNewConstMetric(collector.jobEstimatedPrintTime, prometheus.GaugeValue,
+			estimatedPrintTime, GetLabels(s, job)...)
 
-			ch <- material
+		if meta.LayerHeight > 0 {
+			ch <- prometheus.MustNewConstMetric(collector.jobLayerHeight, prometheus.GaugeValue,
+				meta.LayerHeight/1000, GetLabels(s, job)...)
+		}
 
-			printerAxisX := prometheus.MustNewConstMetric(
-				collector.printerAxis, prometheus.GaugeValue,
-				printer.Telemetry.AxisX,
-				GetLabels(s, job, "x")...)
+		ch <- prometheus.MustNewConstMetric(collector.jobInfo, prometheus.GaugeValue,
+			1, GetLabels(s, job, strconv.FormatFloat(jobV1.ID, 'f', -1, 64), meta.FilamentType, meta.PrinterModel)...)
 
-			ch <- printerAxisX
+		ch <- prometheus.MustNewConstMetric(collector.jobInaccurateEstimates, prometheus.GaugeValue,
+			BoolToFloat(jobV1.InaccurateEstimates), GetLabels(s, job)...)
 
-			printerAxisY := prometheus.MustNewConstMetric(
-				collector.printerAxis, prometheus.GaugeValue,
-				printer.Telemetry.AxisY,
-				GetLabels(s, job, "y")...)
+		// guessed model would raise false alarm
+		if meta.PrinterModel != "" && s.Type != "" && s.Type != "unknown" && !isUncertain(modelConfidence) {
+			ch <- prometheus.MustNewConstMetric(collector.jobModelMismatch, prometheus.GaugeValue,
+				BoolToFloat(IsModelMismatch(meta.PrinterModel, s.Type)), GetLabels(s, job, meta.PrinterModel)...)
+		}
+	}
 
-			ch <- printerAxisY
+	if jobV1.ID != 0 || job.Job.File.Name != "" {
+		for material, filament := range getJobFilament(snapshot) {
+			ch <- prometheus.MustNewConstMetric(collector.jobFilamentGrams, prometheus.GaugeValue,
+				filament.Grams, GetLabels(s, job, material)...)
 
-			printerAxisZ := prometheus.MustNewConstMetric(
-				collector.printerAxis, prometheus.GaugeValue,
-				printer.Telemetry.AxisZ,
-				GetLabels(s, job, "z")...)
+			ch <- prometheus.MustNewConstMetric(collector.jobFilamentMeters, prometheus.GaugeValue,
+				filament.Meters, GetLabels(s, job, material)...)
+		}
+	}
 
-			ch <- printerAxisZ
+	// files are grouped by origin, which matches lower cased storage type - usb, local, sdcard
+	filesCount := map[string]float64{}
+	filesSize := map[string]float64{}
 
-			printerFlow := prometheus.MustNewConstMetric(collector.printerFlow, prometheus.GaugeValue,
-				status.Printer.Flow/100, GetLabels(s, job)...)
+	for _, file := range files.Files {
+		count, size := file.CountFiles()
+		filesCount[file.Origin] += count
+		filesSize[file.Origin] += size
+	}
 
-			ch <- printerFlow
+	for origin, count := range filesCount {
+		ch <- prometheus.MustNewConstMetric(collector.printerFiles, prometheus.GaugeValue,
+			count, GetLabels(s, job, origin)...)
 
-			printerMMU := prometheus.MustNewConstMetric(collector.printerMMU, prometheus.GaugeValue,
-				BoolToFloat(info.Mmu), GetLabels(s, job)...)
-			ch <- printerMMU
+		ch <- prometheus.MustNewConstMetric(collector.printerFilesSize, prometheus.GaugeValue,
+			filesSize[origin], GetLabels(s, job, origin)...)
+	}
 
-			printerBedTemp := prometheus.MustNewConstMetric(collector.printerTemp, prometheus.GaugeValue,
-				printer.Temperature.Bed.Actual, GetLabels(s, job, "bed")...)
+	if configuration.Exporter.LegacyStatus {
+		printerStatus := prometheus.MustNewConstMetric(
+			collector.printerStatus, prometheus.GaugeValue,
+			getStateFlag(printer),
+			s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path, printer.State.Text)
 
-			ch <- printerBedTemp
+		ch <- printerStatus
+	}
 
-			printerBedTempTarget := prometheus.MustNewConstMetric(collector.printerTempTarget, prometheus.GaugeValue,
-				printer.Temperature.Bed.Target, GetLabels(s, job, "bed")...)
+	state := GetPrinterState(snapshot)
 
-			ch <- printerBedTempTarget
+	for _, printerState := range PrinterStates {
+		ch <- prometheus.MustNewConstMetric(collector.printerState, prometheus.GaugeValue,
+			BoolToFloat(printerState == state), s.Address, s.Type, s.Name, printerState)
+	}
 
-			printerToolTempTarget := prometheus.MustNewConstMetric(collector.printerTempTarget, prometheus.GaugeValue,
-				printer.Temperature.Tool0.Target, GetLabels(s, job, "tool0")...)
+	ch <- prometheus.MustNewConstMetric(collector.printerState, prometheus.GaugeValue,
+		BoolToFloat(!slices.Contains(PrinterStates, state)), s.Address, s.Type, s.Name, PrinterStateUnknown)
 
-			ch <- printerToolTempTarget
+	if snapshot.JobImage != "" {
+		printerJobImage := prometheus.MustNewConstMetric(collector.printerJobImage, prometheus.GaugeValue,
+			1, GetLabels(s, job, snapshot.JobImage)...)
 
-			printerToolTemp := prometheus.MustNewConstMetric(collector.printerTemp, prometheus.GaugeValue,
-				printer.Temperature.Tool0.Actual, GetLabels(s, job, "tool0")...)
+		ch <- printerJobImage
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.printerUp, prometheus.GaugeValue,
+		1, s.Address, s.Type, s.Name)
+}
 
-			ch <- printerToolTemp
+// collectJobCounters sends job lifecycle counters of the printer, these are kept even if printer is down
+func (collector *Collector) collectJobCounters(ch chan<- prometheus.Metric, s config.Printers) {
+	counters := collector.poller.Jobs.Counters(s.Address)
 
-			printerStatus := prometheus.MustNewConstMetric(
-				collector.printerStatus, prometheus.GaugeValue,
-				getStateFlag(printer),
-				s.Address, s.Type, s.Name, job.Job.File.Name, job.Job.File.Path, printer.State.Text)
+	ch <- prometheus.MustNewConstMetric(collector.jobsStarted, prometheus.CounterValue,
+		counters.Started, s.Address, s.Type, s.Name)
 
-			ch <- printerStatus
+	ch <- prometheus.MustNewConstMetric(collector.jobsCompleted, prometheus.CounterValue,
+		counters.Completed, s.Address, s.Type, s.Name)
 
-			if getStateFlag(printer) == 4 {
-				image, err := GetJobImage(s, job.Job.File.Path)
+	ch <- prometheus.MustNewConstMetric(collector.jobsCancelled, prometheus.CounterValue,
+		counters.Cancelled, s.Address, s.Type, s.Name)
 
-				if err != nil {
-					log.Error().Msg("Error while scraping image endpoint at " + s.Address + " - " + err.Error())
-				} else {
-					printerJobImage := prometheus.MustNewConstMetric(collector.printerJobImage, prometheus.GaugeValue,
-						1, GetLabels(s, job, image)...)
+	ch <- prometheus.MustNewConstMetric(collector.jobsFailed, prometheus.CounterValue,
+		counters.Failed, s.Address, s.Type, s.Name)
 
-					ch <- printerJobImage
-				}
+	ch <- prometheus.MustNewConstMetric(collector.printSeconds, prometheus.CounterValue,
+		counters.PrintSeconds, s.Address, s.Type, s.Name)
 
-			}
+	for material, filament := range counters.Filament {
+		ch <- prometheus.MustNewConstMetric(collector.filamentUsedGrams, prometheus.CounterValue,
+			filament.Grams, s.Address, s.Type, s.Name, material)
+
+		ch <- prometheus.MustNewConstMetric(collector.filamentUsedMeters, prometheus.CounterValue,
+			filament.Meters, s.Address, s.Type, s.Name, material)
+
+		ch <- prometheus.MustNewConstMetric(collector.filamentCost, prometheus.CounterValue,
+			filament.Cost, s.Address, s.Type, s.Name, material, configuration.Exporter.Filament.Currency)
+	}
+}
+
+// collectUtilization sends time spent in states and rolling utilization of the printer
+func (collector *Collector) collectUtilization(ch chan<- prometheus.Metric, s config.Printers) {
+	seconds := collector.poller.Utilization.StateSeconds(s.Address)
+
+	for _, state := range UtilizationStates {
+		ch <- prometheus.MustNewConstMetric(collector.stateSeconds, prometheus.CounterValue,
+			seconds[state], s.Address, s.Type, s.Name, state)
+	}
+
+	for _, stats := range collector.poller.Utilization.Stats(s.Address, time.Now()) {
+		if stats.Observed == 0 {
+			continue
+		}
+
+		window := model.Duration(stats.Window).String()
 
-			printerUp = prometheus.MustNewConstMetric(collector.printerUp, prometheus.GaugeValue,
-				1, s.Address, s.Type, s.Name)
+		ch <- prometheus.MustNewConstMetric(collector.utilizationRatio, prometheus.GaugeValue,
+			stats.Utilization, s.Address, s.Type, s.Name, window)
 
-			ch <- printerUp
+		ch <- prometheus.MustNewConstMetric(collector.availabilityRatio, prometheus.GaugeValue,
+			stats.Availability, s.Address, s.Type, s.Name, window)
 
-			log.Debug().Msg("Scraping done at " + s.Address)
-		}(s)
+		if stats.Finished > 0 {
+			ch <- prometheus.MustNewConstMetric(collector.jobSuccessRatio, prometheus.GaugeValue,
+				stats.SuccessRate, s.Address, s.Type, s.Name, window)
+		}
 	}
-	wg.Wait()
 }
@@ -0,0 +1,351 @@
+package prusalink
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// gatherSnapshot returns metric families of the collector after the poller stored the snapshot
+func gatherSnapshot(t *testing.T, snapshot Snapshot) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	cfg := config.Config{Printers: []config.Printers{snapshot.Config}}
+	poller := NewPoller(cfg)
+	poller.update(snapshot)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(cfg, poller))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		result[family.GetName()] = family
+	}
+
+	return result
+}
+
+// metricValue returns gauge value of the series with the label, ok is false when it's missing
+func metricValue(family *dto.MetricFamily, label string, value string) (float64, bool) {
+	if family == nil {
+		return 0, false
+	}
+
+	for _, metric := range family.GetMetric() {
+		for _, pair := range metric.GetLabel() {
+			if label == "" || (pair.GetName() == label && pair.GetValue() == value) {
+				return metric.GetGauge().GetValue(), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func xlSnapshot(t *testing.T) Snapshot {
+	t.Helper()
+
+	snapshot := Snapshot{
+		Config:          config.Printers{Address: "192.168.1.10", Name: "xl", Type: "XL"},
+		ModelConfidence: ConfidenceConfigured,
+		Up:              true,
+		Time:            time.Now(),
+		LastSeen:        time.Now(),
+	}
+
+	readFixture(t, "xl/printer.json", &snapshot.Printer)
+	readFixture(t, "xl/v1/status.json", &snapshot.Status)
+	readFixture(t, "v1/info.json", &snapshot.Info)
+
+	return snapshot
+}
+
+func TestCollectXLTools(t *testing.T) {
+	families := gatherSnapshot(t, xlSnapshot(t))
+
+	temperatures := []struct {
+		element string
+		actual  float64
+		target  float64
+	}{
+		{"tool0", 215.0, 215.0},
+		{"tool1", 170.2, 170.0},
+		{"tool2", 24.5, 0},
+		{"tool3", 24.1, 0},
+		{"tool4", 23.9, 0},
+		{"bed", 60.1, 60.0},
+	}
+
+	for _, test := range temperatures {
+		if actual, ok := metricValue(families["prusa_temperature_celsius"], "printer_heated_element", test.element); !ok || actual != test.actual {
+			t.Errorf("prusa_temperature_celsius{%s} = %v (%t), expected %v", test.element, actual, ok, test.actual)
+		}
+
+		if target, ok := metricValue(families["prusa_temperature_target_celsius"], "printer_heated_element", test.element); !ok || target != test.target {
+			t.Errorf("prusa_temperature_target_celsius{%s} = %v (%t), expected %v", test.element, target, ok, test.target)
+		}
+	}
+
+	nozzles := map[string]float64{"tool0": 0.0004, "tool1": 0.0004, "tool2": 0.0006, "tool3": 0.0004, "tool4": 0.00025}
+
+	for tool, expected := range nozzles {
+		if nozzle, ok := metricValue(families["prusa_tool_nozzle_size_meters"], "printer_tool", tool); !ok || nozzle != expected {
+			t.Errorf("prusa_tool_nozzle_size_meters{%s} = %v (%t), expected %v", tool, nozzle, ok, expected)
+		}
+	}
+
+	if material, ok := metricValue(families["prusa_tool_material_info"], "printer_tool", "tool3"); !ok || material != 0 {
+		t.Errorf("prusa_tool_material_info{tool3} = %v (%t), expected 0 for unloaded tool", material, ok)
+	}
+
+	if active, ok := metricValue(families["prusa_active_tool"], "", ""); !ok || active != 0 {
+		t.Errorf("prusa_active_tool = %v (%t), expected 0", active, ok)
+	}
+}
+
+// prusa_nozzle_size_meters keeps value reported by the printer, only per-tool nozzle size is converted to meters
+func TestCollectNozzleSizeUnits(t *testing.T) {
+	families := gatherSnapshot(t, xlSnapshot(t))
+
+	nozzle, ok := metricValue(families["prusa_nozzle_size_meters"], "", "")
+	if !ok || nozzle != 0.4 {
+		t.Errorf("prusa_nozzle_size_meters = %v (%t), expected 0.4 as reported by the printer", nozzle, ok)
+	}
+}
+
+func TestCollectPrinterState(t *testing.T) {
+	tests := []struct {
+		state    string
+		expected string
+	}{
+		{"PRINTING", "PRINTING"},
+		{"ready", "READY"},
+		{"CALIBRATING", PrinterStateUnknown},
+	}
+
+	for _, test := range tests {
+		snapshot := xlSnapshot(t)
+		snapshot.Status.Printer.State = test.state
+		families := gatherSnapshot(t, snapshot)
+
+		for _, state := range append(slices.Clone(PrinterStates), PrinterStateUnknown) {
+			value, ok := metricValue(families["prusa_printer_state"], "state", state)
+			if !ok || value != BoolToFloat(state == test.expected) {
+				t.Errorf("%s: prusa_printer_state{state=%q} = %v (%t), expected %v", test.state, state, value, ok, BoolToFloat(state == test.expected))
+			}
+		}
+	}
+}
+
+// buddySnapshot returns snapshot of single tool Buddy printer with the status fixture
+func buddySnapshot(t *testing.T, printerType string, status string) Snapshot {
+	t.Helper()
+
+	snapshot := Snapshot{
+		Config:          config.Printers{Address: "192.168.1.20", Name: "printer", Type: printerType},
+		ModelConfidence: ConfidenceConfigured,
+		Up:              true,
+		Time:            time.Now(),
+		LastSeen:        time.Now(),
+	}
+
+	readFixture(t, "printer.json", &snapshot.Printer)
+	readFixture(t, status, &snapshot.Status)
+	readFixture(t, "v1/info.json", &snapshot.Info)
+
+	return snapshot
+}
+
+func TestCollectChamber(t *testing.T) {
+	tests := []struct {
+		name        string
+		printerType string
+		status      string
+		chamber     bool
+		temperature float64
+		target      float64
+		fan         float64
+	}{
+		{"Core One", "COREONE", "coreone/v1/status.json", true, 44.8, 45, 2400},
+		{"MK4 without chamber", "MK4", "v1/status.json", false, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		families := gatherSnapshot(t, buddySnapshot(t, test.printerType, test.status))
+
+		temperature, hasTemperature := metricValue(families["prusa_temperature_celsius"], "printer_heated_element", "chamber")
+		target, hasTarget := metricValue(families["prusa_temperature_target_celsius"], "printer_heated_element", "chamber")
+		fan, hasFan := metricValue(families["prusa_fan_speed_rpm"], "fan", "chamber")
+
+		if hasTemperature != test.chamber || hasTarget != test.chamber || hasFan != test.chamber {
+			t.Errorf("%s: chamber series present = %t, %t, %t, expected %t", test.name, hasTemperature, hasTarget, hasFan, test.chamber)
+			continue
+		}
+
+		if temperature != test.temperature || target != test.target || fan != test.fan {
+			t.Errorf("%s: chamber = %v, target %v, fan %v, expected %v, %v, %v", test.name, temperature, target, fan, test.temperature, test.target, test.fan)
+		}
+	}
+}
+
+func TestGetChamber(t *testing.T) {
+	actual, target := 40.0, 45.0
+
+	var status Status
+	status.Printer.TempChamber = &actual
+	status.Printer.TargetChamber = &target
+
+	var printer Printer
+	if chamber := getChamber(printer, status); chamber == nil || chamber.Actual != 40 || chamber.Target != 45 {
+		t.Errorf("getChamber() = %+v, expected chamber from status", chamber)
+	}
+
+	// chamber of /api/printer is preferred
+	printer.Temperature.Chamber = &Heater{Actual: 41, Target: 50}
+	if chamber := getChamber(printer, status); chamber == nil || chamber.Actual != 41 || chamber.Target != 50 {
+		t.Errorf("getChamber() = %+v, expected chamber from printer", chamber)
+	}
+
+	// target without temperature is not a chamber sensor
+	status.Printer.TempChamber = nil
+	if chamber := getChamber(Printer{}, status); chamber != nil {
+		t.Errorf("getChamber() = %+v, expected nil without chamber temperature", chamber)
+	}
+}
+
+func TestCollectStorage(t *testing.T) {
+	snapshot := buddySnapshot(t, "MK4", "v1/status.json")
+	readFixture(t, "storage/files.json", &snapshot.Files)
+	readFixture(t, "storage/v1/storage.json", &snapshot.Storage)
+
+	families := gatherSnapshot(t, snapshot)
+
+	tests := []struct {
+		metric  string
+		storage string
+		value   float64
+		present bool
+	}{
+		{"prusa_files_count", "usb", 2, true},
+		{"prusa_files_size_bytes", "usb", 1500000, true},
+		{"prusa_files_count", "local", 1, true},
+		{"prusa_files_size_bytes", "local", 500000, true},
+		{"prusa_storage_free_bytes", "usb", 30000000000, true},
+		{"prusa_storage_total_bytes", "usb", 32000000000, true},
+		{"prusa_storage_available", "usb", 1, true},
+		{"prusa_storage_read_only", "usb", 0, true},
+		{"prusa_storage_print_files_bytes", "usb", 1500000, true},
+		{"prusa_storage_system_files_bytes", "usb", 2000000, true},
+		{"prusa_storage_available", "local", 0, true},
+		{"prusa_storage_read_only", "local", 1, true},
+		// space is not reported by all storages, missing values are not exported as 0
+		{"prusa_storage_free_bytes", "local", 0, false},
+		{"prusa_storage_total_bytes", "local", 0, false},
+	}
+
+	for _, test := range tests {
+		value, ok := metricValue(families[test.metric], "printer_storage", test.storage)
+
+		if ok != test.present || value != test.value {
+			t.Errorf("%s{%s} = %v (%t), expected %v (%t)", test.metric, test.storage, value, ok, test.value, test.present)
+		}
+	}
+}
+
+// jobMetaSnapshot returns printing snapshot with /api/v1/job carrying the gcode metadata
+func jobMetaSnapshot(t *testing.T, printerType string, confidence string, meta string) Snapshot {
+	t.Helper()
+
+	snapshot := buddySnapshot(t, printerType, "v1/status.json")
+	snapshot.ModelConfidence = confidence
+	snapshot.Job.Job.EstimatedPrintTime = 7200
+
+	data := `{"id":109,"state":"PRINTING","progress":10,"inaccurate_estimates":true,"file":{"name":"BENCHY~1.BGC","display_name":"benchy.bgcode","path":"/usb","meta":` + meta + `}}`
+	if err := json.Unmarshal([]byte(data), &snapshot.JobV1); err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func TestCollectJobMetadata(t *testing.T) {
+	meta := `{"printer_model":"MK4IS","layer_height":0.2,"filament_type":"PLA","estimated_print_time":3600}`
+	families := gatherSnapshot(t, jobMetaSnapshot(t, "MK4", ConfidenceConfigured, meta))
+
+	if estimated, ok := metricValue(families["prusa_job_estimated_print_time_seconds"], "", ""); !ok || estimated != 3600 {
+		t.Errorf("prusa_job_estimated_print_time_seconds = %v (%t), expected 3600", estimated, ok)
+	}
+
+	if layer, ok := metricValue(families["prusa_job_layer_height_meters"], "", ""); !ok || math.Abs(layer-0.0002) > 1e-12 {
+		t.Errorf("prusa_job_layer_height_meters = %v (%t), expected 0.0002", layer, ok)
+	}
+
+	if inaccurate, ok := metricValue(families["prusa_job_inaccurate_estimates"], "", ""); !ok || inaccurate != 1 {
+		t.Errorf("prusa_job_inaccurate_estimates = %v (%t), expected 1", inaccurate, ok)
+	}
+
+	info := families["prusa_job_info"]
+	if info == nil || len(info.GetMetric()) != 1 {
+		t.Fatalf("prusa_job_info = %v, expected one series", info)
+	}
+
+	labels := map[string]string{}
+	for _, pair := range info.GetMetric()[0].GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+
+	if labels["job_id"] != "109" || labels["filament_type"] != "PLA" || labels["sliced_for_model"] != "MK4IS" {
+		t.Errorf("prusa_job_info labels = %v, expected job 109 sliced for MK4IS with PLA", labels)
+	}
+
+	// estimate of legacy job endpoint is used when metadata don't have it, layer height is not exported without metadata
+	families = gatherSnapshot(t, jobMetaSnapshot(t, "MK4", ConfidenceConfigured, `{}`))
+
+	if estimated, ok := metricValue(families["prusa_job_estimated_print_time_seconds"], "", ""); !ok || estimated != 7200 {
+		t.Errorf("prusa_job_estimated_print_time_seconds = %v (%t), expected 7200 from /api/job", estimated, ok)
+	}
+
+	if _, ok := metricValue(families["prusa_job_layer_height_meters"], "", ""); ok {
+		t.Errorf("prusa_job_layer_height_meters is exported without metadata")
+	}
+}
+
+func TestCollectJobModelMismatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		printerType string
+		confidence  string
+		slicedFor   string
+		present     bool
+		mismatch    float64
+	}{
+		{"same model", "MK4", ConfidenceConfigured, "MK4IS", true, 0},
+		{"other model", "MK4S", ConfidenceConfigured, "MK4IS", true, 1},
+		{"MK3.9 job on MK4", "MK4", ConfidenceHigh, "MK3.9", true, 1},
+		{"medium confidence", "MK4S", ConfidenceMedium, "MK4IS", true, 1},
+		{"low confidence is not reported", "MK4S", ConfidenceLow, "MK4IS", false, 0},
+		{"no detection is not reported", "unknown", ConfidenceNone, "MK4IS", false, 0},
+		{"job without model", "MK4", ConfidenceConfigured, "", false, 0},
+	}
+
+	for _, test := range tests {
+		meta := `{"printer_model":"` + test.slicedFor + `"}`
+		families := gatherSnapshot(t, jobMetaSnapshot(t, test.printerType, test.confidence, meta))
+
+		mismatch, ok := metricValue(families["prusa_job_model_mismatch"], "", "")
+		if ok != test.present || mismatch != test.mismatch {
+			t.Errorf("%s: prusa_job_model_mismatch = %v (%t), expected %v (%t)", test.name, mismatch, ok, test.mismatch, test.present)
+		}
+	}
+}
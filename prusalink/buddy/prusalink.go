@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/icholy/digest"
@@ -31,28 +35,11 @@ var (
 		"SL1S":    "sl",
 	}*/
 
-	// used for autodetection - does not work with changed hostname :sad:
-	printerTypes = map[string]string{
-		"PrusaMINI":         "MINI",
-		"PrusaMK4":          "MK4", // unfortunately MK3.5 is also detected as MK4
-		"PrusaXL":           "XL",
-		"PrusaLink I3MK3S":  "I3MK3S",
-		"PrusaLink I3MK3":   "I3MK3",
-		"PrusaLink I3MK25S": "I3MK25S",
-		"PrusaLink I3MK25":  "I3MK25",
-		"prusa-sl1":         "SL1",
-		"prusa-sl1s":        "SL1S",
-		"Prusa_iX":          "IX", // can be found in src/common/config.h in firmware source code
-	}
-
 	configuration config.Config
 )
 
 // GetLabels is used to get the labels for the given printer and job
 func GetLabels(printer config.Printers, job Job, labelValues ...string) []string {
-	if job == (Job{}) {
-		return append([]string{printer.Address, printer.Type, printer.Name, "", ""}, labelValues...)
-	}
 	return append([]string{printer.Address, printer.Type, printer.Name, job.Job.File.Name, job.Job.File.Path}, labelValues...)
 }
 
@@ -66,9 +53,42 @@ func BoolToFloat(boolean bool) float64 {
 	return 1.0
 }
 
+// PrinterStates contains all printer states reported by /api/v1/status in the order they are exported
+var PrinterStates = []string{"IDLE", "BUSY", "PRINTING", "PAUSED", "FINISHED", "STOPPED", "ERROR", "ATTENTION", "READY"}
+
+// PrinterStateUnknown is exported instead of states the exporter does not know
+const PrinterStateUnknown = "UNKNOWN"
+
+// GetPrinterState returns printer state from /api/v1/status, it's derived from legacy flags when the printer does not report it
+func GetPrinterState(snapshot Snapshot) string {
+	if state := strings.ToUpper(snapshot.Status.Printer.State); state != "" {
+		return state
+	}
+
+	flags := snapshot.Printer.State.Flags
+
+	switch {
+	case flags.Error || flags.ClosedOrError || flags.ClosedOnError:
+		return "ERROR"
+	case flags.Paused || flags.Pausing:
+		return "PAUSED"
+	case flags.Printing || flags.Cancelling:
+		return "PRINTING"
+	case flags.Finished:
+		return "FINISHED"
+	case flags.Busy:
+		return "BUSY"
+	case flags.Ready || flags.Prepared:
+		return "READY"
+	default:
+		return "IDLE"
+	}
+}
+
 // getStateFlag returns the state flag for the given printer.
 // The state flag is a float64 value representing the current state of the printer.
 // It is used for tracking the printer's status and progress.
+// Deprecated: flags are checked by priority, so printing printer with operational flag returns 1 - use GetPrinterState.
 func getStateFlag(printer Printer) float64 {
 	if printer.State.Flags.Operational {
 		return 1
@@ -99,51 +119,99 @@ func getStateFlag(printer Printer) float64 {
 	}
 }
 
-// accessPrinterEndpoint is used to access the printer's API endpoint
-func accessPrinterEndpoint(path string, printer config.Printers) ([]byte, error) {
-	url := string("http://" + printer.Address + path)
-	var (
-		res    *http.Response
-		result []byte
-		err    error
-	)
+// getChamber returns chamber temperatures from printer or status endpoint, nil is returned for printers without chamber sensor
+func getChamber(printer Printer, status Status) *Heater {
+	if printer.Temperature.Chamber != nil {
+		return printer.Temperature.Chamber
+	}
 
-	if printer.Apikey == "" {
-		client := &http.Client{
-			Transport: &digest.Transport{
-				Username: printer.Username,
-				Password: printer.Password,
-			},
-			Timeout: 5 * time.Duration(configuration.Exporter.ScrapeTimeout) * time.Second,
-		}
-		res, err = client.Get(url)
+	if status.Printer.TempChamber == nil {
+		return nil
+	}
 
-		if err != nil {
-			return result, err
-		}
-	} else {
-		req, err := http.NewRequest("GET", url, nil)
-		client := &http.Client{
-			Timeout: 5 * time.Duration(configuration.Exporter.ScrapeTimeout) * time.Second,
-		}
+	chamber := Heater{Actual: *status.Printer.TempChamber}
+	if status.Printer.TargetChamber != nil {
+		chamber.Target = *status.Printer.TargetChamber
+	}
 
-		if err != nil {
-			return result, err
-		}
+	return &chamber
+}
+
+// doPrinterRequest sends request to the printer, digest authentication is used when API key is not configured
+func doPrinterRequest(method string, path string, body io.Reader, headers map[string]string, printer config.Printers, timeout time.Duration) (*http.Response, error) {
+	req, err := newPrinterRequest(method, path, body, headers, printer)
+
+	if err != nil {
+		return nil, err
+	}
 
+	return newPrinterClient(printer, timeout).Do(req)
+}
+
+// printerTimeout returns timeout of single request to the printer, printer configuration overrides the flag
+func printerTimeout(printer config.Printers) time.Duration {
+	if printer.ScrapeTimeout != 0 {
+		return time.Duration(printer.ScrapeTimeout)
+	}
+
+	return time.Duration(configuration.Exporter.ScrapeTimeout)
+}
+
+// newPrinterRequest returns request to the printer with API key when it's configured
+func newPrinterRequest(method string, path string, body io.Reader, headers map[string]string, printer config.Printers) (*http.Request, error) {
+	req, err := http.NewRequest(method, "http://"+printer.Address+path, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if printer.Apikey != "" {
 		req.Header.Add("X-Api-Key", printer.Apikey)
-		res, err = client.Do(req)
-		if err != nil {
-			return result, err
+	}
+
+	return req, nil
+}
+
+// newPrinterClient returns client of the printer, digest transport keeps the last challenge, so following requests are authenticated at first attempt
+func newPrinterClient(printer config.Printers, timeout time.Duration) *http.Client {
+	client := &http.Client{
+		Timeout: timeout,
+	}
+
+	if printer.Apikey == "" {
+		client.Transport = &digest.Transport{
+			Username: printer.Username,
+			Password: printer.Password,
 		}
 	}
-	result, err = io.ReadAll(res.Body)
+
+	return client
+}
+
+// accessPrinterEndpoint is used to access the printer's API endpoint
+func accessPrinterEndpoint(path string, printer config.Printers) ([]byte, error) {
+	res, err := doPrinterRequest("GET", path, nil, nil, printer, printerTimeout(printer))
+
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := io.ReadAll(res.Body)
 	res.Body.Close()
 
 	if err != nil {
 		log.Error().Msg(err.Error())
 	}
 
+	// error pages can be valid JSON, they must not be parsed as the endpoint data
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s returned status %d", path, res.StatusCode)
+	}
+
 	return result, nil
 }
 
@@ -208,7 +276,7 @@ func GetJobV1(printer config.Printers) (JobV1, error) {
 	var job JobV1
 	response, err := accessPrinterEndpoint("/api/v1/job", printer)
 
-	if err != nil {
+	if err != nil || len(response) == 0 { // 204 No Content is returned when printer is not printing
 		return job, err
 	}
 
@@ -287,11 +355,19 @@ func GetCameras(printer config.Printers) (Cameras, error) {
 	return cameras, err
 }
 
-// GetPrinterProfiles is used to get the printer's printerprofiles API endpoint
+// GetPrinterProfiles is used to get the printer's printerprofiles API endpoint, OctoPrint compatible /api/printerprofiles served by PrusaLink on Einsy is used when /api/v1/printerprofiles fails
 func GetPrinterProfiles(printer config.Printers) (PrinterProfiles, error) {
 	var profiles PrinterProfiles
 	response, err := accessPrinterEndpoint("/api/v1/printerprofiles", printer)
 
+	if err == nil {
+		if err = json.Unmarshal(response, &profiles); err == nil {
+			return profiles, nil
+		}
+	}
+
+	response, err = accessPrinterEndpoint("/api/printerprofiles", printer)
+
 	if err != nil {
 		return profiles, err
 	}
@@ -301,6 +377,71 @@ func GetPrinterProfiles(printer config.Printers) (PrinterProfiles, error) {
 	return profiles, err
 }
 
+// ErrCameraNotFound is returned when the printer does not know the camera
+var ErrCameraNotFound = errors.New("camera not found")
+
+// GetCameraSnapshot is used to get the latest snapshot of the printer's camera, it returns image with its content type
+func GetCameraSnapshot(printer config.Printers, cameraID string) ([]byte, string, error) {
+	res, err := doPrinterRequest("GET", "/api/v1/cameras/"+url.PathEscape(cameraID)+"/snap", nil, nil, printer, printerTimeout(printer))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("camera %s returned status %d - %w", cameraID, res.StatusCode, ErrCameraNotFound)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("camera %s returned status %d", cameraID, res.StatusCode)
+	}
+
+	snapshot, err := io.ReadAll(res.Body)
+
+	return snapshot, res.Header.Get("Content-Type"), err
+}
+
+// ErrJobState is returned by job control when the job is not in the right state for the action
+var ErrJobState = errors.New("job is not in the right state")
+
+// PauseJob is used to pause the printer's job
+func PauseJob(printer config.Printers, jobID string) error {
+	return controlJob("PUT", "/api/v1/job/"+url.PathEscape(jobID)+"/pause", printer)
+}
+
+// ResumeJob is used to resume the printer's paused job
+func ResumeJob(printer config.Printers, jobID string) error {
+	return controlJob("PUT", "/api/v1/job/"+url.PathEscape(jobID)+"/resume", printer)
+}
+
+// StopJob is used to stop the printer's job
+func StopJob(printer config.Printers, jobID string) error {
+	return controlJob("DELETE", "/api/v1/job/"+url.PathEscape(jobID), printer)
+}
+
+// controlJob sends job control request to the printer, printer answers 204 on success and 409 when job is not in the right state
+func controlJob(method string, path string, printer config.Printers) error {
+	res, err := doPrinterRequest(method, path, nil, nil, printer, printerTimeout(printer))
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%s %s returned status %d - %w", method, path, res.StatusCode, ErrJobState)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned status %d", method, path, res.StatusCode)
+	}
+
+	return nil
+}
+
 // GetJobImage is used to get the printer's job image from API
 func GetJobImage(printer config.Printers, imagePath string) (string, error) { // returns base64 encoded image
 	//http://192.168.20.50/thumb/l/usb/PYTHON~1.BGC
@@ -338,44 +479,15 @@ func compressPNG(input []byte, compressionLevel png.CompressionLevel) ([]byte, e
 
 // GetPrinterType returns the printer type of the given printer - e.g. "MINI", "MK4", "XL", "I3MK3S", "I3MK3", "I3MK25S",
 func GetPrinterType(printer config.Printers) (string, error) {
-	version, err := GetVersion(printer)
-	if err != nil {
-		return "unknown", err
-	}
-
-	printerType := version.Hostname
-
-	if version.Hostname == "" {
-		if version.Original == "" {
-			info, err := GetInfo(printer)
-			if err != nil {
-				return "unknown", err
-			}
-			printerType = info.Hostname
-		} else {
-			printerType = version.Original
-		}
-	} else if version.Original != "" {
-		printerType = version.Original
-	}
-
-	if printerTypes[printerType] != "" {
-		printerType = printerTypes[printerType]
-	}
-
-	if printerType == "" {
-		printerType = "unknown"
-	}
-
-	log.Trace().Msg(printerType + " detected for " + printer.Address + " (" + printer.Name + ")")
+	detection, err := DetectPrinterModel(printer)
 
-	return printerType, nil
+	return detection.Model, err
 }
 
 // ProbePrinter is used to probe the printer - just testing the connection
 func ProbePrinter(printer config.Printers) (bool, error) {
 	req, _ := http.NewRequest("GET", "http://"+printer.Address+"/", nil)
-	client := &http.Client{Timeout: time.Duration(configuration.Exporter.ScrapeTimeout) * time.Millisecond}
+	client := &http.Client{Timeout: printerTimeout(printer)}
 	r, e := client.Do(req)
 
 	if e != nil {
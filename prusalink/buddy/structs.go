@@ -1,5 +1,14 @@
 package prusalink
 
+import (
+	"encoding/json"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 // Version is a struct that holds the version information of the printer - buddy, einsy and sl
 type Version struct {
 	API          string `json:"api"`
@@ -12,6 +21,7 @@ type Version struct {
 		UploadByPut bool `json:"upload-by-put"`
 	} `json:"capabilities"`
 	Hostname string `json:"hostname"`
+	Printer  string `json:"printer,omitempty"` // printer type code, e.g. 1.4.1 for MK4S - reported by newer firmware only
 }
 
 // Job is a struct that contains data about print job
@@ -27,10 +37,10 @@ type Job struct {
 			Origin  string  `json:"origin"`
 			Date    float64 `json:"date"`
 		} `json:"file"`
-		AveragePrintTime any    `json:"averagePrintTime"`
-		LastPrintTime    any    `json:"lastPrintTime"`
-		Filament         any    `json:"filament"`
-		User             string `json:"user"`
+		AveragePrintTime any                      `json:"averagePrintTime"`
+		LastPrintTime    any                      `json:"lastPrintTime"`
+		Filament         map[string]FilamentUsage `json:"filament"`
+		User             string                   `json:"user"`
 	} `json:"job"`
 	Progress struct {
 		PrintTimeLeft       float64 `json:"printTimeLeft"`
@@ -63,25 +73,8 @@ type Printer struct {
 		TempCPU     float64 `json:"tempCpu"`
 		TempUvLed   float64 `json:"tempUvLed"`
 	} `json:"telemetry"`
-	Temperature struct {
-		Tool0 struct {
-			Actual  float64 `json:"actual"`
-			Target  float64 `json:"target"`
-			Display float64 `json:"display"`
-			Offset  float64 `json:"offset"`
-		} `json:"tool0"`
-		Bed struct {
-			Actual float64 `json:"actual"`
-			Target float64 `json:"target"`
-			Offset float64 `json:"offset"`
-		} `json:"bed"`
-		Chamber struct {
-			Actual float64 `json:"actual"`
-			Offset float64 `json:"offset"`
-			Target float64 `json:"target"`
-		} `json:"chamber"`
-	} `json:"temperature"`
-	State struct {
+	Temperature Temperature `json:"temperature"`
+	State       struct {
 		Text  string `json:"text"`
 		Flags struct {
 			LinkState     string `json:"link_state"`
@@ -109,34 +102,151 @@ type Printer struct {
 	} `json:"storage"`
 }
 
+// Heater is a struct that contains temperatures of single heated element
+type Heater struct {
+	Actual  float64 `json:"actual"`
+	Target  float64 `json:"target"`
+	Display float64 `json:"display"`
+	Offset  float64 `json:"offset"`
+}
+
+// Tool is a struct that contains data about single toolhead - nozzle diameter and material are reported by multi-tool printers only
+type Tool struct {
+	Heater
+	NozzleDiameter float64 `json:"nozzle_diameter"`
+	Material       string  `json:"material"`
+}
+
+// Temperature is a struct that contains temperatures of the printer - all toolN entries are parsed into Tools, Tool0 is kept for single tool printers
+type Temperature struct {
+	Tool0   Tool
+	Bed     Heater
+	Chamber *Heater // nil for printers without chamber sensor
+	Tools   map[int]Tool
+}
+
+// UnmarshalJSON parses temperatures with dynamic number of toolN entries
+func (temperature *Temperature) UnmarshalJSON(data []byte) error {
+	var elements map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+
+	temperature.Tools = map[int]Tool{}
+
+	for name, raw := range elements {
+		var err error
+
+		switch {
+		case name == "bed":
+			err = json.Unmarshal(raw, &temperature.Bed)
+		case name == "chamber" && string(raw) != "null":
+			temperature.Chamber = &Heater{}
+			err = json.Unmarshal(raw, temperature.Chamber)
+		case strings.HasPrefix(name, "tool"):
+			index, convErr := strconv.Atoi(strings.TrimPrefix(name, "tool"))
+			if convErr != nil {
+				continue
+			}
+			var tool Tool
+			err = json.Unmarshal(raw, &tool)
+			temperature.Tools[index] = tool
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	temperature.Tool0 = temperature.Tools[0]
+
+	return nil
+}
+
+// ToolIndexes returns sorted indexes of all reported tools
+func (temperature Temperature) ToolIndexes() []int {
+	indexes := make([]int, 0, len(temperature.Tools))
+
+	for index := range temperature.Tools {
+		indexes = append(indexes, index)
+	}
+
+	sort.Ints(indexes)
+
+	return indexes
+}
+
 // Files is a struct that contains data about the files on the printer
 type Files struct {
-	Files []struct {
-		Name     string `json:"name"`
-		Path     string `json:"path"`
-		Display  string `json:"display"`
-		Type     string `json:"type"`
-		Origin   string `json:"origin"`
-		Children []struct {
-			Name    string `json:"name"`
-			Display string `json:"display"`
-			Path    string `json:"path"`
-			Origin  string `json:"origin"`
-			Refs    struct {
-				Resource       string `json:"resource"`
-				ThumbnailSmall string `json:"thumbnailSmall"`
-				ThumbnailBig   string `json:"thumbnailBig"`
-				Download       string `json:"download"`
-			} `json:"refs"`
-		} `json:"children"`
-		Date     float64  `json:"date"`
-		Size     float64  `json:"size"`
-		TypePath []string `json:"typePath"`
-		Refs     struct {
-			Resource any `json:"resource"`
-		} `json:"refs"`
-		ReadOnly bool `json:"read_only,omitempty"`
-	} `json:"files"`
+	Files []File `json:"files"`
+}
+
+// File is a struct that contains data about single file or folder, folders contain their files in Children
+type File struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Display  string   `json:"display"`
+	Type     string   `json:"type"`
+	Origin   string   `json:"origin"`
+	Date     float64  `json:"date"`
+	Size     float64  `json:"size"`
+	TypePath []string `json:"typePath"`
+	Refs     struct {
+		Resource       any `json:"resource"`
+		ThumbnailSmall any `json:"thumbnailSmall"`
+		ThumbnailBig   any `json:"thumbnailBig"`
+		Download       any `json:"download"`
+		Icon           any `json:"icon"`
+		Thumbnail      any `json:"thumbnail"`
+	} `json:"refs"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	Children []File `json:"children"`
+}
+
+// IsFolder returns true for folders - Buddy does not report type of files, so folders are recognized by children too
+func (file File) IsFolder() bool {
+	return file.Type == "folder" || len(file.Children) > 0
+}
+
+// printFileExtensions are extensions of gcode files, Buddy reports files on USB by 8.3 names, e.g. BENCHY~1.BGC
+var printFileExtensions = []string{".gcode", ".gco", ".g", ".bgcode", ".bgc"}
+
+// IsPrintFile returns true for gcode and binary gcode files, display name is checked first as it keeps the long extension
+func (file File) IsPrintFile() bool {
+	if file.IsFolder() {
+		return false
+	}
+
+	if file.Type == "machinecode" {
+		return true
+	}
+
+	for _, name := range []string{file.Display, file.Name} {
+		if slices.Contains(printFileExtensions, strings.ToLower(path.Ext(name))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CountFiles returns recursive count and total size in bytes of print files in the folder, other files are not counted
+func (file File) CountFiles() (count float64, size float64) {
+	if !file.IsFolder() {
+		if file.IsPrintFile() {
+			return 1, file.Size
+		}
+		return 0, 0
+	}
+
+	for _, child := range file.Children {
+		childCount, childSize := child.CountFiles()
+		count += childCount
+		size += childSize
+	}
+
+	return count, size
 }
 
 // JobV1 is a struct that contains data about the print job from path /api/v1/job
@@ -160,11 +270,14 @@ type JobV1 struct {
 		MTimestamp  float64 `json:"m_timestamp"`
 		DisplayPath string  `json:"display_path"`
 		Meta        struct {
-			EstimatedPrintingTimeNormalMode string  `json:"estimated printing time (normal mode)"`
-			PrinterModel                    string  `json:"printer_model"`
-			LayerHeight                     float64 `json:"layer_height"`
-			FilamentType                    string  `json:"filament_type"`
-			EstimatedPrintTime              float64 `json:"estimated_print_time"`
+			EstimatedPrintingTimeNormalMode string     `json:"estimated printing time (normal mode)"`
+			PrinterModel                    string     `json:"printer_model"`
+			LayerHeight                     float64    `json:"layer_height"`
+			FilamentType                    string     `json:"filament_type"`
+			EstimatedPrintTime              float64    `json:"estimated_print_time"`
+			FilamentUsedMillimeters         MetaValues `json:"filament used [mm]"`
+			FilamentUsedGrams               MetaValues `json:"filament used [g]"`
+			FilamentUsedVolume              MetaValues `json:"filament used [cm3]"`
 		} `json:"meta"`
 	} `json:"file"`
 }
@@ -178,33 +291,37 @@ type Status struct {
 		TimePrinting  float64 `json:"time_printing"`
 	} `json:"job"`
 	Printer struct {
-		State        string  `json:"state"`
-		TempBed      float64 `json:"temp_bed"`
-		TargetBed    float64 `json:"target_bed"`
-		TempNozzle   float64 `json:"temp_nozzle"`
-		TargetNozzle float64 `json:"target_nozzle"`
-		AxisX        float64 `json:"axis_x"`
-		AxisY        float64 `json:"axis_y"`
-		AxisZ        float64 `json:"axis_z"`
-		Flow         float64 `json:"flow"`
-		Speed        float64 `json:"speed"`
-		FanHotend    float64 `json:"fan_hotend"`
-		FanPrint     float64 `json:"fan_print"`
+		State         string   `json:"state"`
+		TempBed       float64  `json:"temp_bed"`
+		TargetBed     float64  `json:"target_bed"`
+		TempNozzle    float64  `json:"temp_nozzle"`
+		TargetNozzle  float64  `json:"target_nozzle"`
+		AxisX         float64  `json:"axis_x"`
+		AxisY         float64  `json:"axis_y"`
+		AxisZ         float64  `json:"axis_z"`
+		Flow          float64  `json:"flow"`
+		Speed         float64  `json:"speed"`
+		FanHotend     float64  `json:"fan_hotend"`
+		FanPrint      float64  `json:"fan_print"`
+		ActiveTool    *float64 `json:"active_tool,omitempty"`
+		TempChamber   *float64 `json:"temp_chamber,omitempty"`   // Core One only
+		TargetChamber *float64 `json:"target_chamber,omitempty"` // Core One only
+		FanChamber    *float64 `json:"fan_chamber,omitempty"`    // Core One only
 	} `json:"printer"`
 }
 
 // StorageV1 is a struct that contains data about the storage from path /api/v1/storage
 type StorageV1 struct {
 	StorageList []struct {
-		Path        string  `json:"path"`
-		Name        string  `json:"name"`
-		Type        string  `json:"type"`
-		ReadOnly    bool    `json:"read_only"`
-		Available   bool    `json:"available"`
-		FreeSpace   float64 `json:"free_space,omitempty"`
-		TotalSpace  float64 `json:"total_space,omitempty"`
-		PrintFiles  float64 `json:"print_files"`
-		SystemFiles float64 `json:"system_files"`
+		Path        string   `json:"path"`
+		Name        string   `json:"name"`
+		Type        string   `json:"type"`
+		ReadOnly    bool     `json:"read_only"`
+		Available   bool     `json:"available"`
+		FreeSpace   *float64 `json:"free_space,omitempty"`  // not reported by all storages
+		TotalSpace  *float64 `json:"total_space,omitempty"` // not reported by all storages
+		PrintFiles  float64  `json:"print_files"`           // size of print files in bytes
+		SystemFiles float64  `json:"system_files"`          // size of system files in bytes
 	} `json:"storage_list"`
 }
 
@@ -220,6 +337,7 @@ type Info struct {
 	Serial            string  `json:"serial"`
 	Hostname          string  `json:"hostname"`
 	Port              float64 `json:"port"`
+	Printer           string  `json:"printer,omitempty"` // printer type code, e.g. 7.1.0 for Core One - reported by newer firmware only
 }
 
 // PrinterProfiles is a struct that contains data about the printer profiles
@@ -0,0 +1,133 @@
+package prusalink
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// readFixture unmarshals fixture from prusalink/api/buddy
+func readFixture(t *testing.T, path string, value any) {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "api", "buddy", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		t.Fatalf("unmarshal %s: %v", path, err)
+	}
+}
+
+func TestTemperatureXLTools(t *testing.T) {
+	var printer Printer
+	readFixture(t, "xl/printer.json", &printer)
+
+	temperature := printer.Temperature
+
+	if indexes := temperature.ToolIndexes(); !slices.Equal(indexes, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("ToolIndexes() = %v, expected [0 1 2 3 4]", indexes)
+	}
+
+	tests := []struct {
+		index    int
+		actual   float64
+		target   float64
+		nozzle   float64
+		material string
+	}{
+		{0, 215.0, 215.0, 0.4, "PLA"},
+		{1, 170.2, 170.0, 0.4, "PETG"},
+		{2, 24.5, 0, 0.6, "PLA"},
+		{3, 24.1, 0, 0.4, " - "},
+		{4, 23.9, 0, 0.25, "FLEX"},
+	}
+
+	for _, test := range tests {
+		tool := temperature.Tools[test.index]
+
+		if tool.Actual != test.actual || tool.Target != test.target || tool.NozzleDiameter != test.nozzle || tool.Material != test.material {
+			t.Errorf("tool%d = %+v, expected actual %v, target %v, nozzle %v, material %q", test.index, tool, test.actual, test.target, test.nozzle, test.material)
+		}
+	}
+
+	if temperature.Tool0 != temperature.Tools[0] {
+		t.Errorf("Tool0 = %+v, expected %+v", temperature.Tool0, temperature.Tools[0])
+	}
+
+	if temperature.Bed.Actual != 60.1 || temperature.Bed.Target != 60.0 {
+		t.Errorf("bed = %+v, expected 60.1/60", temperature.Bed)
+	}
+
+	if temperature.Chamber != nil {
+		t.Errorf("chamber = %+v, expected nil for XL", temperature.Chamber)
+	}
+}
+
+func TestTemperatureSingleTool(t *testing.T) {
+	var printer Printer
+	readFixture(t, "printer.json", &printer)
+
+	if indexes := printer.Temperature.ToolIndexes(); !slices.Equal(indexes, []int{0}) {
+		t.Fatalf("ToolIndexes() = %v, expected [0]", indexes)
+	}
+
+	if printer.Temperature.Tool0 != printer.Temperature.Tools[0] {
+		t.Errorf("Tool0 = %+v, expected %+v", printer.Temperature.Tool0, printer.Temperature.Tools[0])
+	}
+}
+
+func TestStatusXLActiveTool(t *testing.T) {
+	var status Status
+	readFixture(t, "xl/v1/status.json", &status)
+
+	if status.Printer.ActiveTool == nil || *status.Printer.ActiveTool != 0 {
+		t.Errorf("active tool = %v, expected 0", status.Printer.ActiveTool)
+	}
+}
+
+func TestIsPrintFile(t *testing.T) {
+	tests := []struct {
+		file  File
+		print bool
+	}{
+		{File{Name: "BENCHY~1.BGC", Display: "benchy.bgcode"}, true},
+		{File{Name: "CLIP.GCO"}, true},
+		{File{Name: "box.gcode"}, true},
+		{File{Name: "BOX.GCODE"}, true},
+		{File{Name: "box", Type: "machinecode"}, true},
+		{File{Name: "README.TXT", Display: "readme.txt"}, false},
+		{File{Name: "FIRMWA~1.BBF", Display: "MK4_firmware_6.2.0.bbf"}, false},
+		{File{Name: "PARTS.GCODE", Type: "folder"}, false},
+	}
+
+	for _, test := range tests {
+		if isPrint := test.file.IsPrintFile(); isPrint != test.print {
+			t.Errorf("IsPrintFile(%+v) = %t, expected %t", test.file, isPrint, test.print)
+		}
+	}
+}
+
+func TestCountFiles(t *testing.T) {
+	var files Files
+	readFixture(t, "storage/files.json", &files)
+
+	expected := []struct {
+		origin string
+		count  float64
+		size   float64
+	}{
+		{"usb", 2, 1500000}, // firmware and readme are not counted, nested folder is
+		{"local", 1, 500000},
+	}
+
+	for i, test := range expected {
+		count, size := files.Files[i].CountFiles()
+		if files.Files[i].Origin != test.origin || count != test.count || size != test.size {
+			t.Errorf("CountFiles(%s) = %v, %v, expected %v, %v", files.Files[i].Origin, count, size, test.count, test.size)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package prusalink
+
+import "time"
+
+// Summary is a struct that contains normalized state of the printer, it's the same for all printer families
+type Summary struct {
+	Name        string             `json:"name"`
+	Address     string             `json:"address"`
+	Model       string             `json:"model"`
+	Location    string             `json:"location,omitempty"`
+	Online      bool               `json:"online"`
+	State       string             `json:"state"`
+	LastSeen    time.Time          `json:"last_seen"`
+	LastError   *SummaryError      `json:"last_error,omitempty"`
+	Firmware    string             `json:"firmware,omitempty"`
+	Serial      string             `json:"serial,omitempty"`
+	Mmu         bool               `json:"mmu"`
+	Material    string             `json:"material,omitempty"`
+	Temperature SummaryTemperature `json:"temperature"`
+	Fans        SummaryFans        `json:"fans"`
+	Axes        SummaryAxes        `json:"axes"`
+	Job         *SummaryJob        `json:"job,omitempty"` // nil when printer has no job
+}
+
+// SummaryError is a struct that contains the last error that made the printer down
+type SummaryError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// SummaryTemperature is a struct that contains temperatures of the printer in degrees Celsius
+type SummaryTemperature struct {
+	Nozzle        float64  `json:"nozzle"`
+	NozzleTarget  float64  `json:"nozzle_target"`
+	Bed           float64  `json:"bed"`
+	BedTarget     float64  `json:"bed_target"`
+	Chamber       *float64 `json:"chamber,omitempty"` // only printers with chamber sensor
+	ChamberTarget *float64 `json:"chamber_target,omitempty"`
+}
+
+// SummaryFans is a struct that contains fan speeds of the printer in RPM
+type SummaryFans struct {
+	Hotend  float64  `json:"hotend"`
+	Print   float64  `json:"print"`
+	Chamber *float64 `json:"chamber,omitempty"`
+}
+
+// SummaryAxes is a struct that contains position of the printer axes in millimeters
+type SummaryAxes struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// SummaryJob is a struct that contains data about current job of the printer
+type SummaryJob struct {
+	ID                 float64                     `json:"id"`
+	File               string                      `json:"file"`
+	Path               string                      `json:"path"`
+	State              string                      `json:"state"`
+	Progress           float64                     `json:"progress"`       // in percent
+	TimePrinting       float64                     `json:"time_printing"`  // in seconds
+	TimeRemaining      float64                     `json:"time_remaining"` // in seconds
+	ETA                *time.Time                  `json:"eta,omitempty"`  // estimated end of the job, nil when printer does not report remaining time
+	EstimatedPrintTime float64                     `json:"estimated_print_time"`
+	FilamentType       string                      `json:"filament_type,omitempty"`
+	Filament           map[string]FilamentCounters `json:"filament,omitempty"` // estimated usage of the whole job by material
+}
+
+// GetSummary returns normalized state of the printer from the snapshot, /api/v1 endpoints are preferred over legacy ones
+func GetSummary(snapshot Snapshot) Summary {
+	printer := snapshot.Config
+	status := snapshot.Status.Printer
+	telemetry := snapshot.Printer.Telemetry
+
+	summary := Summary{
+		Name:     printer.Name,
+		Address:  printer.Address,
+		Model:    printer.Type,
+		Location: printer.Location,
+		Online:   snapshot.Up,
+		State:    "OFFLINE",
+		LastSeen: snapshot.LastSeen,
+	}
+
+	if snapshot.LastError != "" {
+		summary.LastError = &SummaryError{Message: snapshot.LastError, Time: snapshot.LastErrorTime}
+	}
+
+	if !snapshot.Up {
+		return summary
+	}
+
+	summary.State = GetPrinterState(snapshot)
+	summary.Firmware = snapshot.Version.Firmware
+	summary.Serial = snapshot.Info.Serial
+	summary.Mmu = snapshot.Info.Mmu
+	summary.Material = telemetry.Material
+
+	summary.Temperature = SummaryTemperature{
+		Nozzle:       firstNonZero(snapshot.Printer.Temperature.Tool0.Actual, status.TempNozzle, telemetry.TempNozzle),
+		NozzleTarget: firstNonZero(snapshot.Printer.Temperature.Tool0.Target, status.TargetNozzle),
+		Bed:          firstNonZero(snapshot.Printer.Temperature.Bed.Actual, status.TempBed, telemetry.TempBed),
+		BedTarget:    firstNonZero(snapshot.Printer.Temperature.Bed.Target, status.TargetBed),
+	}
+
+	if chamber := getChamber(snapshot.Printer, snapshot.Status); chamber != nil {
+		summary.Temperature.Chamber = &chamber.Actual
+		summary.Temperature.ChamberTarget = &chamber.Target
+	}
+
+	summary.Fans = SummaryFans{
+		Hotend:  status.FanHotend,
+		Print:   status.FanPrint,
+		Chamber: status.FanChamber,
+	}
+
+	summary.Axes = SummaryAxes{
+		X: firstNonZero(status.AxisX, telemetry.AxisX),
+		Y: firstNonZero(status.AxisY, telemetry.AxisY),
+		Z: firstNonZero(status.AxisZ, telemetry.AxisZ, telemetry.ZHeight),
+	}
+
+	jobID, state, progress, timePrinting := getJobState(snapshot)
+	file := firstNonEmpty(snapshot.JobV1.File.DisplayName, snapshot.Job.Job.File.Display, snapshot.Job.Job.File.Name)
+
+	if jobID == 0 && file == "" {
+		return summary
+	}
+
+	summary.Job = &SummaryJob{
+		ID:                 jobID,
+		File:               file,
+		Path:               firstNonEmpty(snapshot.JobV1.File.Path, snapshot.Job.Job.File.Path),
+		State:              state,
+		Progress:           progress,
+		TimePrinting:       timePrinting,
+		TimeRemaining:      firstNonZero(snapshot.JobV1.TimeRemaining, snapshot.Status.Job.TimeRemaining, snapshot.Job.Progress.PrintTimeLeft),
+		EstimatedPrintTime: firstNonZero(snapshot.JobV1.File.Meta.EstimatedPrintTime, snapshot.Job.Job.EstimatedPrintTime),
+		FilamentType:       snapshot.JobV1.File.Meta.FilamentType,
+	}
+
+	if filament := getJobFilament(snapshot); len(filament) > 0 {
+		summary.Job.Filament = filament
+	}
+
+	if summary.Job.TimeRemaining > 0 {
+		eta := snapshot.Time.Add(time.Duration(summary.Job.TimeRemaining) * time.Second).Truncate(time.Second)
+		summary.Job.ETA = &eta
+	}
+
+	if jobID == 0 {
+		// printer without /api/v1 endpoints, legacy job endpoint reports completion as ratio
+		summary.Job.State = snapshot.Job.State
+		summary.Job.Progress = snapshot.Job.Progress.Completion * 100
+		summary.Job.TimePrinting = snapshot.Job.Progress.PrintTime
+	}
+
+	return summary
+}
+
+// firstNonZero returns the first value that is not zero
+func firstNonZero(values ...float64) float64 {
+	for _, value := range values {
+		if value != 0 {
+			return value
+		}
+	}
+
+	return 0
+}
+
+// firstNonEmpty returns the first value that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
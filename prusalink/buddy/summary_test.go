@@ -0,0 +1,158 @@
+package prusalink
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+func TestGetSummary(t *testing.T) {
+	summary := GetSummary(buddySnapshot(t, "MK4", "v1/status.json"))
+
+	if summary.Name != "printer" || summary.Address != "192.168.1.20" || summary.Model != "MK4" || !summary.Online || summary.State != "IDLE" {
+		t.Errorf("summary = %+v, expected online idle MK4", summary)
+	}
+
+	if summary.Serial != "10859-3472414637128135" || summary.Material != "FLEX" || summary.Mmu {
+		t.Errorf("serial = %q, material = %q, mmu = %t, expected values of the fixtures", summary.Serial, summary.Material, summary.Mmu)
+	}
+
+	if temperature := summary.Temperature; temperature.Nozzle != 22 || temperature.Bed != 20.1 || temperature.Chamber != nil {
+		t.Errorf("temperature = %+v, expected nozzle 22, bed 20.1 and no chamber", temperature)
+	}
+
+	if axes := summary.Axes; axes != (SummaryAxes{X: 241, Y: 170, Z: 89.3}) {
+		t.Errorf("axes = %+v, expected position from status", axes)
+	}
+
+	if summary.Job != nil {
+		t.Errorf("job = %+v, expected no job of idle printer", summary.Job)
+	}
+}
+
+func TestGetSummaryXL(t *testing.T) {
+	snapshot := xlSnapshot(t)
+	snapshot.Time = time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+
+	summary := GetSummary(snapshot)
+
+	if summary.State != "PRINTING" || summary.Material != "PLA" {
+		t.Errorf("state = %q, material = %q, expected printing PLA", summary.State, summary.Material)
+	}
+
+	expectedTemperature := SummaryTemperature{Nozzle: 215, NozzleTarget: 215, Bed: 60.1, BedTarget: 60}
+	if summary.Temperature != expectedTemperature {
+		t.Errorf("temperature = %+v, expected %+v", summary.Temperature, expectedTemperature)
+	}
+
+	if summary.Fans.Hotend != 5520 || summary.Fans.Print != 3900 {
+		t.Errorf("fans = %+v, expected hotend 5520 and print 3900", summary.Fans)
+	}
+
+	job := summary.Job
+	if job == nil {
+		t.Fatal("job is missing")
+	}
+
+	if job.ID != 42 || job.State != "PRINTING" || job.Progress != 37 || job.TimePrinting != 5012 || job.TimeRemaining != 8460 {
+		t.Errorf("job = %+v, expected job 42 from status", job)
+	}
+
+	eta := time.Date(2026, 3, 1, 14, 21, 0, 0, time.UTC)
+	if job.ETA == nil || !job.ETA.Equal(eta) {
+		t.Errorf("ETA = %v, expected %v", job.ETA, eta)
+	}
+}
+
+func TestGetSummaryJob(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	v1 := buddySnapshot(t, "MK4", "v1/status.json")
+	v1.Time = now
+	v1.JobV1.ID = 7
+	v1.JobV1.State = "PAUSED"
+	v1.JobV1.Progress = 42.5
+	v1.JobV1.TimePrinting = 600
+	v1.JobV1.TimeRemaining = 90
+	v1.JobV1.File.DisplayName = "benchy.bgcode"
+	v1.JobV1.File.Path = "/usb"
+
+	// printer without /api/v1 endpoints
+	legacy := Snapshot{Config: config.Printers{Address: "192.168.1.30", Type: "MINI"}, Up: true, Time: now}
+	readFixture(t, "printer.json", &legacy.Printer)
+	readFixture(t, "job.json", &legacy.Job)
+	legacy.Job.Progress.Completion = 0.425
+
+	withoutRemaining := v1
+	withoutRemaining.JobV1.TimeRemaining = 0
+
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		expected SummaryJob
+		eta      time.Time
+	}{
+		{
+			name:     "v1 progress in percent",
+			snapshot: v1,
+			expected: SummaryJob{ID: 7, File: "benchy.bgcode", Path: "/usb", State: "PAUSED", Progress: 42.5, TimePrinting: 600, TimeRemaining: 90},
+			eta:      now.Add(90 * time.Second),
+		},
+		{
+			name:     "legacy completion ratio",
+			snapshot: legacy,
+			expected: SummaryJob{File: "multiple_grots_0.4n_0.15mm_PLA,PLA,PLA,PLA_XLIS_5h36m.bgcode", Path: "/usb/MULTIP~1.BGC", State: "Printing", Progress: 42.5, TimePrinting: 254, TimeRemaining: 20100, EstimatedPrintTime: 20354},
+			eta:      now.Add(20100 * time.Second),
+		},
+		{
+			name:     "unknown remaining time",
+			snapshot: withoutRemaining,
+			expected: SummaryJob{ID: 7, File: "benchy.bgcode", Path: "/usb", State: "PAUSED", Progress: 42.5, TimePrinting: 600},
+		},
+	}
+
+	for _, test := range tests {
+		job := GetSummary(test.snapshot).Job
+		if job == nil {
+			t.Errorf("%s: job is missing", test.name)
+			continue
+		}
+
+		eta := job.ETA
+		job.ETA = nil
+
+		if !reflect.DeepEqual(*job, test.expected) {
+			t.Errorf("%s: job = %+v, expected %+v", test.name, *job, test.expected)
+		}
+
+		if test.eta.IsZero() && eta != nil || !test.eta.IsZero() && (eta == nil || !eta.Equal(test.eta)) {
+			t.Errorf("%s: ETA = %v, expected %v", test.name, eta, test.eta)
+		}
+	}
+}
+
+func TestGetSummaryOffline(t *testing.T) {
+	lastSeen := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	snapshot := xlSnapshot(t) // data of the last poll must not be reported
+	snapshot.Up = false
+	snapshot.LastSeen = lastSeen
+	snapshot.LastError = "job endpoint - connection refused"
+	snapshot.LastErrorTime = lastSeen.Add(time.Minute)
+
+	summary := GetSummary(snapshot)
+
+	if summary.Online || summary.State != "OFFLINE" || !summary.LastSeen.Equal(lastSeen) {
+		t.Errorf("summary = %+v, expected offline printer last seen at %v", summary, lastSeen)
+	}
+
+	if summary.LastError == nil || summary.LastError.Message != snapshot.LastError || !summary.LastError.Time.Equal(snapshot.LastErrorTime) {
+		t.Errorf("last error = %+v, expected %q", summary.LastError, snapshot.LastError)
+	}
+
+	if summary.Job != nil || summary.Temperature != (SummaryTemperature{}) {
+		t.Errorf("job = %+v, temperature = %+v, expected nothing of offline printer", summary.Job, summary.Temperature)
+	}
+}
@@ -0,0 +1,164 @@
+package prusalink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// UploadOptions is a struct that contains options of file upload
+type UploadOptions struct {
+	PrintAfterUpload bool
+	Overwrite        bool
+	Progress         func(sent int64) // called after every chunk sent to the printer, it may be nil
+}
+
+// progressReader is io.Reader reporting number of bytes read so far
+type progressReader struct {
+	reader   io.Reader
+	sent     atomic.Int64 // read by GetBody while the transport may still be sending
+	progress func(sent int64)
+}
+
+// Read reads from the underlying reader and reports progress
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	sent := reader.sent.Add(int64(n))
+
+	if n > 0 && reader.progress != nil {
+		reader.progress(sent)
+	}
+
+	return n, err
+}
+
+// UploadFile is used to upload file to the printer's storage by PUT /api/v1/files/<storage>/<path>
+// body is streamed to the printer, so size has to be known in advance - it's checked against free space of the storage first
+func UploadFile(printer config.Printers, storage string, path string, body io.Reader, size int64, options UploadOptions) error {
+	version, err := GetVersion(printer)
+
+	if err != nil {
+		return err
+	}
+
+	if !version.Capabilities.UploadByPut {
+		return errors.New("printer does not support upload by PUT")
+	}
+
+	// storage request authenticates digest client, so the upload is sent only once and it's not buffered in memory
+	client := newPrinterClient(printer, 0)
+
+	if err := checkFreeSpace(client, printer, storage, size); err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Content-Type":       "application/octet-stream",
+		"Print-After-Upload": structuredBool(options.PrintAfterUpload),
+		"Overwrite":          structuredBool(options.Overwrite),
+	}
+
+	escaped := []string{}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+
+	stream := &progressReader{reader: body, progress: options.Progress}
+	req, err := newPrinterRequest("PUT", "/api/v1/files/"+url.PathEscape(strings.Trim(storage, "/"))+"/"+strings.Join(escaped, "/"), io.NopCloser(stream), headers, printer)
+
+	if err != nil {
+		return err
+	}
+
+	// body can't be rewound, repeated request (digest challenge after 401, redirect or retry of the transport) fails instead of sending partial file
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		if stream.sent.Load() > 0 {
+			return nil, errors.New("upload was rejected by the printer after the file was sent and it can't be repeated")
+		}
+		return io.NopCloser(stream), nil
+	}
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("upload returned status %d %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// checkFreeSpace returns error when the storage is not available, it's read only or it does not have enough free space
+func checkFreeSpace(client *http.Client, printer config.Printers, storage string, size int64) error {
+	req, err := newPrinterRequest("GET", "/api/v1/storage", nil, nil, printer)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), printerTimeout(printer))
+	defer cancel()
+
+	res, err := client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("/api/v1/storage returned status %d", res.StatusCode)
+	}
+
+	var storages StorageV1
+
+	if err := json.NewDecoder(res.Body).Decode(&storages); err != nil {
+		return err
+	}
+
+	for _, s := range storages.StorageList {
+		if strings.Trim(s.Path, "/") != strings.Trim(storage, "/") {
+			continue
+		}
+
+		if !s.Available {
+			return fmt.Errorf("storage %s is not available", storage)
+		}
+
+		if s.ReadOnly {
+			return fmt.Errorf("storage %s is read only", storage)
+		}
+
+		if s.FreeSpace != nil && *s.FreeSpace < float64(size) {
+			return fmt.Errorf("storage %s has %.0f bytes free, %d bytes required", storage, *s.FreeSpace, size)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("storage %s not found", storage)
+}
+
+// structuredBool returns boolean in HTTP structured field format used by PrusaLink headers
+func structuredBool(value bool) string {
+	if value {
+		return "?1"
+	}
+	return "?0"
+}
@@ -0,0 +1,258 @@
+package prusalink
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// upload is an upload received by the test printer
+type upload struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// uploadPrinter is a test printer accepting uploads, empty fields are answered with defaults
+type uploadPrinter struct {
+	version       string // /api/version, upload by PUT is supported by default
+	storage       string // /api/v1/storage, USB with 30 GB free space by default
+	storageStatus int
+	uploadStatus  int
+	digest        bool // requests have to be authenticated by digest, upload is challenged again after it's received
+
+	mutex   sync.Mutex
+	uploads []upload
+}
+
+// start starts the printer and returns its configuration
+func (printer *uploadPrinter) start(t *testing.T) config.Printers {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		challenge := func(stale bool) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="Printer API", nonce="0123456789abcdef", stale=`+map[bool]string{true: "true", false: "false"}[stale])
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+
+		if printer.digest && !strings.HasPrefix(r.Header.Get("Authorization"), "Digest ") {
+			challenge(false)
+			return
+		}
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/version":
+			w.Write([]byte(orDefault(printer.version, `{"api":"2.0.0","capabilities":{"upload-by-put":true}}`)))
+		case r.Method == "GET" && r.URL.Path == "/api/v1/storage":
+			w.WriteHeader(orDefault(printer.storageStatus, http.StatusOK))
+			w.Write([]byte(orDefault(printer.storage, `{"storage_list":[{"path":"/usb/","read_only":false,"available":true,"free_space":30000000000}]}`)))
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/api/v1/files/"):
+			body, _ := io.ReadAll(r.Body)
+
+			printer.mutex.Lock()
+			printer.uploads = append(printer.uploads, upload{path: r.URL.EscapedPath(), header: r.Header.Clone(), body: body})
+			printer.mutex.Unlock()
+
+			if printer.digest {
+				challenge(true) // nonce expired during upload
+				return
+			}
+
+			if printer.uploadStatus == http.StatusTemporaryRedirect {
+				w.Header().Set("Location", "/api/v1/files/usb/moved.gcode")
+			}
+			w.WriteHeader(orDefault(printer.uploadStatus, http.StatusCreated))
+			if printer.uploadStatus == http.StatusConflict {
+				w.Write([]byte("file already exists"))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	settings := config.Printers{Address: strings.TrimPrefix(server.URL, "http://"), ScrapeTimeout: model.Duration(time.Second), Apikey: "key"}
+	if printer.digest {
+		settings.Apikey = ""
+		settings.Username, settings.Password = "maker", "secret"
+	}
+
+	return settings
+}
+
+// orDefault returns value or default when value is zero
+func orDefault[T comparable](value T, defaultValue T) T {
+	var zero T
+	if value == zero {
+		return defaultValue
+	}
+	return value
+}
+
+func TestUploadFile(t *testing.T) {
+	printer := &uploadPrinter{}
+	settings := printer.start(t)
+
+	content := bytes.Repeat([]byte("G1 X10 Y10\n"), 20000)
+	progress := []int64{}
+
+	err := UploadFile(settings, "/usb/", "/models/benchy 2.gcode", bytes.NewReader(content), int64(len(content)), UploadOptions{
+		PrintAfterUpload: true,
+		Progress:         func(sent int64) { progress = append(progress, sent) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(printer.uploads) != 1 {
+		t.Fatalf("uploads = %d, expected 1", len(printer.uploads))
+	}
+
+	received := printer.uploads[0]
+
+	if received.path != "/api/v1/files/usb/models/benchy%202.gcode" {
+		t.Errorf("path = %q, expected escaped file path", received.path)
+	}
+
+	if !bytes.Equal(received.body, content) {
+		t.Errorf("received %d bytes, expected %d", len(received.body), len(content))
+	}
+
+	for header, value := range map[string]string{
+		"Print-After-Upload": "?1",
+		"Overwrite":          "?0",
+		"Content-Type":       "application/octet-stream",
+		"Content-Length":     "220000",
+		"X-Api-Key":          "key",
+	} {
+		if received.header.Get(header) != value {
+			t.Errorf("%s = %q, expected %q", header, received.header.Get(header), value)
+		}
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(content)) {
+		t.Fatalf("progress = %v, expected to end with %d", progress, len(content))
+	}
+
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Errorf("progress = %v, expected increasing values", progress)
+			break
+		}
+	}
+
+	// overwrite without print
+	if err := UploadFile(settings, "usb", "box.gcode", strings.NewReader("G28"), 3, UploadOptions{Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if header := printer.uploads[1].header; header.Get("Print-After-Upload") != "?0" || header.Get("Overwrite") != "?1" {
+		t.Errorf("Print-After-Upload = %q, Overwrite = %q, expected ?0 and ?1", header.Get("Print-After-Upload"), header.Get("Overwrite"))
+	}
+}
+
+func TestUploadFileRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		printer *uploadPrinter
+		storage string
+		err     string
+	}{
+		{
+			name:    "upload by PUT is not supported",
+			printer: &uploadPrinter{version: `{"api":"2.0.0","capabilities":{"upload-by-put":false}}`},
+			err:     "printer does not support upload by PUT",
+		},
+		{
+			name:    "insufficient space",
+			printer: &uploadPrinter{storage: `{"storage_list":[{"path":"/usb/","available":true,"free_space":100}]}`},
+			err:     "storage usb has 100 bytes free, 1000 bytes required",
+		},
+		{
+			name:    "read only storage",
+			printer: &uploadPrinter{storage: `{"storage_list":[{"path":"/usb/","available":true,"read_only":true}]}`},
+			err:     "storage usb is read only",
+		},
+		{
+			name:    "unavailable storage",
+			printer: &uploadPrinter{storage: `{"storage_list":[{"path":"/usb/","available":false}]}`},
+			err:     "storage usb is not available",
+		},
+		{
+			name:    "unknown storage",
+			printer: &uploadPrinter{},
+			storage: "local",
+			err:     "storage local not found",
+		},
+		{
+			name:    "storage endpoint fails",
+			printer: &uploadPrinter{storageStatus: http.StatusServiceUnavailable, storage: `{"storage_list":[{"path":"/usb/","available":true}]}`},
+			err:     "/api/v1/storage returned status 503",
+		},
+	}
+
+	for _, test := range tests {
+		settings := test.printer.start(t)
+
+		err := UploadFile(settings, orDefault(test.storage, "usb"), "box.gcode", bytes.NewReader(make([]byte, 1000)), 1000, UploadOptions{})
+
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: UploadFile() = %v, expected %q", test.name, err, test.err)
+		}
+
+		if len(test.printer.uploads) != 0 {
+			t.Errorf("%s: file was uploaded", test.name)
+		}
+	}
+}
+
+func TestUploadFileFailed(t *testing.T) {
+	printer := &uploadPrinter{uploadStatus: http.StatusConflict}
+	settings := printer.start(t)
+
+	err := UploadFile(settings, "usb", "box.gcode", strings.NewReader("G28"), 3, UploadOptions{})
+
+	if err == nil || err.Error() != "upload returned status 409 file already exists" {
+		t.Errorf("UploadFile() = %v, expected status and message of the printer", err)
+	}
+}
+
+func TestUploadFileIsNotRepeated(t *testing.T) {
+	printer := &uploadPrinter{digest: true}
+	settings := printer.start(t)
+
+	content := bytes.Repeat([]byte("G1 X10 Y10\n"), 1000)
+	err := UploadFile(settings, "usb", "box.gcode", bytes.NewReader(content), int64(len(content)), UploadOptions{})
+
+	if err == nil || !strings.Contains(err.Error(), "can't be repeated") {
+		t.Errorf("UploadFile() = %v, expected error of repeated upload", err)
+	}
+
+	// digest client was authenticated by storage request, the file is sent once and whole
+	if len(printer.uploads) != 1 || !bytes.Equal(printer.uploads[0].body, content) || !strings.HasPrefix(printer.uploads[0].header.Get("Authorization"), "Digest ") {
+		t.Errorf("uploads = %d, expected single authenticated upload of the whole file", len(printer.uploads))
+	}
+}
+
+func TestUploadFileIsNotRedirected(t *testing.T) {
+	printer := &uploadPrinter{uploadStatus: http.StatusTemporaryRedirect}
+	settings := printer.start(t)
+
+	err := UploadFile(settings, "usb", "box.gcode", strings.NewReader("G28"), 3, UploadOptions{})
+
+	if err == nil || !strings.Contains(err.Error(), "can't be repeated") {
+		t.Errorf("UploadFile() = %v, expected error of repeated upload", err)
+	}
+
+	if len(printer.uploads) != 1 {
+		t.Errorf("uploads = %d, expected the file is not sent again with empty body", len(printer.uploads))
+	}
+}
@@ -0,0 +1,263 @@
+package prusalink
+
+import (
+	"sync"
+	"time"
+)
+
+// Utilization states of the printer
+const (
+	StateIdle      = "idle"
+	StatePrinting  = "printing"
+	StatePaused    = "paused"
+	StateError     = "error"
+	StateAttention = "attention"
+	StateOffline   = "offline"
+)
+
+// UtilizationStates contains all utilization states in the order they are exported
+var UtilizationStates = []string{StateIdle, StatePrinting, StatePaused, StateError, StateAttention, StateOffline}
+
+// utilizationBucket is a struct that contains time spent in states and finished jobs during one minute
+type utilizationBucket struct {
+	Start     time.Time          `json:"start"`
+	Seconds   map[string]float64 `json:"seconds"`
+	Completed float64            `json:"completed"`
+	Finished  float64            `json:"finished"` // completed, cancelled and failed jobs
+}
+
+// printerUtilization is a struct that contains utilization data of single printer, it's persisted in the state file with tracked job
+type printerUtilization struct {
+	Seconds map[string]float64   `json:"seconds"` // cumulative time spent in states
+	Buckets []*utilizationBucket `json:"buckets"`
+}
+
+// UtilizationStats is a struct that contains utilization figures of the printer over one window
+type UtilizationStats struct {
+	Window       time.Duration
+	Observed     float64 // seconds observed in the window
+	Utilization  float64 // ratio of time spent printing
+	Availability float64 // ratio of time the printer was online and without error
+	SuccessRate  float64 // ratio of completed jobs to all finished jobs
+	Finished     float64 // number of finished jobs in the window, success rate is meaningless without them
+}
+
+// UtilizationTracker accumulates time spent per state and keeps per minute history for rolling windows
+type UtilizationTracker struct {
+	windows  []time.Duration
+	maxGap   time.Duration
+	printers map[string]*printerUtilization
+	mutex    sync.Mutex
+}
+
+// NewUtilizationTracker returns a new UtilizationTracker, gaps between polls longer than maxGap are not counted
+func NewUtilizationTracker(windows []time.Duration, maxGap time.Duration) *UtilizationTracker {
+	return &UtilizationTracker{
+		windows:  windows,
+		maxGap:   maxGap,
+		printers: map[string]*printerUtilization{},
+	}
+}
+
+// Update adds time between previous and current poll to the state of the previous poll, it's called by the poller after every poll
+func (tracker *UtilizationTracker) Update(previous Snapshot, snapshot Snapshot) {
+	if previous.Time.IsZero() {
+		return
+	}
+
+	elapsed := snapshot.Time.Sub(previous.Time)
+	if elapsed <= 0 || elapsed > tracker.maxGap {
+		return
+	}
+
+	state := getUtilizationState(previous)
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	printer := tracker.printer(snapshot.Config.Address)
+	printer.Seconds[state] += elapsed.Seconds()
+	tracker.bucket(printer, snapshot.Time).Seconds[state] += elapsed.Seconds()
+}
+
+// HandleJobEvent counts finished jobs for success rate, it's meant to be subscribed to the job tracker
+func (tracker *UtilizationTracker) HandleJobEvent(event JobEvent) {
+	if event.Type != JobCompleted && event.Type != JobCancelled && event.Type != JobFailed {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	bucket := tracker.bucket(tracker.printer(event.Snapshot.Config.Address), event.Snapshot.Time)
+	bucket.Finished++
+
+	if event.Type == JobCompleted {
+		bucket.Completed++
+	}
+}
+
+// StateSeconds returns cumulative time spent in states by the printer with given address
+func (tracker *UtilizationTracker) StateSeconds(address string) map[string]float64 {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	seconds := map[string]float64{}
+
+	if printer, ok := tracker.printers[address]; ok {
+		for state, value := range printer.Seconds {
+			seconds[state] = value
+		}
+	}
+
+	return seconds
+}
+
+// Stats returns utilization figures of the printer with given address for all configured windows
+func (tracker *UtilizationTracker) Stats(address string, now time.Time) []UtilizationStats {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	printer, ok := tracker.printers[address]
+	if !ok {
+		return nil
+	}
+
+	stats := []UtilizationStats{}
+
+	for _, window := range tracker.windows {
+		seconds := map[string]float64{}
+		completed := 0.0
+		stat := UtilizationStats{Window: window}
+		from := now.Add(-window)
+
+		for _, bucket := range printer.Buckets {
+			if bucket.Start.Before(from) {
+				continue
+			}
+
+			for state, value := range bucket.Seconds {
+				seconds[state] += value
+				stat.Observed += value
+			}
+			completed += bucket.Completed
+			stat.Finished += bucket.Finished
+		}
+
+		if stat.Observed > 0 {
+			stat.Utilization = seconds[StatePrinting] / stat.Observed
+			stat.Availability = (stat.Observed - seconds[StateOffline] - seconds[StateError]) / stat.Observed
+		}
+
+		if stat.Finished > 0 {
+			stat.SuccessRate = completed / stat.Finished
+		}
+
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+// printer returns utilization data of the printer, it's created when missing
+func (tracker *UtilizationTracker) printer(address string) *printerUtilization {
+	printer, ok := tracker.printers[address]
+
+	if !ok {
+		printer = &printerUtilization{Seconds: map[string]float64{}}
+		tracker.printers[address] = printer
+	}
+
+	return printer
+}
+
+// bucket returns bucket of the minute, buckets older than the longest window are dropped
+func (tracker *UtilizationTracker) bucket(printer *printerUtilization, t time.Time) *utilizationBucket {
+	start := t.Truncate(time.Minute)
+
+	if count := len(printer.Buckets); count > 0 && !printer.Buckets[count-1].Start.Before(start) {
+		return printer.Buckets[count-1]
+	}
+
+	bucket := &utilizationBucket{Start: start, Seconds: map[string]float64{}}
+	printer.Buckets = append(printer.Buckets, bucket)
+
+	var longest time.Duration
+	for _, window := range tracker.windows {
+		longest = max(longest, window)
+	}
+
+	expired := 0
+	for expired < len(printer.Buckets) && printer.Buckets[expired].Start.Before(t.Add(-longest-time.Minute)) {
+		expired++
+	}
+	printer.Buckets = printer.Buckets[expired:]
+
+	return bucket
+}
+
+// export returns copy of utilization data of all printers by address
+func (tracker *UtilizationTracker) export() map[string]*printerUtilization {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	printers := map[string]*printerUtilization{}
+
+	for address, printer := range tracker.printers {
+		exported := &printerUtilization{Seconds: map[string]float64{}}
+		for state, value := range printer.Seconds {
+			exported.Seconds[state] = value
+		}
+
+		for _, bucket := range printer.Buckets {
+			copied := *bucket
+			copied.Seconds = map[string]float64{}
+			for state, value := range bucket.Seconds {
+				copied.Seconds[state] = value
+			}
+			exported.Buckets = append(exported.Buckets, &copied)
+		}
+
+		printers[address] = exported
+	}
+
+	return printers
+}
+
+// restore replaces utilization data of the printer with data read from the state file
+func (tracker *UtilizationTracker) restore(address string, printer *printerUtilization) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if printer.Seconds == nil {
+		printer.Seconds = map[string]float64{}
+	}
+
+	for _, bucket := range printer.Buckets {
+		if bucket.Seconds == nil {
+			bucket.Seconds = map[string]float64{}
+		}
+	}
+
+	tracker.printers[address] = printer
+}
+
+// getUtilizationState returns utilization state of the snapshot based on printer state
+func getUtilizationState(snapshot Snapshot) string {
+	if !snapshot.Up {
+		return StateOffline
+	}
+
+	switch GetPrinterState(snapshot) {
+	case "PAUSED":
+		return StatePaused
+	case "PRINTING":
+		return StatePrinting
+	case "ERROR":
+		return StateError
+	case "ATTENTION":
+		return StateAttention
+	default:
+		return StateIdle
+	}
+}
@@ -0,0 +1,73 @@
+package prusalink
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUtilizationPersisted(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now()
+
+	jobs := NewJobTracker(stateFile)
+	utilization := NewUtilizationTracker([]time.Duration{time.Hour}, time.Minute)
+	jobs.PersistUtilization(utilization)
+
+	printing := jobSnapshot(9, "PRINTING", 50, 100)
+	printing.Time = now.Add(-40 * time.Second)
+	idle := jobSnapshot(0, "IDLE", 0, 0)
+	idle.Time = now.Add(-10 * time.Second)
+
+	utilization.Update(Snapshot{}, printing)
+	utilization.Update(printing, idle)
+	jobs.Update(Snapshot{}, idle)
+	jobs.Save()
+
+	restored := NewUtilizationTracker([]time.Duration{time.Hour}, time.Minute)
+	restoredJobs := NewJobTracker(stateFile)
+	restoredJobs.PersistUtilization(restored)
+
+	if seconds := restored.StateSeconds("192.168.1.10")[StatePrinting]; seconds != 30 {
+		t.Errorf("restored printing seconds = %v, expected 30", seconds)
+	}
+
+	stats := restored.Stats("192.168.1.10", now)
+	if len(stats) != 1 || stats[0].Observed != 30 || stats[0].Utilization != 1 {
+		t.Errorf("restored stats = %+v, expected 30 observed seconds with full utilization", stats)
+	}
+
+	if _, ok := restoredJobs.printers["192.168.1.10"]; !ok {
+		t.Error("tracked job was not restored")
+	}
+}
+
+func TestUtilizationOnlyEntryIsNotTrackedJob(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now()
+
+	jobs := NewJobTracker(stateFile)
+	utilization := NewUtilizationTracker([]time.Duration{time.Hour}, time.Minute)
+	jobs.PersistUtilization(utilization)
+
+	offline := jobSnapshot(0, "", 0, 0)
+	offline.Up = false
+	offline.Time = now.Add(-20 * time.Second)
+	later := offline
+	later.Time = now
+
+	utilization.Update(offline, later)
+	jobs.Save()
+
+	restored := NewUtilizationTracker([]time.Duration{time.Hour}, time.Minute)
+	restoredJobs := NewJobTracker(stateFile)
+	restoredJobs.PersistUtilization(restored)
+
+	if seconds := restored.StateSeconds("192.168.1.10")[StateOffline]; seconds != 20 {
+		t.Errorf("restored offline seconds = %v, expected 20", seconds)
+	}
+
+	if _, ok := restoredJobs.printers["192.168.1.10"]; ok {
+		t.Error("printer never seen online was restored as tracked job, its running job would not be adopted")
+	}
+}
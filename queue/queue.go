@@ -0,0 +1,242 @@
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// Job states
+const (
+	StateQueued      = "QUEUED"
+	StateDispatching = "DISPATCHING"
+	StateDispatched  = "DISPATCHED"
+	StateFailed      = "FAILED"
+	StateCancelled   = "CANCELLED"
+)
+
+// ErrNotFound is returned when job does not exist
+var ErrNotFound = errors.New("job not found")
+
+// Requirements is a struct that contains conditions the printer has to meet, zero values match every printer
+type Requirements struct {
+	Model          string  `json:"model,omitempty"`           // detected or configured printer type, e.g. MK4
+	NozzleDiameter float64 `json:"nozzle_diameter,omitempty"` // in millimeters
+	Material       string  `json:"material,omitempty"`        // material loaded in the printer, e.g. PLA
+}
+
+// Job is a struct that contains single queued gcode
+type Job struct {
+	ID             uint64       `json:"id"`
+	File           string       `json:"file"` // file name used on the printer
+	Size           int64        `json:"size"`
+	User           string       `json:"user"`
+	Requirements   Requirements `json:"requirements"`
+	State          string       `json:"state"`
+	Printer        string       `json:"printer,omitempty"` // name of the printer job was dispatched to
+	PrinterAddress string       `json:"printer_address,omitempty"`
+	Attempts       int          `json:"attempts"`
+	Error          string       `json:"error,omitempty"` // the last dispatch error
+	Queued         time.Time    `json:"queued"`
+	Dispatched     *time.Time   `json:"dispatched,omitempty"`
+	Finished       *time.Time   `json:"finished,omitempty"` // when job was dispatched, failed or cancelled
+}
+
+// finishedStates are states of jobs that left the queue
+var finishedStates = []string{StateDispatched, StateFailed, StateCancelled}
+
+// Store is an embedded database of queued jobs, jobs are ordered by id which is order of submission
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates queue database file
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// Add stores new job and returns it with assigned id
+func (store *Store) Add(job Job) (Job, error) {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		id, err := bucket.NextSequence()
+
+		if err != nil {
+			return err
+		}
+
+		job.ID = id
+
+		return putJob(bucket, job)
+	})
+
+	return job, err
+}
+
+// Get returns job by id
+func (store *Store) Get(id uint64) (Job, error) {
+	var job Job
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(jobsBucket).Get(jobKey(id))
+
+		if value == nil {
+			return ErrNotFound
+		}
+
+		return json.Unmarshal(value, &job)
+	})
+
+	return job, err
+}
+
+// Update changes job by the function in single transaction
+func (store *Store) Update(id uint64, update func(job *Job) error) (Job, error) {
+	var job Job
+
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		value := bucket.Get(jobKey(id))
+
+		if value == nil {
+			return ErrNotFound
+		}
+
+		if err := json.Unmarshal(value, &job); err != nil {
+			return err
+		}
+
+		if err := update(&job); err != nil {
+			return err
+		}
+
+		return putJob(bucket, job)
+	})
+
+	return job, err
+}
+
+// Delete removes job from the database
+func (store *Store) Delete(id uint64) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete(jobKey(id))
+	})
+}
+
+// List returns jobs in the states ordered by id, no states return every job
+func (store *Store) List(states ...string) ([]Job, error) {
+	jobs := []Job{}
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, value []byte) error {
+			var job Job
+
+			if err := json.Unmarshal(value, &job); err != nil {
+				return err
+			}
+
+			if len(states) > 0 && !contains(states, job.State) {
+				return nil
+			}
+
+			jobs = append(jobs, job)
+
+			return nil
+		})
+	})
+
+	return jobs, err
+}
+
+// Prune removes jobs that left the queue before the time, jobs stored without finish time are pruned by queue time
+func (store *Store) Prune(before time.Time) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		var expired [][]byte
+
+		err := bucket.ForEach(func(key, value []byte) error {
+			var job Job
+
+			if err := json.Unmarshal(value, &job); err != nil {
+				return err
+			}
+
+			finished := job.Queued
+			if job.Finished != nil {
+				finished = *job.Finished
+			}
+
+			if contains(finishedStates, job.State) && finished.Before(before) {
+				expired = append(expired, append([]byte{}, key...))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// putJob stores the job in the bucket
+func putJob(bucket *bolt.Bucket, job Job) error {
+	value, err := json.Marshal(job)
+
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(jobKey(job.ID), value)
+}
+
+// jobKey returns big endian encoded id, so keys are sorted by submission
+func jobKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// contains returns true if value is in values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,387 @@
+package queue
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// dispatchHold is how long printer is skipped after dispatch, so the job is not sent twice before the printer reports it's printing
+const dispatchHold = 2 * time.Minute
+
+// ErrNotQueued is returned when job can't be cancelled because it's not waiting in the queue
+var ErrNotQueued = errors.New("job is not queued")
+
+// Scheduler dispatches queued jobs to idle printers matching their requirements
+type Scheduler struct {
+	store         *Store
+	dir           string
+	storage       string
+	retries       int
+	retention     time.Duration
+	states        []string             // printer states jobs are dispatched in
+	held          map[string]time.Time // printers with running dispatch, zero time holds the printer until dispatch ends
+	mutex         sync.Mutex
+	waitSeconds   prometheus.Histogram
+	failures      prometheus.Counter
+	jobs          *prometheus.Desc
+	oldestSeconds *prometheus.Desc
+}
+
+// New returns a new Scheduler, jobs interrupted by restart during dispatch are failed, printer may have already started them
+// jobs that left the queue before retention are removed
+func New(config config.Config) (*Scheduler, error) {
+	settings := config.Exporter.Queue
+
+	if err := os.MkdirAll(settings.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	store, err := Open(settings.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	interrupted, err := store.List(StateDispatching)
+
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	now := time.Now()
+
+	for _, job := range interrupted {
+		if _, err := store.Update(job.ID, func(job *Job) error {
+			job.State = StateFailed
+			job.Error = "dispatch was interrupted by restart, check the printer before submitting the job again"
+			job.Finished = &now
+			return nil
+		}); err != nil {
+			store.Close()
+			return nil, err
+		}
+
+		log.Warn().Uint64("job", job.ID).Str("printer", job.PrinterAddress).Msg("Queued job interrupted during dispatch is failed")
+		os.Remove(filepath.Join(settings.Dir, strconv.FormatUint(job.ID, 10)+".gcode"))
+	}
+
+	scheduler := &Scheduler{
+		store:     store,
+		dir:       settings.Dir,
+		storage:   settings.Storage,
+		retries:   settings.Retries,
+		retention: time.Duration(settings.Retention),
+		states:    settings.DispatchStates,
+		held:      map[string]time.Time{},
+		waitSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "prusa_queue_wait_seconds",
+			Help:    "Time jobs spent in queue before they were dispatched to printer",
+			Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400},
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prusa_queue_dispatch_failures_total",
+			Help: "Number of failed attempts to dispatch queued job",
+		}),
+		jobs:          prometheus.NewDesc("prusa_queue_jobs", "Number of jobs in the queue by state", []string{"state"}, nil),
+		oldestSeconds: prometheus.NewDesc("prusa_queue_oldest_wait_seconds", "Time the oldest queued job is waiting", nil, nil),
+	}
+
+	scheduler.prune()
+
+	return scheduler, nil
+}
+
+// Close closes the queue database
+func (scheduler *Scheduler) Close() error {
+	return scheduler.store.Close()
+}
+
+// Submit stores the gcode and adds job to the end of the queue
+func (scheduler *Scheduler) Submit(job Job, body io.Reader) (Job, error) {
+	job.File = filepath.Base(filepath.Clean("/" + job.File))
+
+	if job.File == "/" || job.File == "." {
+		return job, errors.New("file name is required")
+	}
+
+	temp, err := os.CreateTemp(scheduler.dir, "upload-*")
+
+	if err != nil {
+		return job, err
+	}
+
+	defer os.Remove(temp.Name())
+
+	job.Size, err = io.Copy(temp, body)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return job, err
+	}
+
+	job.State = StateQueued
+	job.Queued = time.Now()
+
+	// job is visible to scheduler only together with its file
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	job, err = scheduler.store.Add(job)
+
+	if err != nil {
+		return job, err
+	}
+
+	if err := os.Rename(temp.Name(), scheduler.gcodePath(job.ID)); err != nil {
+		scheduler.store.Delete(job.ID)
+		return job, err
+	}
+
+	return job, nil
+}
+
+// Get returns job by id
+func (scheduler *Scheduler) Get(id uint64) (Job, error) {
+	return scheduler.store.Get(id)
+}
+
+// List returns jobs in the states ordered by submission, no states return every job
+func (scheduler *Scheduler) List(states ...string) ([]Job, error) {
+	return scheduler.store.List(states...)
+}
+
+// Cancel removes queued job from the queue, dispatched jobs are controlled on the printer
+func (scheduler *Scheduler) Cancel(id uint64) (Job, error) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	job, err := scheduler.store.Update(id, func(job *Job) error {
+		if job.State != StateQueued {
+			return ErrNotQueued
+		}
+		now := time.Now()
+		job.State = StateCancelled
+		job.Finished = &now
+		return nil
+	})
+
+	if err == nil {
+		os.Remove(scheduler.gcodePath(id))
+		scheduler.prune()
+	}
+
+	return job, err
+}
+
+// HandleSnapshot dispatches the first matching job when printer is in one of dispatch states, it's meant to be subscribed to the poller
+func (scheduler *Scheduler) HandleSnapshot(_ prusalink.Snapshot, snapshot prusalink.Snapshot) {
+	address := snapshot.Config.Address
+	idle := snapshot.Up && slices.Contains(scheduler.states, prusalink.GetPrinterState(snapshot))
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	if until, ok := scheduler.held[address]; ok {
+		if until.IsZero() || (idle && time.Now().Before(until)) {
+			return
+		}
+		delete(scheduler.held, address)
+	}
+
+	if !idle {
+		return
+	}
+
+	jobs, err := scheduler.store.List(StateQueued)
+
+	if err != nil {
+		log.Error().Msg("Error while reading print queue - " + err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		if !Matches(job.Requirements, snapshot) {
+			continue
+		}
+
+		job, err = scheduler.store.Update(job.ID, func(job *Job) error {
+			job.State = StateDispatching
+			job.Printer = snapshot.Config.Name
+			job.PrinterAddress = address
+			return nil
+		})
+
+		if err != nil {
+			log.Error().Msg("Error while updating queued job " + strconv.FormatUint(job.ID, 10) + " - " + err.Error())
+			return
+		}
+
+		scheduler.held[address] = time.Time{}
+		go scheduler.dispatch(job, snapshot.Config)
+
+		return
+	}
+}
+
+// dispatch uploads the job to the printer and starts it, failed jobs are queued again until retries are exhausted
+func (scheduler *Scheduler) dispatch(job Job, printer config.Printers) {
+	log.Info().Str("printer", printer.Address).Str("file", job.File).Uint64("job", job.ID).Msg("Dispatching queued job")
+
+	err := scheduler.upload(job, printer)
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	now := time.Now()
+
+	job, updateErr := scheduler.store.Update(job.ID, func(job *Job) error {
+		job.Attempts++
+
+		if err == nil {
+			job.State = StateDispatched
+			job.Dispatched = &now
+			job.Finished = &now
+			job.Error = ""
+			return nil
+		}
+
+		job.Error = err.Error()
+		job.State = StateQueued
+		job.Printer, job.PrinterAddress = "", ""
+
+		if job.Attempts > scheduler.retries {
+			job.State = StateFailed
+			job.Finished = &now
+		}
+
+		return nil
+	})
+
+	if updateErr != nil {
+		log.Error().Msg("Error while updating queued job " + strconv.FormatUint(job.ID, 10) + " - " + updateErr.Error())
+	}
+
+	if err != nil {
+		delete(scheduler.held, printer.Address)
+		scheduler.failures.Inc()
+		log.Error().Msg("Error while dispatching queued job " + strconv.FormatUint(job.ID, 10) + " to " + printer.Address + " - " + err.Error())
+	} else {
+		scheduler.held[printer.Address] = now.Add(dispatchHold)
+		scheduler.waitSeconds.Observe(now.Sub(job.Queued).Seconds())
+	}
+
+	if job.State == StateDispatched || job.State == StateFailed {
+		os.Remove(scheduler.gcodePath(job.ID))
+		scheduler.prune()
+	}
+}
+
+// prune removes jobs that left the queue before retention, zero retention keeps them
+func (scheduler *Scheduler) prune() {
+	if scheduler.retention == 0 {
+		return
+	}
+
+	if err := scheduler.store.Prune(time.Now().Add(-scheduler.retention)); err != nil {
+		log.Error().Msg("Error while removing old jobs from print queue - " + err.Error())
+	}
+}
+
+// upload sends stored gcode of the job to the printer and starts the print
+func (scheduler *Scheduler) upload(job Job, printer config.Printers) error {
+	file, err := os.Open(scheduler.gcodePath(job.ID))
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return prusalink.UploadFile(printer, scheduler.storage, job.File, file, job.Size, prusalink.UploadOptions{
+		PrintAfterUpload: true,
+		Overwrite:        true,
+	})
+}
+
+// gcodePath returns path of stored gcode of the job
+func (scheduler *Scheduler) gcodePath(id uint64) string {
+	return filepath.Join(scheduler.dir, strconv.FormatUint(id, 10)+".gcode")
+}
+
+// Matches returns true if the printer in the snapshot meets the requirements
+// model detected with low confidence never matches, job sliced for other printer could damage it
+func Matches(requirements Requirements, snapshot prusalink.Snapshot) bool {
+	if requirements.Model != "" {
+		if snapshot.ModelConfidence == prusalink.ConfidenceLow || snapshot.ModelConfidence == prusalink.ConfidenceNone {
+			return false
+		}
+
+		if prusalink.NormalizeModel(requirements.Model) != prusalink.NormalizeModel(snapshot.Config.Type) {
+			return false
+		}
+	}
+
+	if requirements.NozzleDiameter != 0 && math.Abs(requirements.NozzleDiameter-snapshot.Info.NozzleDiameter) > 0.001 {
+		return false
+	}
+
+	if requirements.Material != "" && !strings.EqualFold(requirements.Material, prusalink.GetSummary(snapshot).Material) {
+		return false
+	}
+
+	return true
+}
+
+// Describe implements prometheus.Collector
+func (scheduler *Scheduler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scheduler.jobs
+	ch <- scheduler.oldestSeconds
+	scheduler.waitSeconds.Describe(ch)
+	scheduler.failures.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (scheduler *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	jobs, err := scheduler.store.List()
+
+	if err != nil {
+		log.Error().Msg("Error while reading print queue - " + err.Error())
+		return
+	}
+
+	counts := map[string]float64{StateQueued: 0, StateDispatching: 0, StateDispatched: 0, StateFailed: 0, StateCancelled: 0}
+	oldest := 0.0
+
+	for _, job := range jobs {
+		counts[job.State]++
+
+		if job.State == StateQueued {
+			oldest = math.Max(oldest, time.Since(job.Queued).Seconds())
+		}
+	}
+
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(scheduler.jobs, prometheus.GaugeValue, count, state)
+	}
+
+	ch <- prometheus.MustNewConstMetric(scheduler.oldestSeconds, prometheus.GaugeValue, oldest)
+	scheduler.waitSeconds.Collect(ch)
+	scheduler.failures.Collect(ch)
+}
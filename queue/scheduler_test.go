@@ -0,0 +1,271 @@
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// newTestScheduler returns scheduler with database in temporary directory
+func newTestScheduler(t *testing.T, dir string) *Scheduler {
+	t.Helper()
+
+	var settings config.Config
+	settings.Exporter.Queue.Path = filepath.Join(dir, "queue.db")
+	settings.Exporter.Queue.Dir = filepath.Join(dir, "queue")
+	settings.Exporter.Queue.Storage = "usb"
+	settings.Exporter.Queue.Retries = 3
+	settings.Exporter.Queue.Retention = model.Duration(7 * 24 * time.Hour)
+	settings.Exporter.Queue.DispatchStates = []string{"READY"}
+
+	scheduler, err := New(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scheduler
+}
+
+// queueSnapshot returns snapshot of online printer in the state
+func queueSnapshot(printer config.Printers, state string, confidence string) prusalink.Snapshot {
+	snapshot := prusalink.Snapshot{Config: printer, Up: true, ModelConfidence: confidence, Time: time.Now()}
+	snapshot.Status.Printer.State = state
+	snapshot.Info.NozzleDiameter = 0.4
+
+	return snapshot
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		requirements Requirements
+		printerType  string
+		confidence   string
+		matches      bool
+	}{
+		{"no requirements", Requirements{}, "unknown", prusalink.ConfidenceNone, true},
+		{"same model", Requirements{Model: "MK4"}, "MK4", prusalink.ConfidenceConfigured, true},
+		{"input shaper suffix", Requirements{Model: "MK4IS"}, "MK4", prusalink.ConfidenceHigh, true},
+		{"MK3.9 spelling", Requirements{Model: "mk3.9"}, "MK39", prusalink.ConfidenceMedium, true},
+		{"different model", Requirements{Model: "MK4"}, "MK4S", prusalink.ConfidenceConfigured, false},
+		{"low confidence", Requirements{Model: "MK4"}, "MK4", prusalink.ConfidenceLow, false},
+		{"no detection", Requirements{Model: "MK4"}, "MK4", prusalink.ConfidenceNone, false},
+		{"low confidence without model requirement", Requirements{NozzleDiameter: 0.4}, "MK4", prusalink.ConfidenceLow, true},
+		{"different nozzle", Requirements{NozzleDiameter: 0.6}, "MK4", prusalink.ConfidenceConfigured, false},
+	}
+
+	for _, test := range tests {
+		snapshot := queueSnapshot(config.Printers{Address: "192.168.1.10", Type: test.printerType}, "READY", test.confidence)
+
+		if matches := Matches(test.requirements, snapshot); matches != test.matches {
+			t.Errorf("%s: Matches() = %t, expected %t", test.name, matches, test.matches)
+		}
+	}
+}
+
+func TestDispatchStates(t *testing.T) {
+	var requests atomic.Int32
+	printer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer printer.Close()
+
+	scheduler := newTestScheduler(t, t.TempDir())
+	defer scheduler.Close()
+
+	job, err := scheduler.Submit(Job{File: "box.bgcode"}, strings.NewReader("G28"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mk4 := config.Printers{Address: strings.TrimPrefix(printer.URL, "http://"), Type: "MK4", ScrapeTimeout: model.Duration(time.Second)}
+
+	for _, state := range []string{"IDLE", "FINISHED", "PRINTING"} {
+		scheduler.HandleSnapshot(prusalink.Snapshot{}, queueSnapshot(mk4, state, prusalink.ConfidenceConfigured))
+	}
+
+	if job, _ = scheduler.Get(job.ID); job.State != StateQueued || requests.Load() != 0 {
+		t.Fatalf("job is %s after %d printer requests, expected it's not dispatched to printer that is not ready", job.State, requests.Load())
+	}
+
+	scheduler.HandleSnapshot(prusalink.Snapshot{}, queueSnapshot(mk4, "READY", prusalink.ConfidenceConfigured))
+
+	// failed dispatch returns the job to the queue
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if job, _ = scheduler.Get(job.ID); job.Attempts > 0 {
+			break
+		}
+	}
+
+	if job.State != StateQueued || job.Attempts != 1 || job.Error == "" || requests.Load() == 0 {
+		t.Errorf("job = %+v, expected one failed dispatch attempt to ready printer", job)
+	}
+}
+
+func TestInterruptedDispatch(t *testing.T) {
+	dir := t.TempDir()
+	scheduler := newTestScheduler(t, dir)
+
+	job, err := scheduler.Submit(Job{File: "box.bgcode"}, strings.NewReader("G28"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// exporter stopped while the job was uploaded
+	scheduler.store.Update(job.ID, func(job *Job) error {
+		job.State = StateDispatching
+		job.Printer, job.PrinterAddress = "mk4", "192.168.1.10"
+		return nil
+	})
+	scheduler.Close()
+
+	scheduler = newTestScheduler(t, dir)
+	defer scheduler.Close()
+
+	job, err = scheduler.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.State != StateFailed || job.PrinterAddress != "192.168.1.10" || job.Error == "" {
+		t.Errorf("job = %+v, expected failed job with the printer it was dispatched to", job)
+	}
+
+	if _, err := os.Stat(scheduler.gcodePath(job.ID)); !os.IsNotExist(err) {
+		t.Errorf("gcode of failed job was not removed - %v", err)
+	}
+}
+
+func TestDispatchRetries(t *testing.T) {
+	printer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer printer.Close()
+
+	mk4 := config.Printers{Address: strings.TrimPrefix(printer.URL, "http://"), Type: "MK4", ScrapeTimeout: model.Duration(time.Second)}
+
+	tests := []struct {
+		retries int
+		states  []string // state after every failed attempt
+	}{
+		{0, []string{StateFailed}},
+		{1, []string{StateQueued, StateFailed}},
+		{3, []string{StateQueued, StateQueued, StateQueued, StateFailed}},
+	}
+
+	for _, test := range tests {
+		scheduler := newTestScheduler(t, t.TempDir())
+		scheduler.retries = test.retries
+
+		job, err := scheduler.Submit(Job{File: "box.bgcode"}, strings.NewReader("G28"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for attempt, state := range test.states {
+			scheduler.dispatch(job, mk4)
+
+			if job, _ = scheduler.Get(job.ID); job.State != state || job.Attempts != attempt+1 {
+				t.Errorf("retries %d: job is %s after %d attempts, expected %s after %d", test.retries, job.State, job.Attempts, state, attempt+1)
+			}
+		}
+
+		if job.Finished == nil {
+			t.Errorf("retries %d: finish time of failed job is missing", test.retries)
+		}
+
+		if _, err := os.Stat(scheduler.gcodePath(job.ID)); !os.IsNotExist(err) {
+			t.Errorf("retries %d: gcode of failed job was not removed - %v", test.retries, err)
+		}
+
+		scheduler.Close()
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	jobs := []Job{
+		{File: "old-queued", State: StateQueued, Queued: old},
+		{File: "old-dispatching", State: StateDispatching, Queued: old},
+		{File: "old-dispatched", State: StateDispatched, Queued: old, Finished: &old},
+		{File: "old-failed", State: StateFailed, Queued: old, Finished: &old},
+		{File: "old-cancelled", State: StateCancelled, Queued: old, Finished: &old},
+		{File: "legacy-cancelled", State: StateCancelled, Queued: old}, // stored before finish time was kept
+		{File: "recent-failed", State: StateFailed, Queued: old, Finished: &now},
+	}
+
+	for _, job := range jobs {
+		if _, err := store.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Prune(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, _ := store.List()
+	files := []string{}
+	for _, job := range remaining {
+		files = append(files, job.File)
+	}
+
+	if expected := []string{"old-queued", "old-dispatching", "recent-failed"}; strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("jobs = %v, expected %v", files, expected)
+	}
+}
+
+func TestSchedulerRetention(t *testing.T) {
+	dir := t.TempDir()
+	scheduler := newTestScheduler(t, dir)
+
+	old := time.Now().Add(-8 * 24 * time.Hour)
+	expired, _ := scheduler.store.Add(Job{File: "expired", State: StateCancelled, Queued: old, Finished: &old})
+	scheduler.Close()
+
+	// finished jobs are removed on start with default retention
+	scheduler = newTestScheduler(t, dir)
+	defer scheduler.Close()
+
+	if _, err := scheduler.Get(expired.ID); err != ErrNotFound {
+		t.Errorf("Get(expired) = %v, expected %v", err, ErrNotFound)
+	}
+
+	// and after every job leaves the queue
+	expired, _ = scheduler.store.Add(Job{File: "expired", State: StateDispatched, Queued: old, Finished: &old})
+
+	job, err := scheduler.Submit(Job{File: "box.bgcode"}, strings.NewReader("G28"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err = scheduler.Cancel(job.ID); err != nil || job.Finished == nil {
+		t.Fatalf("Cancel() = %+v, %v, expected cancelled job with finish time", job, err)
+	}
+
+	if _, err := scheduler.Get(expired.ID); err != ErrNotFound {
+		t.Errorf("Get(expired) = %v, expected %v", err, ErrNotFound)
+	}
+
+	if _, err := scheduler.Get(job.ID); err != nil {
+		t.Errorf("Get(cancelled) = %v, expected recently cancelled job to be kept", err)
+	}
+}
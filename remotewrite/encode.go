@@ -0,0 +1,140 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// skippedFamilies are not sent, their labels carry data that does not belong to time series
+var skippedFamilies = map[string]bool{
+	"prusa_job_image": true, // base64 encoded thumbnail in label
+}
+
+// maxLabelValueLength is the default limit of Mimir, Cortex and Grafana Cloud, longer labels make the whole request rejected
+const maxLabelValueLength = 2048
+
+// label is a single label of the time series
+type label struct {
+	name  string
+	value string
+}
+
+// encodeWriteRequest returns protobuf encoded remote write request of gathered metric families
+// it's encoded by hand to avoid dependency on Prometheus server - WriteRequest{timeseries=1}, TimeSeries{labels=1, samples=2}, Label{name=1, value=2}, Sample{value=1, timestamp=2}
+func encodeWriteRequest(families []*dto.MetricFamily, externalLabels map[string]string, timestamp int64) []byte {
+	var request []byte
+
+	for _, family := range families {
+		if skippedFamilies[family.GetName()] {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			sampleTime := timestamp
+			if metric.TimestampMs != nil {
+				sampleTime = metric.GetTimestampMs()
+			}
+
+			labels := []label{}
+			for _, pair := range metric.GetLabel() {
+				labels = append(labels, label{pair.GetName(), pair.GetValue()})
+			}
+			for name, value := range externalLabels {
+				labels = append(labels, label{name, value})
+			}
+
+			for _, series := range expandMetric(family.GetName(), family.GetType(), metric) {
+				request = protowire.AppendTag(request, 1, protowire.BytesType)
+				request = protowire.AppendBytes(request, encodeTimeSeries(append(series.labels, labels...), series.value, sampleTime))
+			}
+		}
+	}
+
+	return request
+}
+
+// sample is a single value of the time series with its own labels
+type sample struct {
+	labels []label
+	value  float64
+}
+
+// expandMetric returns samples of the metric, summaries and histograms are expanded the same way as in text format
+func expandMetric(name string, metricType dto.MetricType, metric *dto.Metric) []sample {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return []sample{{[]label{{"__name__", name}}, metric.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []sample{{[]label{{"__name__", name}}, metric.GetGauge().GetValue()}}
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		samples := []sample{
+			{[]label{{"__name__", name + "_sum"}}, summary.GetSampleSum()},
+			{[]label{{"__name__", name + "_count"}}, float64(summary.GetSampleCount())},
+		}
+		for _, quantile := range summary.GetQuantile() {
+			samples = append(samples, sample{[]label{{"__name__", name}, {"quantile", formatFloat(quantile.GetQuantile())}}, quantile.GetValue()})
+		}
+		return samples
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		samples := []sample{
+			{[]label{{"__name__", name + "_sum"}}, histogram.GetSampleSum()},
+			{[]label{{"__name__", name + "_count"}}, float64(histogram.GetSampleCount())},
+			{[]label{{"__name__", name + "_bucket"}, {"le", "+Inf"}}, float64(histogram.GetSampleCount())},
+		}
+		for _, bucket := range histogram.GetBucket() {
+			if math.IsInf(bucket.GetUpperBound(), +1) {
+				continue
+			}
+			samples = append(samples, sample{[]label{{"__name__", name + "_bucket"}, {"le", formatFloat(bucket.GetUpperBound())}}, float64(bucket.GetCumulativeCount())})
+		}
+		return samples
+	default:
+		return []sample{{[]label{{"__name__", name}}, metric.GetUntyped().GetValue()}}
+	}
+}
+
+// encodeTimeSeries returns protobuf encoded time series with single sample, labels are sorted by name as remote write requires
+func encodeTimeSeries(labels []label, value float64, timestamp int64) []byte {
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	var series []byte
+	seen := map[string]bool{}
+
+	for _, l := range labels {
+		if seen[l.name] || l.value == "" || len(l.value) > maxLabelValueLength {
+			continue // metric labels win over external labels, empty labels are the same as missing ones, too long labels are dropped
+		}
+		seen[l.name] = true
+
+		var encoded []byte
+		encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, l.name)
+		encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, l.value)
+
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, encoded)
+	}
+
+	var encoded []byte
+	encoded = protowire.AppendTag(encoded, 1, protowire.Fixed64Type)
+	encoded = protowire.AppendFixed64(encoded, math.Float64bits(value))
+	encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+	encoded = protowire.AppendVarint(encoded, uint64(timestamp))
+
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, encoded)
+
+	return series
+}
+
+// formatFloat returns float formatted the same way as in text format
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,194 @@
+package remotewrite
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodedSeries is a time series decoded from remote write request
+type decodedSeries struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// field is a decoded protobuf field, value is set for bytes type and fixed for fixed64 and varint types
+type field struct {
+	number protowire.Number
+	value  []byte
+	fixed  uint64
+}
+
+// fields returns fields of protobuf message, only bytes, fixed64 and varint types are expected
+func fields(t *testing.T, data []byte) []field {
+	t.Helper()
+
+	var result []field
+
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		decoded := field{number: number}
+
+		switch wireType {
+		case protowire.BytesType:
+			decoded.value, n = protowire.ConsumeBytes(data)
+		case protowire.Fixed64Type:
+			decoded.fixed, n = protowire.ConsumeFixed64(data)
+		case protowire.VarintType:
+			decoded.fixed, n = protowire.ConsumeVarint(data)
+		default:
+			t.Fatalf("unexpected wire type %d", wireType)
+		}
+
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		data = data[n:]
+		result = append(result, decoded)
+	}
+
+	return result
+}
+
+// decodeWriteRequest decodes WriteRequest{timeseries=1}, TimeSeries{labels=1, samples=2}, Label{name=1, value=2}, Sample{value=1, timestamp=2}
+func decodeWriteRequest(t *testing.T, request []byte) []decodedSeries {
+	t.Helper()
+
+	var result []decodedSeries
+
+	for _, timeSeries := range fields(t, request) {
+		if timeSeries.number != 1 {
+			t.Fatalf("unexpected field %d of WriteRequest", timeSeries.number)
+		}
+
+		series := decodedSeries{}
+		samples := 0
+
+		for _, field := range fields(t, timeSeries.value) {
+			switch field.number {
+			case 1:
+				var l label
+				for _, part := range fields(t, field.value) {
+					if part.number == 1 {
+						l.name = string(part.value)
+					} else {
+						l.value = string(part.value)
+					}
+				}
+				series.labels = append(series.labels, l)
+			case 2:
+				samples++
+				for _, part := range fields(t, field.value) {
+					if part.number == 1 {
+						series.value = math.Float64frombits(part.fixed)
+					} else {
+						series.timestamp = int64(part.fixed)
+					}
+				}
+			}
+		}
+
+		if samples != 1 {
+			t.Errorf("time series has %d samples, expected 1", samples)
+		}
+
+		result = append(result, series)
+	}
+
+	return result
+}
+
+// labelsString returns labels in text format
+func labelsString(labels []label) string {
+	parts := []string{}
+	for _, l := range labels {
+		parts = append(parts, l.name+"="+l.value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func gauge(name string, value float64, labels ...string) *dto.MetricFamily {
+	metric := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for i := 0; i < len(labels); i += 2 {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+
+	return &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{metric}}
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	longName := strings.Repeat("n", maxLabelValueLength+1)
+
+	families := []*dto.MetricFamily{
+		gauge("prusa_temperature_celsius", 215.5, "printer_name", "xl", "printer_address", "192.168.1.10", "printer_job_name", ""),
+		gauge("prusa_job_image", 1, "printer_address", "192.168.1.10", "printer_job_image", strings.Repeat("iVBORw0KGgo", 1000)),
+		gauge("prusa_info", 1, "printer_address", "192.168.1.10", "printer_job_name", longName, "instance", "printer"),
+		{
+			Name: proto.String("prusa_jobs_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Counter:     &dto.Counter{Value: proto.Float64(7)},
+				TimestampMs: proto.Int64(1000),
+			}},
+		},
+		{
+			Name: proto.String("prusa_poll_duration_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(3),
+					SampleSum:   proto.Float64(1.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(0.5), CumulativeCount: proto.Uint64(2)},
+						{UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: proto.Uint64(3)},
+					},
+				},
+			}},
+		},
+	}
+
+	decoded := decodeWriteRequest(t, encodeWriteRequest(families, map[string]string{"instance": "farm", "cluster": "lab"}, 2000))
+
+	expected := []struct {
+		labels    string
+		value     float64
+		timestamp int64
+	}{
+		{"__name__=prusa_temperature_celsius,cluster=lab,instance=farm,printer_address=192.168.1.10,printer_name=xl", 215.5, 2000},
+		{"__name__=prusa_info,cluster=lab,instance=printer,printer_address=192.168.1.10", 1, 2000},
+		{"__name__=prusa_jobs_total,cluster=lab,instance=farm", 7, 1000},
+		{"__name__=prusa_poll_duration_seconds_sum,cluster=lab,instance=farm", 1.5, 2000},
+		{"__name__=prusa_poll_duration_seconds_count,cluster=lab,instance=farm", 3, 2000},
+		{"__name__=prusa_poll_duration_seconds_bucket,cluster=lab,instance=farm,le=+Inf", 3, 2000},
+		{"__name__=prusa_poll_duration_seconds_bucket,cluster=lab,instance=farm,le=0.5", 2, 2000},
+	}
+
+	if len(decoded) != len(expected) {
+		for _, series := range decoded {
+			t.Log(labelsString(series.labels))
+		}
+		t.Fatalf("request has %d time series, expected %d", len(decoded), len(expected))
+	}
+
+	for i, series := range decoded {
+		if labels := labelsString(series.labels); labels != expected[i].labels || series.value != expected[i].value || series.timestamp != expected[i].timestamp {
+			t.Errorf("time series %d = {%s} %v @ %d, expected {%s} %v @ %d", i, labels, series.value, series.timestamp, expected[i].labels, expected[i].value, expected[i].timestamp)
+		}
+
+		for _, l := range series.labels {
+			if len(l.value) > maxLabelValueLength {
+				t.Errorf("label %s has %d bytes, expected at most %d", l.name, len(l.value), maxLabelValueLength)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/pstrobl96/prusa_exporter/config"
+	"github.com/rs/zerolog/log"
+)
+
+// segmentExtension is extension of queued requests in WAL directory
+const segmentExtension = ".snappy"
+
+// Writer periodically gathers registered metrics and pushes them to remote write endpoint
+// every gathered batch is written to WAL directory first and removed after successful delivery, so data survive connectivity loss and restarts
+type Writer struct {
+	url            string
+	username       string
+	password       string
+	bearerToken    string
+	externalLabels map[string]string
+	walDir         string
+	retention      time.Duration
+	interval       time.Duration
+	gatherer       prometheus.Gatherer
+	client         *http.Client
+	pending        chan struct{}
+	backoff        time.Duration // initial delay between retries of failed request
+	stop           chan struct{}
+	stopped        sync.WaitGroup
+}
+
+// New returns a new Writer gathering metrics from the gatherer, WAL directory is created when missing
+// temporary files of requests interrupted while being written are removed
+func New(config config.Config, gatherer prometheus.Gatherer) (*Writer, error) {
+	settings := config.Exporter.RemoteWrite
+
+	if err := os.MkdirAll(settings.WALDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	temporary, err := filepath.Glob(filepath.Join(settings.WALDir, "*"+segmentExtension+".tmp"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range temporary {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Writer{
+		url:            settings.URL,
+		username:       settings.Username,
+		password:       settings.Password,
+		bearerToken:    settings.BearerToken,
+		externalLabels: settings.ExternalLabels,
+		walDir:         settings.WALDir,
+		retention:      time.Duration(settings.WALRetention),
+		interval:       time.Duration(settings.Interval),
+		gatherer:       gatherer,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		pending:        make(chan struct{}, 1),
+		backoff:        time.Second,
+		stop:           make(chan struct{}),
+	}, nil
+}
+
+// Start starts gathering and sending in background, requests queued before restart are sent first
+func (writer *Writer) Start() {
+	go writer.send()
+	writer.notify()
+
+	writer.stopped.Add(1)
+
+	go func() {
+		defer writer.stopped.Done()
+
+		ticker := time.NewTicker(writer.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-writer.stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := writer.gather(); err != nil {
+				log.Error().Msg("Error while queueing remote write request - " + err.Error())
+				continue
+			}
+			writer.notify()
+		}
+	}()
+}
+
+// Stop stops gathering and waits for the request being written to WAL, queued requests are sent after restart
+func (writer *Writer) Stop() {
+	close(writer.stop)
+	writer.stopped.Wait()
+}
+
+// notify wakes up the sender, notification is dropped when sender is already woken up
+func (writer *Writer) notify() {
+	select {
+	case writer.pending <- struct{}{}:
+	default:
+	}
+}
+
+// gather gathers metrics and writes compressed request to WAL directory, file is renamed after it's written so sender never reads partial request
+func (writer *Writer) gather() error {
+	families, err := writer.gatherer.Gather()
+
+	if err != nil && len(families) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	request := snappy.Encode(nil, encodeWriteRequest(families, writer.externalLabels, now.UnixMilli()))
+	path := filepath.Join(writer.walDir, fmt.Sprintf("%020d", now.UnixNano())+segmentExtension)
+
+	if err := os.WriteFile(path+".tmp", request, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// send sends queued requests in order, failed request is retried with exponential backoff and the queue waits for it
+func (writer *Writer) send() {
+	backoff := writer.backoff
+
+	for range writer.pending {
+		for {
+			segments, err := writer.segments()
+
+			if err != nil {
+				log.Error().Msg("Error while reading remote write WAL " + writer.walDir + " - " + err.Error())
+				break
+			}
+
+			if len(segments) == 0 {
+				break
+			}
+
+			if writer.expired(segments[0]) {
+				log.Warn().Msg("Dropping remote write request " + segments[0] + " older than WAL retention")
+				os.Remove(segments[0])
+				continue
+			}
+
+			retry, err := writer.post(segments[0])
+
+			if err != nil && retry {
+				log.Error().Msg("Error while sending remote write request, " + strconv.Itoa(len(segments)) + " requests queued - " + err.Error())
+				time.Sleep(backoff)
+				backoff = min(2*backoff, writer.interval)
+				continue
+			}
+
+			if err != nil {
+				log.Error().Msg("Remote write request " + segments[0] + " was rejected and dropped - " + err.Error())
+			}
+
+			backoff = writer.backoff
+			os.Remove(segments[0])
+		}
+	}
+}
+
+// post sends single queued request and reports whether failed request should be retried
+func (writer *Writer) post(segment string) (bool, error) {
+	body, err := os.ReadFile(segment)
+
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest("POST", writer.url, bytes.NewReader(body))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "prusa_exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if writer.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+writer.bearerToken)
+	} else if writer.username != "" {
+		req.SetBasicAuth(writer.username, writer.password)
+	}
+
+	res, err := writer.client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer res.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("endpoint returned status %d - %s", res.StatusCode, strings.TrimSpace(string(message)))
+}
+
+// segments returns queued requests ordered from the oldest
+func (writer *Writer) segments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(writer.walDir, "*"+segmentExtension))
+
+	sort.Strings(segments)
+
+	return segments, err
+}
+
+// expired returns true if the queued request is older than WAL retention
+func (writer *Writer) expired(segment string) bool {
+	nanoseconds, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(segment), segmentExtension), 10, 64)
+
+	if err != nil {
+		return false
+	}
+
+	return time.Since(time.Unix(0, nanoseconds)) > writer.retention
+}
@@ -0,0 +1,280 @@
+package remotewrite
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// received is a request received by the test endpoint
+type received struct {
+	header http.Header
+	body   string
+}
+
+// endpoint is a test remote write endpoint answering with statuses in order, the last status is repeated
+type endpoint struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func (endpoint *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	endpoint.mutex.Lock()
+	status := http.StatusNoContent
+	if len(endpoint.statuses) > 0 {
+		status = endpoint.statuses[min(len(endpoint.requests), len(endpoint.statuses)-1)]
+	}
+	endpoint.requests = append(endpoint.requests, received{header: r.Header.Clone(), body: string(body)})
+	endpoint.mutex.Unlock()
+
+	w.WriteHeader(status)
+}
+
+// bodies returns bodies of received requests in order
+func (endpoint *endpoint) bodies() []string {
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+
+	bodies := []string{}
+	for _, request := range endpoint.requests {
+		bodies = append(bodies, request.body)
+	}
+	return bodies
+}
+
+// newTestWriter returns writer with WAL in temporary directory sending to the test endpoint
+func newTestWriter(t *testing.T, endpoint *endpoint) *Writer {
+	t.Helper()
+
+	server := httptest.NewServer(endpoint)
+	t.Cleanup(server.Close)
+
+	var cfg config.Config
+	cfg.Exporter.RemoteWrite.URL = server.URL
+	cfg.Exporter.RemoteWrite.Interval = model.Duration(time.Hour)
+	cfg.Exporter.RemoteWrite.WALDir = filepath.Join(t.TempDir(), "wal")
+	cfg.Exporter.RemoteWrite.WALRetention = model.Duration(time.Hour)
+
+	writer, err := New(cfg, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.backoff = time.Millisecond
+
+	return writer
+}
+
+// queue writes request to WAL as if it was gathered at given time
+func queue(t *testing.T, writer *Writer, at time.Time, body string) string {
+	t.Helper()
+
+	path := filepath.Join(writer.walDir, fmt.Sprintf("%020d", at.UnixNano())+segmentExtension)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// waitForEmptyWAL waits until all queued requests are sent or dropped
+func waitForEmptyWAL(t *testing.T, writer *Writer) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		segments, err := writer.segments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("requests %v were not sent", segments)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		status int
+		retry  bool
+		err    bool
+	}{
+		{http.StatusNoContent, false, false},
+		{http.StatusOK, false, false},
+		{http.StatusInternalServerError, true, true},
+		{http.StatusServiceUnavailable, true, true},
+		{http.StatusTooManyRequests, true, true},
+		{http.StatusBadRequest, false, true},
+		{http.StatusUnauthorized, false, true},
+	}
+
+	for _, test := range tests {
+		endpoint := &endpoint{statuses: []int{test.status}}
+		writer := newTestWriter(t, endpoint)
+		writer.bearerToken = "secret"
+
+		retry, err := writer.post(queue(t, writer, time.Now(), "request"))
+
+		if retry != test.retry || (err != nil) != test.err {
+			t.Errorf("post() with status %d = %t, %v, expected retry %t and error %t", test.status, retry, err, test.retry, test.err)
+		}
+
+		request := endpoint.requests[0]
+		if request.body != "request" {
+			t.Errorf("body = %q, expected queued request", request.body)
+		}
+
+		for header, value := range map[string]string{
+			"Content-Encoding":                  "snappy",
+			"Content-Type":                      "application/x-protobuf",
+			"X-Prometheus-Remote-Write-Version": "0.1.0",
+			"Authorization":                     "Bearer secret",
+		} {
+			if request.header.Get(header) != value {
+				t.Errorf("%s = %q, expected %q", header, request.header.Get(header), value)
+			}
+		}
+	}
+}
+
+func TestPostBasicAuth(t *testing.T) {
+	endpoint := &endpoint{}
+	writer := newTestWriter(t, endpoint)
+	writer.username, writer.password = "prusa", "password"
+
+	if _, err := writer.post(queue(t, writer, time.Now(), "request")); err != nil {
+		t.Fatal(err)
+	}
+
+	request, _ := http.NewRequest("POST", "/", nil)
+	request.Header = endpoint.requests[0].header
+	if username, password, ok := request.BasicAuth(); !ok || username != "prusa" || password != "password" {
+		t.Errorf("basic auth = %q, %q, expected configured credentials", username, password)
+	}
+}
+
+func TestSend(t *testing.T) {
+	// the oldest request is retried until it's accepted, rejected request is dropped
+	endpoint := &endpoint{statuses: []int{500, 429, 204, 400, 204}}
+	writer := newTestWriter(t, endpoint)
+	now := time.Now()
+
+	queue(t, writer, now.Add(-3*time.Minute), "first")
+	queue(t, writer, now.Add(-2*time.Minute), "rejected")
+	queue(t, writer, now.Add(-time.Minute), "last")
+
+	go writer.send()
+	writer.notify()
+	waitForEmptyWAL(t, writer)
+
+	expected := []string{"first", "first", "first", "rejected", "last"}
+	if bodies := endpoint.bodies(); fmt.Sprint(bodies) != fmt.Sprint(expected) {
+		t.Errorf("requests = %v, expected %v", bodies, expected)
+	}
+}
+
+func TestSendDropsExpired(t *testing.T) {
+	endpoint := &endpoint{}
+	writer := newTestWriter(t, endpoint)
+	now := time.Now()
+
+	queue(t, writer, now.Add(-2*time.Hour), "expired")
+	queue(t, writer, now.Add(-time.Minute), "recent")
+
+	go writer.send()
+	writer.notify()
+	waitForEmptyWAL(t, writer)
+
+	if bodies := endpoint.bodies(); len(bodies) != 1 || bodies[0] != "recent" {
+		t.Errorf("requests = %v, expected only recent", bodies)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	writer := newTestWriter(t, &endpoint{})
+	now := time.Now()
+
+	tests := []struct {
+		segment string
+		expired bool
+	}{
+		{fmt.Sprintf("%020d", now.Add(-2*time.Hour).UnixNano()) + segmentExtension, true},
+		{fmt.Sprintf("%020d", now.Add(-59*time.Minute).UnixNano()) + segmentExtension, false},
+		{"unknown" + segmentExtension, false}, // unknown files are never dropped as expired
+	}
+
+	for _, test := range tests {
+		if expired := writer.expired(filepath.Join(writer.walDir, test.segment)); expired != test.expired {
+			t.Errorf("expired(%s) = %t, expected %t", test.segment, expired, test.expired)
+		}
+	}
+}
+
+func TestNewRemovesTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	temporary := filepath.Join(dir, fmt.Sprintf("%020d", time.Now().UnixNano())+segmentExtension+".tmp")
+	segment := filepath.Join(dir, fmt.Sprintf("%020d", time.Now().UnixNano())+segmentExtension)
+
+	for _, path := range []string{temporary, segment} {
+		if err := os.WriteFile(path, []byte("partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var cfg config.Config
+	cfg.Exporter.RemoteWrite.WALDir = dir
+
+	if _, err := New(cfg, prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(temporary); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not removed - %v", err)
+	}
+
+	if _, err := os.Stat(segment); err != nil {
+		t.Errorf("queued request was removed - %v", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	endpoint := &endpoint{statuses: []int{500}} // requests stay in WAL
+	writer := newTestWriter(t, endpoint)
+	writer.backoff = time.Hour
+	writer.interval = time.Millisecond
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"}))
+	writer.gatherer = registry
+
+	writer.Start()
+	time.Sleep(20 * time.Millisecond)
+	writer.Stop()
+
+	segments, _ := writer.segments()
+	time.Sleep(20 * time.Millisecond)
+
+	if after, _ := writer.segments(); len(segments) == 0 || len(after) != len(segments) {
+		t.Errorf("requests = %d after stop and %d later, expected gathering to stop", len(segments), len(after))
+	}
+
+	if temporary, _ := filepath.Glob(filepath.Join(writer.walDir, "*.tmp")); len(temporary) != 0 {
+		t.Errorf("temporary files %v were left after stop", temporary)
+	}
+}
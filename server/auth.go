@@ -0,0 +1,34 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerToken returns token from Authorization header, tokens in query are not accepted as they end up in access logs
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+// checkToken returns true if request carries the token as bearer token, empty token never matches
+func checkToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) == 1
+}
+
+// checkQueryToken returns true if request carries the token as token query parameter, empty token never matches
+// it's meant only for read-only endpoints embedded in pages that can't send headers
+func checkQueryToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		token  string
+		ok     bool
+	}{
+		{"bearer token", "/camera/", "Bearer secret", "secret", true},
+		{"wrong bearer token", "/camera/", "Bearer other", "secret", false},
+		{"query token is ignored", "/camera/?token=secret", "", "secret", false},
+		{"missing token", "/camera/", "", "secret", false},
+		{"empty configured token", "/camera/", "Bearer ", "", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+
+		if ok := checkToken(r, test.token); ok != test.ok {
+			t.Errorf("%s: checkToken() = %t, expected %t", test.name, ok, test.ok)
+		}
+	}
+}
+
+func TestCheckQueryToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		token  string
+		ok     bool
+	}{
+		{"query token", "/camera/?token=embed", "embed", true},
+		{"wrong query token", "/camera/?token=other", "embed", false},
+		{"missing query token", "/camera/", "embed", false},
+		{"empty configured token", "/camera/?token=", "", false},
+	}
+
+	for _, test := range tests {
+		if ok := checkQueryToken(httptest.NewRequest("GET", test.target, nil), test.token); ok != test.ok {
+			t.Errorf("%s: checkQueryToken() = %t, expected %t", test.name, ok, test.ok)
+		}
+	}
+}
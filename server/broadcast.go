@@ -0,0 +1,50 @@
+package server
+
+import (
+	"sync"
+
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// Broadcaster passes snapshots from the poller to connected streaming clients
+type Broadcaster struct {
+	subscribers map[chan prusalink.Snapshot]struct{}
+	mutex       sync.Mutex
+}
+
+// NewBroadcaster returns a new Broadcaster, HandleSnapshot has to be subscribed to the poller
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: map[chan prusalink.Snapshot]struct{}{}}
+}
+
+// HandleSnapshot sends the snapshot to all clients, slow clients miss the snapshot instead of blocking the poller
+func (broadcaster *Broadcaster) HandleSnapshot(_ prusalink.Snapshot, snapshot prusalink.Snapshot) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+
+	for subscriber := range broadcaster.subscribers {
+		select {
+		case subscriber <- snapshot:
+		default:
+		}
+	}
+}
+
+// subscribe returns channel receiving snapshots until it's unsubscribed
+func (broadcaster *Broadcaster) subscribe() chan prusalink.Snapshot {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+
+	subscriber := make(chan prusalink.Snapshot, 16)
+	broadcaster.subscribers[subscriber] = struct{}{}
+
+	return subscriber
+}
+
+// unsubscribe stops sending snapshots to the channel
+func (broadcaster *Broadcaster) unsubscribe(subscriber chan prusalink.Snapshot) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+
+	delete(broadcaster.subscribers, subscriber)
+}
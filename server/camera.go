@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// CameraPath is the path prefix of camera snapshot proxy - /camera/<printer>/<camera_id>/snapshot
+const CameraPath = "/camera/"
+
+// CameraHandler returns handler proxying the latest camera snapshot, printer credentials stay in the exporter
+// embed token is accepted in query parameter, so snapshots can be embedded in Grafana panels that can't send headers
+func CameraHandler(printers []config.Printers, token string, embedToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkToken(r, token) && !checkQueryToken(r, embedToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "expected GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, CameraPath), "/")
+
+		if len(parts) != 3 || parts[2] != "snapshot" {
+			http.Error(w, "expected path "+CameraPath+"<printer>/<camera_id>/snapshot", http.StatusNotFound)
+			return
+		}
+
+		printer, ok := findPrinter(printers, parts[0])
+
+		if !ok {
+			http.Error(w, "printer "+parts[0]+" is not configured", http.StatusNotFound)
+			return
+		}
+
+		snapshot, contentType, err := prusalink.GetCameraSnapshot(printer, parts[1])
+
+		if errors.Is(err, prusalink.ErrCameraNotFound) {
+			http.Error(w, "camera "+parts[1]+" of "+parts[0]+" not found", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			log.Error().Msg("Error while getting camera snapshot from " + printer.Address + " - " + err.Error())
+			http.Error(w, "snapshot is not available - "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		if contentType == "" {
+			contentType = http.DetectContentType(snapshot)
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(snapshot)
+	}
+}
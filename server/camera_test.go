@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// cameraPrinter returns test printer with camera "front" answering JPEG, camera "raw" without content type and camera "broken" failing
+func cameraPrinter(t *testing.T) []config.Printers {
+	t.Helper()
+
+	printer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/cameras/front/snap":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("jpeg data"))
+		case "/api/v1/cameras/raw/snap":
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		case "/api/v1/cameras/broken/snap":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(printer.Close)
+
+	return []config.Printers{{Address: strings.TrimPrefix(printer.URL, "http://"), Name: "mk4", ScrapeTimeout: model.Duration(time.Second)}}
+}
+
+func TestCameraHandler(t *testing.T) {
+	handler := CameraHandler(cameraPrinter(t), "secret", "embed")
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		header      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"bearer token", "GET", "mk4/front/snapshot", "Bearer secret", http.StatusOK, "image/jpeg", "jpeg data"},
+		{"embed token in query", "GET", "mk4/front/snapshot?token=embed", "", http.StatusOK, "image/jpeg", "jpeg data"},
+		{"bearer token in query", "GET", "mk4/front/snapshot?token=secret", "", http.StatusUnauthorized, "", ""},
+		{"embed token as bearer", "GET", "mk4/front/snapshot", "Bearer embed", http.StatusUnauthorized, "", ""},
+		{"missing token", "GET", "mk4/front/snapshot", "", http.StatusUnauthorized, "", ""},
+		{"detected content type", "GET", "mk4/raw/snapshot", "Bearer secret", http.StatusOK, "image/png", ""},
+		{"unknown printer", "GET", "mk3/front/snapshot", "Bearer secret", http.StatusNotFound, "", ""},
+		{"unknown camera", "GET", "mk4/back/snapshot", "Bearer secret", http.StatusNotFound, "", ""},
+		{"printer error", "GET", "mk4/broken/snapshot", "Bearer secret", http.StatusBadGateway, "", ""},
+		{"missing snapshot suffix", "GET", "mk4/front", "Bearer secret", http.StatusNotFound, "", ""},
+		{"too long path", "GET", "mk4/front/snapshot/x", "Bearer secret", http.StatusNotFound, "", ""},
+		{"write method", "POST", "mk4/front/snapshot?token=embed", "", http.StatusMethodNotAllowed, "", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, CameraPath+test.target, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, r)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status = %d, expected %d", test.name, recorder.Code, test.status)
+			continue
+		}
+
+		if test.contentType != "" && recorder.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s: Content-Type = %q, expected %q", test.name, recorder.Header().Get("Content-Type"), test.contentType)
+		}
+
+		if test.body != "" && recorder.Body.String() != test.body {
+			t.Errorf("%s: body = %q, expected %q", test.name, recorder.Body.String(), test.body)
+		}
+	}
+}
+
+func TestCameraHandlerWithoutEmbedToken(t *testing.T) {
+	handler := CameraHandler(cameraPrinter(t), "secret", "")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", CameraPath+"mk4/front/snapshot?token=", nil))
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, expected 401 for empty query token", recorder.Code)
+	}
+}
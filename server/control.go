@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// ControlPath is the path prefix of job control - PUT /api/control/<printer>/job/<id>/pause|resume, DELETE /api/control/<printer>/job/<id>
+const ControlPath = "/api/control/"
+
+// AuditEntry is a struct that contains single record of the audit log
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Remote  string    `json:"remote"`
+	Printer string    `json:"printer"`
+	Address string    `json:"address"`
+	JobID   string    `json:"job_id"`
+	Action  string    `json:"action"`
+	Result  string    `json:"result"` // ok or error message
+}
+
+// AuditLog appends control actions to JSON lines file
+type AuditLog struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewAuditLog returns a new AuditLog writing to the path
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record writes the entry to the audit log and to the exporter log
+func (audit *AuditLog) Record(entry AuditEntry) {
+	log.Info().Str("user", entry.User).Str("printer", entry.Address).Str("job", entry.JobID).Str("action", entry.Action).Str("result", entry.Result).Msg("Control action")
+
+	line, _ := json.Marshal(entry)
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	file, err := os.OpenFile(audit.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		log.Error().Msg("Error while opening audit log " + audit.path + " - " + err.Error())
+		return
+	}
+
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Error().Msg("Error while writing audit log " + audit.path + " - " + err.Error())
+	}
+}
+
+// ControlHandler returns handler of job control, every request has to carry one of the tokens, "current" job id is resolved from the last poll
+func ControlHandler(printers []config.Printers, tokens map[string]string, poller *prusalink.Poller, audit *AuditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := findUser(r, tokens)
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, ControlPath), "/"), "/")
+
+		if !ok {
+			// rejected attempts are audited too, the token itself is not written
+			entry := AuditEntry{Time: time.Now(), Remote: r.RemoteAddr, Printer: parts[0], Action: r.Method + " " + r.URL.Path, Result: "invalid token"}
+			if bearerToken(r) == "" {
+				entry.Result = "missing token"
+			}
+			audit.Record(entry)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if len(parts) < 3 || len(parts) > 4 || parts[1] != "job" {
+			http.Error(w, "expected path "+ControlPath+"<printer>/job/<id>[/pause|/resume]", http.StatusNotFound)
+			return
+		}
+
+		action := ""
+		switch {
+		case len(parts) == 4 && parts[3] == "pause" && r.Method == http.MethodPut:
+			action = "pause"
+		case len(parts) == 4 && parts[3] == "resume" && r.Method == http.MethodPut:
+			action = "resume"
+		case len(parts) == 3 && r.Method == http.MethodDelete:
+			action = "stop"
+		default:
+			http.Error(w, "expected PUT .../pause, PUT .../resume or DELETE .../job/<id>", http.StatusMethodNotAllowed)
+			return
+		}
+
+		printer, ok := findPrinter(printers, parts[0])
+
+		if !ok {
+			http.Error(w, "printer "+parts[0]+" is not configured", http.StatusNotFound)
+			return
+		}
+
+		entry := AuditEntry{
+			Time:    time.Now(),
+			User:    user,
+			Remote:  r.RemoteAddr,
+			Printer: printer.Name,
+			Address: printer.Address,
+			JobID:   parts[2],
+			Action:  action,
+		}
+
+		if entry.JobID == "current" {
+			summary := getSummary(printer, poller)
+
+			if summary.Job == nil {
+				entry.Result = "no job is running"
+				audit.Record(entry)
+				http.Error(w, entry.Result, http.StatusConflict)
+				return
+			}
+
+			entry.JobID = strconv.FormatFloat(summary.Job.ID, 'f', -1, 64)
+		}
+
+		var err error
+		switch action {
+		case "pause":
+			err = prusalink.PauseJob(printer, entry.JobID)
+		case "resume":
+			err = prusalink.ResumeJob(printer, entry.JobID)
+		case "stop":
+			err = prusalink.StopJob(printer, entry.JobID)
+		}
+
+		if err != nil {
+			entry.Result = err.Error()
+			audit.Record(entry)
+
+			// printer refused the action in current job state, it's not a gateway error
+			if errors.Is(err, prusalink.ErrJobState) {
+				http.Error(w, action+" failed - "+err.Error(), http.StatusConflict)
+				return
+			}
+
+			log.Error().Msg("Error while sending " + action + " to " + printer.Address + " - " + err.Error())
+			http.Error(w, action+" failed - "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		entry.Result = "ok"
+		audit.Record(entry)
+		writeJSON(w, entry)
+	}
+}
+
+// findUser returns user whose token is carried by the request as bearer token
+func findUser(r *http.Request, tokens map[string]string) (string, bool) {
+	provided := bearerToken(r)
+
+	if provided == "" {
+		return "", false
+	}
+
+	for user, token := range tokens {
+		if token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			return user, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+func TestFindUser(t *testing.T) {
+	tokens := map[string]string{"alice": "secret", "bob": ""}
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		user   string
+		ok     bool
+	}{
+		{"bearer token", "/api/queue", "Bearer secret", "alice", true},
+		{"wrong bearer token", "/api/queue", "Bearer other", "", false},
+		{"query token is ignored", "/api/queue?token=secret", "", "", false},
+		{"empty token does not match user without token", "/api/queue", "Bearer ", "", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+
+		if user, ok := findUser(r, tokens); user != test.user || ok != test.ok {
+			t.Errorf("%s: findUser() = %q, %t, expected %q, %t", test.name, user, ok, test.user, test.ok)
+		}
+	}
+}
+
+// readAudit returns entries of the audit log
+func readAudit(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestControlHandler(t *testing.T) {
+	printer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/job/7/pause":
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v1/job/7/resume":
+			w.WriteHeader(http.StatusConflict) // job is not paused
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer printer.Close()
+
+	printers := []config.Printers{{Address: strings.TrimPrefix(printer.URL, "http://"), Name: "xl", ScrapeTimeout: model.Duration(time.Second)}}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	handler := ControlHandler(printers, map[string]string{"alice": "secret"}, nil, NewAuditLog(auditPath))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+		user   string
+		result string
+	}{
+		{"pause", "PUT", "xl/job/7/pause", "secret", http.StatusOK, "alice", "ok"},
+		{"printer refuses action", "PUT", "xl/job/7/resume", "secret", http.StatusConflict, "alice", "PUT /api/v1/job/7/resume returned status 409 - job is not in the right state"},
+		{"printer fails", "DELETE", "xl/job/7", "secret", http.StatusBadGateway, "alice", "DELETE /api/v1/job/7 returned status 500"},
+		{"invalid token", "PUT", "xl/job/7/pause", "other", http.StatusUnauthorized, "", "invalid token"},
+		{"missing token", "PUT", "xl/job/7/pause", "", http.StatusUnauthorized, "", "missing token"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, ControlPath+test.path, nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, r)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status = %d, expected %d", test.name, recorder.Code, test.status)
+		}
+	}
+
+	entries := readAudit(t, auditPath)
+	if len(entries) != len(tests) {
+		t.Fatalf("audit log has %d entries, expected %d", len(entries), len(tests))
+	}
+
+	for i, test := range tests {
+		if entries[i].User != test.user || entries[i].Result != test.result || entries[i].Printer != "xl" {
+			t.Errorf("%s: audit entry = %+v, expected user %q and result %q", test.name, entries[i], test.user, test.result)
+		}
+	}
+
+	if data, _ := os.ReadFile(auditPath); strings.Contains(string(data), "other") {
+		t.Errorf("audit log contains rejected token")
+	}
+}
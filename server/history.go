@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/history"
+	"github.com/rs/zerolog/log"
+)
+
+// HistoryPath is the path of print history API
+const HistoryPath = "/api/history"
+
+var historyCSVHeader = []string{"printer", "printer_address", "printer_model", "job_id", "file", "path", "start", "end", "duration_seconds", "print_time_seconds", "estimated_print_time_seconds", "outcome", "filament_type", "filament_grams", "filament_meters", "filament_cost"}
+
+// HistoryHandler returns handler of print history, records are filtered by printer, from and to parameters and returned as JSON or CSV with format=csv
+func HistoryHandler(store *history.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := history.Filter{Printer: query.Get("printer")}
+
+		var err error
+
+		if filter.From, err = parseTime(query.Get("from")); err != nil {
+			http.Error(w, "invalid from parameter - "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if filter.To, err = parseTime(query.Get("to")); err != nil {
+			http.Error(w, "invalid to parameter - "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		records, err := store.Query(filter)
+
+		if err != nil {
+			log.Error().Msg("Error while querying print history - " + err.Error())
+			http.Error(w, "history is not available", http.StatusInternalServerError)
+			return
+		}
+
+		if query.Get("format") == "csv" {
+			writeHistoryCSV(w, records)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			log.Error().Msg("Error while encoding print history - " + err.Error())
+		}
+	}
+}
+
+// writeHistoryCSV writes records without thumbnails as CSV
+func writeHistoryCSV(w http.ResponseWriter, records []history.Record) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=history.csv")
+
+	writer := csv.NewWriter(w)
+	writer.Write(historyCSVHeader)
+
+	for _, record := range records {
+		writer.Write([]string{
+			record.Printer,
+			record.PrinterAddress,
+			record.PrinterModel,
+			strconv.FormatFloat(record.JobID, 'f', -1, 64),
+			record.File,
+			record.Path,
+			record.Start.Format(time.RFC3339),
+			record.End.Format(time.RFC3339),
+			strconv.FormatFloat(record.DurationSeconds, 'f', 0, 64),
+			strconv.FormatFloat(record.PrintTimeSeconds, 'f', 0, 64),
+			strconv.FormatFloat(record.EstimatedPrintTimeSeconds, 'f', 0, 64),
+			record.Outcome,
+			record.FilamentType,
+			strconv.FormatFloat(record.FilamentGrams, 'f', 2, 64),
+			strconv.FormatFloat(record.FilamentMeters, 'f', 2, 64),
+			strconv.FormatFloat(record.FilamentCost, 'f', 2, 64),
+		})
+	}
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		log.Error().Msg("Error while writing print history CSV - " + err.Error())
+	}
+}
+
+// parseTime parses RFC 3339 time or unix timestamp in seconds, empty value is zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/history"
+)
+
+// historyStore returns store with two finished jobs, the first ends at 2026-03-01 12:00 UTC and the second an hour later
+func historyStore(t *testing.T) *history.Store {
+	t.Helper()
+
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	end := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	records := []history.Record{
+		{Printer: "xl", PrinterAddress: "192.168.1.10", PrinterModel: "XL", JobID: 42, File: "benchy.bgcode", Path: "/usb", Start: end.Add(-2 * time.Hour), End: end, DurationSeconds: 7200, PrintTimeSeconds: 7000.4, EstimatedPrintTimeSeconds: 6900, Outcome: "completed", FilamentType: "PLA", FilamentGrams: 15.123, FilamentMeters: 4.9, FilamentCost: 0.4, Thumbnail: "iVBORw0KGgo="},
+		{Printer: "mk4", PrinterAddress: "192.168.1.11", PrinterModel: "MK4", JobID: 7, File: "clip.gcode", Path: "/usb", Start: end, End: end.Add(time.Hour), DurationSeconds: 3600, PrintTimeSeconds: 1200, Outcome: "cancelled", FilamentType: "PETG"},
+	}
+
+	for _, record := range records {
+		if err := store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return store
+}
+
+// getHistory returns recorded response of history handler for given query
+func getHistory(store *history.Store, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	HistoryHandler(store)(recorder, httptest.NewRequest("GET", HistoryPath+query, nil))
+
+	return recorder
+}
+
+func TestHistoryHandler(t *testing.T) {
+	store := historyStore(t)
+
+	tests := []struct {
+		name  string
+		query string
+		files []string
+	}{
+		{"everything", "", []string{"benchy.bgcode", "clip.gcode"}},
+		{"printer", "?printer=mk4", []string{"clip.gcode"}},
+		{"unix from", "?from=1772370000", []string{"clip.gcode"}}, // 2026-03-01 13:00 UTC
+		{"unix to", "?to=1772366400", []string{"benchy.bgcode"}},  // 2026-03-01 12:00 UTC
+		{"rfc3339 from", "?from=2026-03-01T12:30:00Z", []string{"clip.gcode"}},
+		{"rfc3339 to with offset", "?to=2026-03-01T13:30:00%2B01:00", []string{"benchy.bgcode"}},
+		{"empty range", "?from=2026-03-02T00:00:00Z", []string{}},
+	}
+
+	for _, test := range tests {
+		recorder := getHistory(store, test.query)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, expected %d", test.name, recorder.Code, http.StatusOK)
+			continue
+		}
+
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: Content-Type = %q, expected application/json", test.name, contentType)
+		}
+
+		var records []history.Record
+		if err := json.Unmarshal(recorder.Body.Bytes(), &records); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		files := []string{}
+		for _, record := range records {
+			files = append(files, record.File)
+		}
+
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("%s: files = %v, expected %v", test.name, files, test.files)
+		}
+	}
+}
+
+func TestHistoryHandlerInvalidTime(t *testing.T) {
+	store := historyStore(t)
+
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{"?from=yesterday", "invalid from parameter"},
+		{"?to=2026-03-01", "invalid to parameter"},
+		{"?from=1772370000&to=1.5", "invalid to parameter"},
+	}
+
+	for _, test := range tests {
+		recorder := getHistory(store, test.query)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, expected %d", test.query, recorder.Code, http.StatusBadRequest)
+		}
+
+		if body := recorder.Body.String(); !strings.HasPrefix(body, test.message) {
+			t.Errorf("%s: body = %q, expected %q", test.query, body, test.message)
+		}
+	}
+}
+
+func TestHistoryHandlerCSV(t *testing.T) {
+	recorder := getHistory(historyStore(t), "?format=csv")
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/csv" {
+		t.Errorf("Content-Type = %q, expected text/csv", contentType)
+	}
+
+	if disposition := recorder.Header().Get("Content-Disposition"); disposition != "attachment; filename=history.csv" {
+		t.Errorf("Content-Disposition = %q, expected history.csv attachment", disposition)
+	}
+
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// thumbnails are not exported, durations are rounded to seconds and filament to two decimals
+	expected := [][]string{
+		historyCSVHeader,
+		{"xl", "192.168.1.10", "XL", "42", "benchy.bgcode", "/usb", "2026-03-01T10:00:00Z", "2026-03-01T12:00:00Z", "7200", "7000", "6900", "completed", "PLA", "15.12", "4.90", "0.40"},
+		{"mk4", "192.168.1.11", "MK4", "7", "clip.gcode", "/usb", "2026-03-01T12:00:00Z", "2026-03-01T13:00:00Z", "3600", "1200", "0", "cancelled", "PETG", "0.00", "0.00", "0.00"},
+	}
+
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("CSV = %q, expected %q", rows, expected)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// PrintersPath is the path of printers summary - /api/printers lists all printers, /api/printers/<printer> returns single printer
+const PrintersPath = "/api/printers"
+
+// PrintersHandler returns handler of normalized printer summaries, printers are returned in configuration order
+func PrintersHandler(printers []config.Printers, poller *prusalink.Poller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := strings.Trim(strings.TrimPrefix(r.URL.Path, PrintersPath), "/")
+
+		if target == "" {
+			summaries := []prusalink.Summary{}
+			for _, printer := range printers {
+				summaries = append(summaries, getSummary(printer, poller))
+			}
+
+			writeJSON(w, summaries)
+			return
+		}
+
+		printer, ok := findPrinter(printers, target)
+
+		if !ok {
+			http.Error(w, "printer "+target+" is not configured", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, getSummary(printer, poller))
+	}
+}
+
+// getSummary returns summary of the printer, printers that were not polled yet are reported as offline
+func getSummary(printer config.Printers, poller *prusalink.Poller) prusalink.Summary {
+	snapshot, ok := poller.Snapshot(printer.Address)
+
+	if !ok {
+		snapshot = prusalink.Snapshot{Config: printer}
+	}
+
+	return prusalink.GetSummary(snapshot)
+}
+
+// writeJSON writes value as JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error().Msg("Error while encoding JSON response - " + err.Error())
+	}
+}
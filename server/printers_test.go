@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// fixturePrinter returns test printer answering with fixtures of prusalink/api/buddy, unknown endpoints answer 404
+func fixturePrinter(t *testing.T, fixtures map[string]string) string {
+	t.Helper()
+
+	printer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := fixtures[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, err := os.ReadFile(filepath.Join("..", "prusalink", "api", "buddy", fixture))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(printer.Close)
+
+	return strings.TrimPrefix(printer.URL, "http://")
+}
+
+// startPoller returns poller after the first poll of all printers, printers are not polled again during the test
+func startPoller(t *testing.T, printers []config.Printers) *prusalink.Poller {
+	t.Helper()
+
+	poller := prusalink.NewPoller(config.Config{Printers: printers})
+	poller.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(poller.Snapshots()) < len(printers) {
+		if time.Now().After(deadline) {
+			t.Fatal("printers were not polled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return poller
+}
+
+// getSummaries returns response of printers handler decoded into value
+func getSummaries(t *testing.T, handler http.HandlerFunc, path string, value any) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", path, nil))
+
+	if recorder.Code == http.StatusOK {
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: Content-Type = %q, expected application/json", path, contentType)
+		}
+
+		if err := json.Unmarshal(recorder.Body.Bytes(), value); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	return recorder.Code
+}
+
+func TestPrintersHandler(t *testing.T) {
+	mk4 := fixturePrinter(t, map[string]string{
+		"/api/job":       "job.json",
+		"/api/printer":   "printer.json",
+		"/api/version":   "version.json",
+		"/api/v1/status": "v1/status.json",
+		"/api/v1/info":   "v1/info.json",
+	})
+	offline := fixturePrinter(t, nil)
+
+	printers := []config.Printers{
+		{Address: mk4, Name: "mk4", Type: "MK4", ScrapeTimeout: model.Duration(time.Second), PollInterval: model.Duration(time.Hour)},
+		{Address: offline, Name: "xl", Type: "XL", Location: "lab", ScrapeTimeout: model.Duration(time.Second), PollInterval: model.Duration(time.Hour)},
+	}
+	handler := PrintersHandler(printers, startPoller(t, printers))
+
+	var summaries []prusalink.Summary
+	if status := getSummaries(t, handler, PrintersPath, &summaries); status != http.StatusOK || len(summaries) != 2 {
+		t.Fatalf("status = %d, summaries = %+v, expected both printers", status, summaries)
+	}
+
+	if summary := summaries[0]; summary.Name != "mk4" || summary.Model != "MK4" || !summary.Online || summary.State != "IDLE" || summary.LastSeen.IsZero() || summary.Temperature.Bed != 20.1 {
+		t.Errorf("summary = %+v, expected online idle MK4", summary)
+	}
+
+	if summary := summaries[1]; summary.Name != "xl" || summary.Location != "lab" || summary.Online || summary.State != "OFFLINE" || !summary.LastSeen.IsZero() || summary.LastError == nil || !strings.HasPrefix(summary.LastError.Message, "job endpoint") {
+		t.Errorf("summary = %+v, expected offline XL never seen with job endpoint error", summary)
+	}
+
+	for _, path := range []string{PrintersPath + "/mk4", PrintersPath + "/" + mk4 + "/"} {
+		var summary prusalink.Summary
+		if status := getSummaries(t, handler, path, &summary); status != http.StatusOK || summary.Name != "mk4" || summary.Address != mk4 {
+			t.Errorf("%s: status = %d, summary = %+v, expected mk4", path, status, summary)
+		}
+	}
+
+	if status := getSummaries(t, handler, PrintersPath+"/mini", nil); status != http.StatusNotFound {
+		t.Errorf("status of unknown printer = %d, expected %d", status, http.StatusNotFound)
+	}
+}
+
+func TestPrintersHandlerNotPolled(t *testing.T) {
+	printers := []config.Printers{{Address: "192.168.1.10", Name: "xl", Type: "XL"}}
+	handler := PrintersHandler(printers, prusalink.NewPoller(config.Config{Printers: printers}))
+
+	var summary prusalink.Summary
+	if status := getSummaries(t, handler, PrintersPath+"/xl", &summary); status != http.StatusOK || summary.Online || summary.State != "OFFLINE" || summary.Model != "XL" {
+		t.Errorf("status = %d, summary = %+v, expected offline printer", status, summary)
+	}
+}
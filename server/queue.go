@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pstrobl96/prusa_exporter/queue"
+	"github.com/rs/zerolog/log"
+)
+
+// QueuePath is the path of print queue - GET lists jobs filtered by state parameters, POST submits gcode, GET and DELETE /api/queue/<id> returns and cancels single job
+const QueuePath = "/api/queue"
+
+// QueueHandler returns handler of the print queue, every request has to carry one of the tokens
+func QueueHandler(scheduler *queue.Scheduler, tokens map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := findUser(r, tokens)
+
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		target := strings.Trim(strings.TrimPrefix(r.URL.Path, QueuePath), "/")
+
+		if target == "" {
+			switch r.Method {
+			case http.MethodGet:
+				listQueue(w, r, scheduler)
+			case http.MethodPost:
+				submitQueue(w, r, scheduler, user)
+			default:
+				http.Error(w, "expected GET or POST", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		id, err := strconv.ParseUint(target, 10, 64)
+
+		if err != nil {
+			http.Error(w, "invalid job id "+target, http.StatusBadRequest)
+			return
+		}
+
+		var job queue.Job
+
+		switch r.Method {
+		case http.MethodGet:
+			job, err = scheduler.Get(id)
+		case http.MethodDelete:
+			job, err = scheduler.Cancel(id)
+			if err == nil {
+				log.Info().Str("user", user).Uint64("job", id).Msg("Queued job cancelled")
+			}
+		default:
+			http.Error(w, "expected GET or DELETE", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch {
+		case errors.Is(err, queue.ErrNotFound):
+			http.Error(w, "job "+target+" not found", http.StatusNotFound)
+		case errors.Is(err, queue.ErrNotQueued):
+			http.Error(w, "job "+target+" is not queued", http.StatusConflict)
+		case err != nil:
+			log.Error().Msg("Error while accessing print queue - " + err.Error())
+			http.Error(w, "queue is not available", http.StatusInternalServerError)
+		default:
+			writeJSON(w, job)
+		}
+	}
+}
+
+// listQueue writes jobs in the requested states
+func listQueue(w http.ResponseWriter, r *http.Request, scheduler *queue.Scheduler) {
+	states := []string{}
+	for _, state := range r.URL.Query()["state"] {
+		states = append(states, strings.ToUpper(state))
+	}
+
+	jobs, err := scheduler.List(states...)
+
+	if err != nil {
+		log.Error().Msg("Error while reading print queue - " + err.Error())
+		http.Error(w, "queue is not available", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, jobs)
+}
+
+// submitQueue adds request body as new job, requirements are read from model, nozzle and material parameters
+func submitQueue(w http.ResponseWriter, r *http.Request, scheduler *queue.Scheduler, user string) {
+	query := r.URL.Query()
+	job := queue.Job{
+		File: query.Get("file"),
+		User: user,
+		Requirements: queue.Requirements{
+			Model:    query.Get("model"),
+			Material: query.Get("material"),
+		},
+	}
+
+	if nozzle := query.Get("nozzle"); nozzle != "" {
+		diameter, err := strconv.ParseFloat(nozzle, 64)
+
+		if err != nil {
+			http.Error(w, "invalid nozzle parameter - "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		job.Requirements.NozzleDiameter = diameter
+	}
+
+	if job.File == "" {
+		http.Error(w, "file parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	job, err := scheduler.Submit(job, r.Body)
+
+	if err != nil {
+		log.Error().Msg("Error while adding job to print queue - " + err.Error())
+		http.Error(w, "job was not queued - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Str("user", user).Uint64("job", job.ID).Str("file", job.File).Msg("Job queued")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, job)
+}
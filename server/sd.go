@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// TargetGroup is a single target group in Prometheus HTTP SD format
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// GetTargetGroups returns one target group per printer pointing to the exporter probe endpoint
+func GetTargetGroups(printers []config.Printers, exporterAddress string, probePath string) []TargetGroup {
+	groups := []TargetGroup{}
+
+	for _, printer := range printers {
+		groups = append(groups, TargetGroup{
+			Targets: []string{exporterAddress},
+			Labels: map[string]string{
+				"__param_target":   printer.Address,
+				"__metrics_path__": probePath,
+				"name":             printer.Name,
+				"type":             printer.Type,
+				"location":         printer.Location,
+			},
+		})
+	}
+
+	return groups
+}
+
+// SDHandler returns handler exposing the printers for Prometheus http_sd_configs
+func SDHandler(printers []config.Printers, probePath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(GetTargetGroups(printers, r.Host, probePath)); err != nil {
+			log.Error().Msg("Error while encoding service discovery response - " + err.Error())
+		}
+	}
+}
+
+// ProbeHandler returns handler scraping only the printer given by the target parameter
+func ProbeHandler(printers []config.Printers, poller *prusalink.Poller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		printer, ok := findPrinter(printers, target)
+
+		if !ok {
+			http.Error(w, "target "+target+" is not configured", http.StatusNotFound)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prusalink.NewProbeCollector(printer, poller))
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// findPrinter returns configured printer by its address or name - unknown targets are never scraped
+func findPrinter(printers []config.Printers, target string) (config.Printers, bool) {
+	for _, printer := range printers {
+		if printer.Address == target || (printer.Name != "" && printer.Name == target) {
+			return printer, true
+		}
+	}
+
+	return config.Printers{}, false
+}
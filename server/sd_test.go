@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// sdPrinters are printers used by service discovery tests
+var sdPrinters = []config.Printers{
+	{Address: "192.168.1.10", Name: "xl", Type: "XL", Location: "lab"},
+	{Address: "192.168.1.11", Type: "MK4"},
+}
+
+func TestSDHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	SDHandler(sdPrinters, "/probe")(recorder, httptest.NewRequest("GET", "http://exporter:10009/sd", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, expected application/json", contentType)
+	}
+
+	var groups []TargetGroup
+	if err := json.Unmarshal(recorder.Body.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []TargetGroup{
+		{Targets: []string{"exporter:10009"}, Labels: map[string]string{"__param_target": "192.168.1.10", "__metrics_path__": "/probe", "name": "xl", "type": "XL", "location": "lab"}},
+		{Targets: []string{"exporter:10009"}, Labels: map[string]string{"__param_target": "192.168.1.11", "__metrics_path__": "/probe", "name": "", "type": "MK4", "location": ""}},
+	}
+
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("target groups = %+v, expected %+v", groups, expected)
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	handler := ProbeHandler(sdPrinters, prusalink.NewPoller(config.Config{Printers: sdPrinters}))
+
+	tests := []struct {
+		name    string
+		target  string
+		status  int
+		address string
+	}{
+		{"by name", "?target=xl", http.StatusOK, "192.168.1.10"},
+		{"by address", "?target=192.168.1.11", http.StatusOK, "192.168.1.11"},
+		{"missing target", "", http.StatusBadRequest, ""},
+		{"unknown target", "?target=mk3", http.StatusNotFound, ""},
+		{"empty target", "?target=", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("GET", "/probe"+test.target, nil))
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status = %d, expected %d", test.name, recorder.Code, test.status)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+			t.Errorf("%s: Content-Type = %q, expected text format", test.name, contentType)
+		}
+
+		body, _ := io.ReadAll(recorder.Body)
+		if !strings.Contains(string(body), `prusa_up{printer_address="`+test.address+`"`) {
+			t.Errorf("%s: probe does not contain prusa_up of %s:\n%s", test.name, test.address, body)
+		}
+
+		for _, printer := range sdPrinters {
+			if printer.Address != test.address && strings.Contains(string(body), `printer_address="`+printer.Address+`"`) {
+				t.Errorf("%s: probe contains metrics of other printer %s", test.name, printer.Address)
+			}
+		}
+	}
+}
+
+func TestFindPrinter(t *testing.T) {
+	if printer, ok := findPrinter(sdPrinters, "xl"); !ok || printer.Address != "192.168.1.10" {
+		t.Errorf("findPrinter(xl) = %v, %t, expected printer 192.168.1.10", printer, ok)
+	}
+
+	// printer without name must not match empty target
+	if printer, ok := findPrinter(sdPrinters, ""); ok {
+		t.Errorf("findPrinter(\"\") = %v, expected no printer", printer)
+	}
+}
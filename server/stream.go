@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// StreamPath is the path of server-sent events stream of printer summaries
+const StreamPath = "/api/stream"
+
+const (
+	streamHistory   = 1024             // number of events kept for clients reconnecting with Last-Event-ID
+	streamHeartbeat = 15 * time.Second // interval of comments keeping idle connections open
+)
+
+// streamEvent is a single event of the stream - snapshot contains full summary, diff contains JSON merge patch of the previous summary
+type streamEvent struct {
+	ID      uint64
+	Type    string
+	Printer string
+	Address string
+	Data    []byte
+}
+
+// Stream keeps the last summary of every printer and sends its changes to connected clients
+type Stream struct {
+	printers    []config.Printers
+	sequence    uint64
+	summaries   map[string]map[string]any // the last summary of printer by address
+	history     []streamEvent
+	subscribers map[chan streamEvent]struct{}
+	mutex       sync.Mutex
+}
+
+// NewStream returns a new Stream, HandleSnapshot has to be subscribed to the poller
+// event ids start at current time, so ids from before restart are not mistaken for new ones
+func NewStream(printers []config.Printers) *Stream {
+	return &Stream{
+		printers:    printers,
+		sequence:    uint64(time.Now().UnixMilli()) * 1000,
+		summaries:   map[string]map[string]any{},
+		subscribers: map[chan streamEvent]struct{}{},
+	}
+}
+
+// HandleSnapshot sends changes of the printer summary to clients, it's meant to be subscribed to the poller
+func (stream *Stream) HandleSnapshot(_ prusalink.Snapshot, snapshot prusalink.Snapshot) {
+	printerSummary := prusalink.GetSummary(snapshot)
+	summary, err := toMap(printerSummary)
+
+	if err != nil {
+		log.Error().Msg("Error while encoding stream summary of " + snapshot.Config.Address + " - " + err.Error())
+		return
+	}
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	address := snapshot.Config.Address
+	previous, known := stream.summaries[address]
+	stream.summaries[address] = summary
+
+	event := streamEvent{Type: "snapshot", Printer: printerSummary.Name, Address: address}
+	payload := map[string]any{"printer": event.Printer, "address": address}
+
+	if known {
+		patch := mergePatch(previous, summary)
+		if len(patch) == 0 {
+			return
+		}
+		event.Type = "diff"
+		payload["patch"] = patch
+	} else {
+		payload["summary"] = summary
+	}
+
+	event.Data, _ = json.Marshal(payload)
+
+	stream.sequence++
+	event.ID = stream.sequence
+
+	stream.history = append(stream.history, event)
+	if len(stream.history) > streamHistory {
+		stream.history = stream.history[len(stream.history)-streamHistory:]
+	}
+
+	for subscriber := range stream.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// client is too slow, it's disconnected and resumes from its last event id
+			close(subscriber)
+			delete(stream.subscribers, subscriber)
+		}
+	}
+}
+
+// Handler returns handler of the stream, clients are filtered by printer parameters with names or addresses
+// clients reconnecting with Last-Event-ID get missed events, other clients start with full snapshot of every printer
+func (stream *Stream) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		filter := map[string]bool{}
+		for _, target := range r.URL.Query()["printer"] {
+			printer, ok := findPrinter(stream.printers, target)
+			if !ok {
+				http.Error(w, "printer "+target+" is not configured", http.StatusNotFound)
+				return
+			}
+			filter[printer.Address] = true
+		}
+
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("last_event_id")
+		}
+
+		initial, subscriber := stream.subscribe(lastEventID)
+		defer stream.unsubscribe(subscriber)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		for _, event := range initial {
+			writeStreamEvent(w, event, filter)
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case event, ok := <-subscriber:
+				if !ok {
+					return
+				}
+				writeStreamEvent(w, event, filter)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// subscribe returns events the client has to receive first and channel of new events
+func (stream *Stream) subscribe(lastEventID string) ([]streamEvent, chan streamEvent) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	subscriber := make(chan streamEvent, 64)
+	stream.subscribers[subscriber] = struct{}{}
+
+	if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil && len(stream.history) > 0 && id >= stream.history[0].ID-1 && id <= stream.sequence {
+		index, _ := slices.BinarySearchFunc(stream.history, id+1, func(event streamEvent, id uint64) int {
+			return int(event.ID - id)
+		})
+		return slices.Clone(stream.history[index:]), subscriber
+	}
+
+	// full snapshots share the current id, so client reconnecting with it gets only newer events
+	initial := []streamEvent{}
+
+	for _, printer := range stream.printers {
+		summary, ok := stream.summaries[printer.Address]
+		if !ok {
+			continue
+		}
+
+		data, _ := json.Marshal(map[string]any{"printer": summary["name"], "address": printer.Address, "summary": summary})
+		initial = append(initial, streamEvent{ID: stream.sequence, Type: "snapshot", Printer: printer.Name, Address: printer.Address, Data: data})
+	}
+
+	return initial, subscriber
+}
+
+// unsubscribe stops sending events to the channel
+func (stream *Stream) unsubscribe(subscriber chan streamEvent) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if _, ok := stream.subscribers[subscriber]; ok {
+		delete(stream.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// writeStreamEvent writes the event in server-sent events format when it passes the filter
+func writeStreamEvent(w http.ResponseWriter, event streamEvent, filter map[string]bool) {
+	if len(filter) > 0 && !filter[event.Address] {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
+
+// toMap returns value converted to generic JSON map, so it can be compared field by field
+func toMap(value any) (map[string]any, error) {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]any
+	err = json.Unmarshal(data, &result)
+
+	return result, err
+}
+
+// mergePatch returns JSON merge patch (RFC 7386) transforming previous into current - changed fields with new values, removed fields as null
+func mergePatch(previous map[string]any, current map[string]any) map[string]any {
+	patch := map[string]any{}
+
+	for key, value := range current {
+		old, ok := previous[key]
+
+		if !ok {
+			patch[key] = value
+			continue
+		}
+
+		oldMap, oldIsMap := old.(map[string]any)
+		newMap, newIsMap := value.(map[string]any)
+
+		if oldIsMap && newIsMap {
+			if nested := mergePatch(oldMap, newMap); len(nested) > 0 {
+				patch[key] = nested
+			}
+		} else if !reflect.DeepEqual(old, value) {
+			patch[key] = value
+		}
+	}
+
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			patch[key] = nil
+		}
+	}
+
+	return patch
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		patch    string
+	}{
+		{"unchanged", `{"a":1,"b":{"c":2}}`, `{"a":1,"b":{"c":2}}`, `{}`},
+		{"changed value", `{"a":1,"b":"x"}`, `{"a":2,"b":"x"}`, `{"a":2}`},
+		{"nested change", `{"t":{"nozzle":200,"bed":60}}`, `{"t":{"nozzle":215,"bed":60}}`, `{"t":{"nozzle":215}}`},
+		{"removed field", `{"a":1,"job":{"id":7}}`, `{"a":1}`, `{"job":null}`},
+		{"removed nested field", `{"t":{"nozzle":200,"chamber":30}}`, `{"t":{"nozzle":200}}`, `{"t":{"chamber":null}}`},
+		{"added field", `{"a":1}`, `{"a":1,"job":{"id":7}}`, `{"job":{"id":7}}`},
+		{"object replaced by value", `{"a":{"b":1}}`, `{"a":3}`, `{"a":3}`},
+		{"arrays are replaced", `{"a":[1,2]}`, `{"a":[1,3]}`, `{"a":[1,3]}`},
+	}
+
+	for _, test := range tests {
+		var previous, current, expected map[string]any
+		json.Unmarshal([]byte(test.previous), &previous)
+		json.Unmarshal([]byte(test.current), &current)
+		json.Unmarshal([]byte(test.patch), &expected)
+
+		if patch := mergePatch(previous, current); !reflect.DeepEqual(patch, expected) {
+			t.Errorf("%s: mergePatch() = %v, expected %v", test.name, patch, expected)
+		}
+	}
+}
+
+// streamSnapshot returns snapshot of the printer with given nozzle temperature
+func streamSnapshot(nozzle float64) prusalink.Snapshot {
+	snapshot := prusalink.Snapshot{
+		Config: config.Printers{Address: "192.168.1.10", Name: "xl", Type: "XL"},
+		Up:     true,
+		Time:   time.Now(),
+	}
+	snapshot.Status.Printer.State = "PRINTING"
+	snapshot.Status.Printer.TempNozzle = nozzle
+
+	return snapshot
+}
+
+// newTestStream returns stream with snapshot event followed by two diffs
+func newTestStream(t *testing.T) (*Stream, []uint64) {
+	t.Helper()
+
+	stream := NewStream([]config.Printers{{Address: "192.168.1.10", Name: "xl"}})
+
+	for _, nozzle := range []float64{200, 210, 210, 215} {
+		stream.HandleSnapshot(prusalink.Snapshot{}, streamSnapshot(nozzle))
+	}
+
+	ids := []uint64{}
+	for _, event := range stream.history {
+		ids = append(ids, event.ID)
+	}
+
+	if len(ids) != 3 || stream.history[0].Type != "snapshot" || stream.history[1].Type != "diff" {
+		t.Fatalf("history = %v, expected snapshot and two diffs, unchanged summary is not sent", stream.history)
+	}
+
+	return stream, ids
+}
+
+func TestStreamResume(t *testing.T) {
+	stream, ids := newTestStream(t)
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		ids         []uint64
+		types       []string
+	}{
+		{"missed events", strconv.FormatUint(ids[0], 10), ids[1:], []string{"diff", "diff"}},
+		{"before the first event", strconv.FormatUint(ids[0]-1, 10), ids, []string{"snapshot", "diff", "diff"}},
+		{"up to date", strconv.FormatUint(ids[2], 10), nil, nil},
+		{"new client", "", []uint64{ids[2]}, []string{"snapshot"}},
+		{"expired id", strconv.FormatUint(ids[0]-2, 10), []uint64{ids[2]}, []string{"snapshot"}},
+		{"id from future", strconv.FormatUint(ids[2]+1, 10), []uint64{ids[2]}, []string{"snapshot"}},
+		{"invalid id", "abc", []uint64{ids[2]}, []string{"snapshot"}},
+	}
+
+	for _, test := range tests {
+		initial, subscriber := stream.subscribe(test.lastEventID)
+		stream.unsubscribe(subscriber)
+
+		gotIDs := []uint64{}
+		gotTypes := []string{}
+		for _, event := range initial {
+			gotIDs = append(gotIDs, event.ID)
+			gotTypes = append(gotTypes, event.Type)
+		}
+
+		if len(gotIDs) != len(test.ids) || (len(gotIDs) > 0 && (!reflect.DeepEqual(gotIDs, test.ids) || !reflect.DeepEqual(gotTypes, test.types))) {
+			t.Errorf("%s: events %v %v, expected %v %v", test.name, gotIDs, gotTypes, test.ids, test.types)
+		}
+	}
+}
+
+func TestStreamHandlerResume(t *testing.T) {
+	stream, ids := newTestStream(t)
+
+	server := httptest.NewServer(stream.Handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"?printer=xl", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(ids[0], 10))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type = %q, expected text/event-stream", contentType)
+	}
+
+	// the next change is sent live after missed events
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		stream.HandleSnapshot(prusalink.Snapshot{}, streamSnapshot(220))
+	}()
+
+	scanner := bufio.NewScanner(res.Body)
+	received := []string{}
+	var patch map[string]any
+
+	for patch == nil && scanner.Scan() {
+		line := scanner.Text()
+
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			received = append(received, id)
+		}
+
+		if data, ok := strings.CutPrefix(line, "data: "); ok && len(received) == 3 {
+			json.Unmarshal([]byte(data), &patch)
+		}
+	}
+
+	expected := []string{strconv.FormatUint(ids[1], 10), strconv.FormatUint(ids[2], 10), strconv.FormatUint(ids[2]+1, 10)}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("received ids %v, expected %v", received, expected)
+	}
+
+	if patch["printer"] != "xl" || patch["patch"].(map[string]any)["temperature"].(map[string]any)["nozzle"] != 220.0 {
+		t.Errorf("live event = %v, expected nozzle temperature patch", patch)
+	}
+}
+
+func TestStreamUnknownPrinter(t *testing.T) {
+	stream := NewStream([]config.Printers{{Address: "192.168.1.10", Name: "xl"}})
+
+	recorder := httptest.NewRecorder()
+	stream.Handler()(recorder, httptest.NewRequest("GET", StreamPath+"?printer=mk4", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status = %d, expected 404", recorder.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// UIEventsPath is the path of server-sent events refreshing the status page
+const UIEventsPath = "/ui/events"
+
+//go:embed ui/index.html
+var uiTemplateText string
+
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"duration":  formatDuration,
+	"clock":     func(t time.Time) string { return t.Local().Format("15:04") },
+	"timestamp": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+	"lower":     strings.ToLower,
+	"value":     func(value *float64) float64 { return *value },
+	"thumbnail": func(image string) template.URL { return template.URL("data:image/png;base64," + image) },
+}).Parse(uiTemplateText))
+
+// uiCard is a struct that contains data of single printer card
+type uiCard struct {
+	ID        string
+	Summary   prusalink.Summary
+	Thumbnail string // base64 encoded PNG, only while printing
+}
+
+// UIHandler returns handler of the status page with a card per configured printer
+func UIHandler(printers []config.Printers, poller *prusalink.Poller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		cards := []uiCard{}
+		for _, printer := range printers {
+			snapshot, ok := poller.Snapshot(printer.Address)
+			if !ok {
+				snapshot = prusalink.Snapshot{Config: printer}
+			}
+			cards = append(cards, newUICard(snapshot))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if err := uiTemplate.ExecuteTemplate(w, "page", map[string]any{"Cards": cards, "EventsPath": UIEventsPath}); err != nil {
+			log.Error().Msg("Error while rendering status page - " + err.Error())
+		}
+	}
+}
+
+// UIEventsHandler returns handler sending rendered printer cards as server-sent events after every poll
+func UIEventsHandler(broadcaster *Broadcaster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		subscriber := broadcaster.subscribe()
+		defer broadcaster.unsubscribe(subscriber)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case snapshot := <-subscriber:
+				var card strings.Builder
+
+				if err := uiTemplate.ExecuteTemplate(&card, "card", newUICard(snapshot)); err != nil {
+					log.Error().Msg("Error while rendering printer card - " + err.Error())
+					continue
+				}
+
+				fmt.Fprint(w, "event: card\n")
+				for _, line := range strings.Split(card.String(), "\n") {
+					fmt.Fprint(w, "data: "+line+"\n")
+				}
+				fmt.Fprint(w, "\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// newUICard returns card of the printer snapshot
+func newUICard(snapshot prusalink.Snapshot) uiCard {
+	id := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, snapshot.Config.Address)
+
+	return uiCard{
+		ID:        "printer-" + id,
+		Summary:   prusalink.GetSummary(snapshot),
+		Thumbnail: snapshot.JobImage,
+	}
+}
+
+// formatDuration returns seconds formatted as hours and minutes
+func formatDuration(seconds float64) string {
+	duration := time.Duration(seconds) * time.Second
+
+	if duration < time.Hour {
+		return fmt.Sprintf("%dm", int(duration.Minutes()))
+	}
+
+	return fmt.Sprintf("%dh %02dm", int(duration.Hours()), int(duration.Minutes())%60)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+)
+
+// cardIDs matches ids of printer cards
+var cardIDs = regexp.MustCompile(`<section class="card" id="([^"]+)">`)
+
+func TestUIHandler(t *testing.T) {
+	mk4 := fixturePrinter(t, map[string]string{
+		"/api/job":       "job.json",
+		"/api/printer":   "printer.json",
+		"/api/version":   "version.json",
+		"/api/v1/status": "v1/status.json",
+	})
+
+	printers := []config.Printers{
+		{Address: mk4, Name: "mk4", Type: "MK4", ScrapeTimeout: model.Duration(time.Second), PollInterval: model.Duration(time.Hour)},
+		{Address: "192.168.1.10", Name: "xl", Type: "XL"}, // not polled yet
+	}
+	poller := startPoller(t, printers[:1])
+	handler := UIHandler(printers, poller)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("status = %d, Content-Type = %q, expected HTML page", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	page := recorder.Body.String()
+	ids := cardIDs.FindAllStringSubmatch(page, -1)
+
+	if len(ids) != 2 || ids[0][1] != newUICard(prusalink.Snapshot{Config: printers[0]}).ID || ids[1][1] != "printer-192-168-1-10" {
+		t.Errorf("cards = %v, expected card per configured printer in order", ids)
+	}
+
+	for _, expected := range []string{"<h2>mk4</h2>", `<span class="state idle">IDLE</span>`, "<h2>xl</h2>", `<span class="state offline">OFFLINE</span>`, `new EventSource("\/ui\/events")`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("page does not contain %q", expected)
+		}
+	}
+
+	for _, path := range []string{"/index.html", "/favicon.ico", "/ui"} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("GET", path, nil))
+
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, expected %d", path, recorder.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestUIEventsHandler(t *testing.T) {
+	broadcaster := NewBroadcaster()
+
+	server := httptest.NewServer(UIEventsHandler(broadcaster))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type = %q, expected text/event-stream", contentType)
+	}
+
+	// client is subscribed once headers are sent
+	snapshot := streamSnapshot(215)
+	broadcaster.HandleSnapshot(prusalink.Snapshot{}, snapshot)
+
+	scanner := bufio.NewScanner(res.Body)
+	event := ""
+	data := []string{}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			event = value
+		} else if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, value)
+		} else {
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+
+	if event != "card" || len(data) < 2 {
+		t.Fatalf("event %q with %d data lines, expected multi-line card", event, len(data))
+	}
+
+	card := strings.Join(data, "\n")
+
+	var expected strings.Builder
+	if err := uiTemplate.ExecuteTemplate(&expected, "card", newUICard(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+
+	if card != expected.String() {
+		t.Errorf("card = %q, expected %q", card, expected.String())
+	}
+
+	// card replaces the card of the same printer on the page
+	recorder := httptest.NewRecorder()
+	UIHandler([]config.Printers{snapshot.Config}, prusalink.NewPoller(config.Config{}))(recorder, httptest.NewRequest("GET", "/", nil))
+
+	pageIDs := cardIDs.FindAllStringSubmatch(recorder.Body.String(), -1)
+	eventIDs := cardIDs.FindAllStringSubmatch(card, -1)
+
+	if len(pageIDs) != 1 || len(eventIDs) != 1 || pageIDs[0][1] != eventIDs[0][1] {
+		t.Errorf("event card %v, expected id of page card %v", eventIDs, pageIDs)
+	}
+}
+
+func TestNewUICard(t *testing.T) {
+	tests := []struct {
+		address string
+		id      string
+	}{
+		{"192.168.1.10", "printer-192-168-1-10"},
+		{"prusa-xl.local:8080", "printer-prusa-xl-local-8080"},
+		{"[fe80::1]", "printer--fe80--1-"},
+	}
+
+	for _, test := range tests {
+		if card := newUICard(prusalink.Snapshot{Config: config.Printers{Address: test.address}}); card.ID != test.id {
+			t.Errorf("newUICard(%s).ID = %q, expected %q", test.address, card.ID, test.id)
+		}
+	}
+
+	snapshot := streamSnapshot(215)
+	snapshot.JobImage = "iVBORw0KGgo="
+
+	if card := newUICard(snapshot); card.Thumbnail != snapshot.JobImage || card.Summary.State != "PRINTING" {
+		t.Errorf("card = %+v, expected printing card with thumbnail", card)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		seconds  float64
+		expected string
+	}{
+		{0, "0m"},
+		{59, "0m"},
+		{3599, "59m"},
+		{3600, "1h 00m"},
+		{8460, "2h 21m"},
+		{90000, "25h 00m"},
+	}
+
+	for _, test := range tests {
+		if formatted := formatDuration(test.seconds); formatted != test.expected {
+			t.Errorf("formatDuration(%v) = %q, expected %q", test.seconds, formatted, test.expected)
+		}
+	}
+}
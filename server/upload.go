@@ -0,0 +1,217 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pstrobl96/prusa_exporter/config"
+	prusalink "github.com/pstrobl96/prusa_exporter/prusalink/buddy"
+	"github.com/rs/zerolog/log"
+)
+
+// FilesPath is the path prefix of file upload - PUT /api/files/<printer>/<storage>/<path>
+const FilesPath = "/api/files/"
+
+// UploadsPath is the path of upload progress
+const UploadsPath = "/api/uploads"
+
+const uploadsHistory = 50 // number of finished uploads kept in progress list
+
+// Upload is a struct that contains progress of single upload
+type Upload struct {
+	ID               int64      `json:"id"`
+	User             string     `json:"user"`
+	Printer          string     `json:"printer"`
+	Address          string     `json:"address"`
+	Storage          string     `json:"storage"`
+	Path             string     `json:"path"`
+	Size             int64      `json:"size"`
+	Sent             int64      `json:"sent"`
+	Progress         float64    `json:"progress"` // in percent
+	State            string     `json:"state"`    // UPLOADING, FINISHED or FAILED
+	Error            string     `json:"error,omitempty"`
+	PrintAfterUpload bool       `json:"print_after_upload"`
+	Started          time.Time  `json:"started"`
+	Finished         *time.Time `json:"finished,omitempty"`
+}
+
+// Uploads keeps progress of running and recently finished uploads
+type Uploads struct {
+	printers []config.Printers
+	tokens   map[string]string
+	sequence int64
+	uploads  []*Upload
+	mutex    sync.Mutex
+}
+
+// NewUploads returns a new Uploads, every request has to carry one of the tokens
+func NewUploads(printers []config.Printers, tokens map[string]string) *Uploads {
+	return &Uploads{printers: printers, tokens: tokens}
+}
+
+// Handler returns handler streaming request body to the printer, Content-Length is required for free space check
+func (uploads *Uploads) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := findUser(r, uploads.tokens)
+
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodPut {
+			http.Error(w, "expected PUT "+FilesPath+"<printer>/<storage>/<path>", http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, FilesPath), "/", 3)
+
+		if len(parts) != 3 || parts[1] == "" || strings.Trim(parts[2], "/") == "" {
+			http.Error(w, "expected path "+FilesPath+"<printer>/<storage>/<path>", http.StatusNotFound)
+			return
+		}
+
+		printer, ok := findPrinter(uploads.printers, parts[0])
+
+		if !ok {
+			http.Error(w, "printer "+parts[0]+" is not configured", http.StatusNotFound)
+			return
+		}
+
+		if r.ContentLength <= 0 {
+			http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+			return
+		}
+
+		upload := uploads.start(Upload{
+			User:             user,
+			Printer:          printer.Name,
+			Address:          printer.Address,
+			Storage:          parts[1],
+			Path:             parts[2],
+			Size:             r.ContentLength,
+			PrintAfterUpload: isTrue(r.Header.Get("Print-After-Upload")),
+		})
+
+		log.Info().Str("user", user).Str("printer", printer.Address).Str("path", parts[1]+"/"+parts[2]).Int64("size", r.ContentLength).Msg("Upload started")
+
+		err := prusalink.UploadFile(printer, parts[1], parts[2], r.Body, r.ContentLength, prusalink.UploadOptions{
+			PrintAfterUpload: upload.PrintAfterUpload,
+			Overwrite:        isTrue(r.Header.Get("Overwrite")),
+			Progress:         func(sent int64) { uploads.progress(upload.ID, sent) },
+		})
+
+		result := uploads.finish(upload.ID, err)
+
+		if err != nil {
+			log.Error().Msg("Error while uploading " + parts[1] + "/" + parts[2] + " to " + printer.Address + " - " + err.Error())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			writeJSON(w, result)
+			return
+		}
+
+		log.Info().Str("user", user).Str("printer", printer.Address).Str("path", parts[1]+"/"+parts[2]).Msg("Upload finished")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, result)
+	}
+}
+
+// ProgressHandler returns handler listing running and recently finished uploads, newest first
+func (uploads *Uploads) ProgressHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := findUser(r, uploads.tokens); !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		uploads.mutex.Lock()
+		list := []Upload{}
+		for _, upload := range slices.Backward(uploads.uploads) {
+			list = append(list, *upload)
+		}
+		uploads.mutex.Unlock()
+
+		writeJSON(w, list)
+	}
+}
+
+// start registers new upload and returns its copy with assigned id
+func (uploads *Uploads) start(upload Upload) Upload {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	uploads.sequence++
+	upload.ID = uploads.sequence
+	upload.State = "UPLOADING"
+	upload.Started = time.Now()
+
+	uploads.uploads = append(uploads.uploads, &upload)
+
+	// drop the oldest finished uploads, running uploads are always kept
+	for len(uploads.uploads) > uploadsHistory {
+		index := slices.IndexFunc(uploads.uploads, func(u *Upload) bool { return u.State != "UPLOADING" })
+		if index < 0 {
+			break
+		}
+		uploads.uploads = slices.Delete(uploads.uploads, index, index+1)
+	}
+
+	return upload
+}
+
+// progress updates number of bytes sent to the printer
+func (uploads *Uploads) progress(id int64, sent int64) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	if upload := uploads.find(id); upload != nil {
+		upload.Sent = sent
+		upload.Progress = float64(sent) / float64(upload.Size) * 100
+	}
+}
+
+// finish marks upload as finished or failed and returns its copy
+func (uploads *Uploads) finish(id int64, err error) Upload {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	upload := uploads.find(id)
+
+	if upload == nil {
+		return Upload{ID: id}
+	}
+
+	now := time.Now()
+	upload.Finished = &now
+	upload.State = "FINISHED"
+
+	if err != nil {
+		upload.State = "FAILED"
+		upload.Error = err.Error()
+	}
+
+	return *upload
+}
+
+// find returns upload by id, mutex has to be locked
+func (uploads *Uploads) find(id int64) *Upload {
+	for _, upload := range uploads.uploads {
+		if upload.ID == id {
+			return upload
+		}
+	}
+	return nil
+}
+
+// isTrue returns true for boolean header values - ?1 used by PrusaLink, 1 and true
+func isTrue(value string) bool {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "?")
+	parsed, err := strconv.ParseBool(value)
+	return err == nil && parsed
+}
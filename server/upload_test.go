@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/pstrobl96/prusa_exporter/config"
+)
+
+// uploadPrinters returns printer "mk4" accepting uploads to USB and printer "mini" with read only USB, uploaded files are stored in the map by path
+func uploadPrinters(t *testing.T) ([]config.Printers, map[string]string, *sync.Mutex) {
+	t.Helper()
+
+	files := map[string]string{}
+	mutex := &sync.Mutex{}
+
+	printer := func(readOnly bool) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/api/version":
+				w.Write([]byte(`{"api":"2.0.0","capabilities":{"upload-by-put":true}}`))
+			case r.URL.Path == "/api/v1/storage":
+				w.Write([]byte(`{"storage_list":[{"path":"/usb/","available":true,"read_only":` + map[bool]string{true: "true", false: "false"}[readOnly] + `}]}`))
+			case r.Method == "PUT":
+				body, _ := io.ReadAll(r.Body)
+				mutex.Lock()
+				files[r.URL.Path] = string(body) + " " + r.Header.Get("Print-After-Upload") + r.Header.Get("Overwrite")
+				mutex.Unlock()
+				w.WriteHeader(http.StatusCreated)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(server.Close)
+
+		return strings.TrimPrefix(server.URL, "http://")
+	}
+
+	return []config.Printers{
+		{Address: printer(false), Name: "mk4", ScrapeTimeout: model.Duration(time.Second)},
+		{Address: printer(true), Name: "mini", ScrapeTimeout: model.Duration(time.Second)},
+	}, files, mutex
+}
+
+func TestUploadsHandler(t *testing.T) {
+	printers, files, mutex := uploadPrinters(t)
+	uploads := NewUploads(printers, map[string]string{"alice": "secret"})
+	handler := uploads.Handler()
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		token         string
+		headers       map[string]string
+		contentLength int64 // -1 for unknown length, body length is used otherwise
+		status        int
+		state         string
+	}{
+		{"upload", "PUT", "mk4/usb/models/benchy.gcode", "secret", map[string]string{"Print-After-Upload": "?1", "Overwrite": "true"}, 0, http.StatusCreated, "FINISHED"},
+		{"upload by address", "PUT", printers[0].Address + "/usb/box.gcode", "secret", nil, 0, http.StatusCreated, "FINISHED"},
+		{"printer rejects upload", "PUT", "mini/usb/box.gcode", "secret", nil, 0, http.StatusBadGateway, "FAILED"},
+		{"missing token", "PUT", "mk4/usb/box.gcode", "", nil, 0, http.StatusUnauthorized, ""},
+		{"wrong token", "PUT", "mk4/usb/box.gcode", "other", nil, 0, http.StatusUnauthorized, ""},
+		{"wrong method", "POST", "mk4/usb/box.gcode", "secret", nil, 0, http.StatusMethodNotAllowed, ""},
+		{"missing path", "PUT", "mk4/usb/", "secret", nil, 0, http.StatusNotFound, ""},
+		{"missing storage", "PUT", "mk4//box.gcode", "secret", nil, 0, http.StatusNotFound, ""},
+		{"unknown printer", "PUT", "xl/usb/box.gcode", "secret", nil, 0, http.StatusNotFound, ""},
+		{"unknown length", "PUT", "mk4/usb/box.gcode", "secret", nil, -1, http.StatusLengthRequired, ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, FilesPath+test.path, strings.NewReader("G28"))
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+		if test.contentLength != 0 {
+			r.ContentLength = test.contentLength
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, r)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status = %d, expected %d - %s", test.name, recorder.Code, test.status, recorder.Body.String())
+			continue
+		}
+
+		if test.state == "" {
+			continue
+		}
+
+		var upload Upload
+		if err := json.Unmarshal(recorder.Body.Bytes(), &upload); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if upload.State != test.state || upload.User != "alice" || upload.Size != 3 || upload.Finished == nil {
+			t.Errorf("%s: upload = %+v, expected %s upload of alice", test.name, upload, test.state)
+		}
+
+		if test.state == "FINISHED" && (upload.Sent != 3 || upload.Progress != 100) {
+			t.Errorf("%s: sent = %d, progress = %v, expected whole file", test.name, upload.Sent, upload.Progress)
+		}
+
+		if test.state == "FAILED" && upload.Error != "storage usb is read only" {
+			t.Errorf("%s: error = %q, expected error of the printer", test.name, upload.Error)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected := map[string]string{
+		"/api/v1/files/usb/models/benchy.gcode": "G28 ?1?1",
+		"/api/v1/files/usb/box.gcode":           "G28 ?0?0",
+	}
+
+	if len(files) != len(expected) {
+		t.Errorf("files = %v, expected %v", files, expected)
+	}
+
+	for path, content := range expected {
+		if files[path] != content {
+			t.Errorf("%s = %q, expected %q", path, files[path], content)
+		}
+	}
+}
+
+func TestUploadsProgressHandler(t *testing.T) {
+	printers, _, _ := uploadPrinters(t)
+	uploads := NewUploads(printers, map[string]string{"alice": "secret"})
+
+	finished := uploads.start(Upload{Printer: "mk4", Path: "first.gcode", Size: 10})
+	uploads.progress(finished.ID, 10)
+	uploads.finish(finished.ID, nil)
+
+	running := uploads.start(Upload{Printer: "mk4", Path: "second.gcode", Size: 200})
+	uploads.progress(running.ID, 50)
+
+	recorder := httptest.NewRecorder()
+	uploads.ProgressHandler()(recorder, httptest.NewRequest("GET", UploadsPath, nil))
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status without token = %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	r := httptest.NewRequest("GET", UploadsPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	uploads.ProgressHandler()(recorder, r)
+
+	var list []Upload
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0].Path != "second.gcode" || list[1].Path != "first.gcode" {
+		t.Fatalf("uploads = %+v, expected newest first", list)
+	}
+
+	if list[0].State != "UPLOADING" || list[0].Sent != 50 || list[0].Progress != 25 {
+		t.Errorf("running upload = %+v, expected 25 %% uploaded", list[0])
+	}
+
+	if list[1].State != "FINISHED" || list[1].Progress != 100 {
+		t.Errorf("finished upload = %+v, expected finished", list[1])
+	}
+}
+
+func TestUploadsHistory(t *testing.T) {
+	uploads := NewUploads(nil, nil)
+
+	running := uploads.start(Upload{Path: "running.gcode"})
+
+	for range uploadsHistory + 10 {
+		upload := uploads.start(Upload{Path: "finished.gcode"})
+		uploads.finish(upload.ID, nil)
+	}
+
+	if len(uploads.uploads) != uploadsHistory {
+		t.Errorf("uploads = %d, expected %d", len(uploads.uploads), uploadsHistory)
+	}
+
+	if uploads.uploads[0].ID != running.ID {
+		t.Errorf("the oldest upload = %+v, expected running upload to be kept", uploads.uploads[0])
+	}
+}